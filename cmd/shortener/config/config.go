@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // NetAddress - хост на котором будет доступен сервис.
@@ -49,23 +50,102 @@ type Settings struct {
 	SaveDBtoFile      bool
 	AddProfileRoute   bool
 	EnableTSL         bool
+	LengthShortURL    int           // длина генерируемых коротких ссылок
+	CodeGenerator     string        // режим генерации коротких ссылок: hash, random, sequential
+	Storage           string        // хранилище: memory, disk, postgres, пусто - по наличию DSN
+	DryRunMigrations  bool          // вывести неприменённые миграции БД и завершить работу
+	StorageTimeout    time.Duration // предельное время операций с хранилищем при обработке запроса
+	CacheSize         int           // размер кеша коротких ссылок, 0 - без кеша
+	CacheTTL          time.Duration // время жизни записи кеша
+	QuotaTotal        int           // всего ссылок одного пользователя, 0 - без ограничения
+	QuotaDaily        int           // ссылок одного пользователя за сутки, 0 - без ограничения
+	QuotaBatch        int           // ссылок в одном пакетном запросе, 0 - без ограничения
+	RateCreate        float64       // запросов создания ссылок в секунду от клиента, 0 - без ограничения
+	RateCreateBurst   int           // допустимый всплеск запросов создания ссылок
+	RateRedirect      float64       // переходов по ссылкам в секунду от клиента, 0 - без ограничения
+	RateRedirectBurst int           // допустимый всплеск переходов по ссылкам
+	RatePassword      float64       // попыток ввода пароля ссылки в секунду от клиента, 0 - без ограничения
+	RatePasswordBurst int           // допустимое количество попыток ввода пароля подряд
+	RateLimitKeys     int           // максимальное количество отслеживаемых клиентов
+	TrustedProxies    string        // адреса и сети обратных прокси через запятую, только им верится X-Real-IP
+	SortQuery         bool          // сортировать параметры запроса сокращаемых ссылок
+	PolicyFile        string        // файл правил deny/allow для адресов сокращаемых ссылок
+	AllowPrivate      bool          // разрешить ссылки на локальные и внутренние адреса
+	PreviewTemplates  string        // каталог шаблонов страницы предпросмотра, заменяющих встроенные
+	RedirectType      int           // код перенаправления для ссылок без собственного кода: 301, 302, 307 или 308
+	RedirectMaxAge    time.Duration // время кеширования постоянного перенаправления клиентом, 0 - без кеширования
+	QueryPassthrough  bool          // передавать параметры запроса перехода в оригинальную ссылку
+	QueryParams       string        // параметры, добавляемые при переходе, в виде запроса utm_source=short&utm_medium=link
+	QueryConflict     string        // при совпадении имен остается параметр link - ссылки или request - запроса перехода
+	ClickHashKey      string        // секрет хеширования адресов клиентов в статистике переходов, в String не выводится
+}
+
+// Виды хранилища ссылок.
+const (
+	StorageMemory   = "memory"   // в памяти с журналом в файле -f
+	StorageDisk     = "disk"     // лог с индексом в локальном файле
+	StoragePostgres = "postgres" // БД Postgres по строке подключения -d
+)
+
+// StorageKind - выбранное хранилище, по умолчанию Postgres при заданном DSN, иначе в памяти.
+func (s Settings) StorageKind() string {
+	if s.Storage != "" {
+		return s.Storage
+	}
+	if s.DatabaseDSN != "" {
+		return StoragePostgres
+	}
+	return StorageMemory
 }
 
 // Метод String для структуры Settings
 func (s Settings) String() string {
 	return fmt.Sprintf(
-		"Settings:\n\tServiceNetAddress: %s\n\tBaseURL: %s\n\tFileStoragePath: %s\n\tDatabaseDSN: %s\n\tConfigNameFile: %s\n\tSaveDBtoFile: %v\n\tAddProfileRoute: %v\n\tEnableTSL: %v",
+		"Settings:\n\tServiceNetAddress: %s\n\tBaseURL: %s\n\tFileStoragePath: %s\n\tDatabaseDSN: %s\n\tConfigNameFile: %s\n\tSaveDBtoFile: %v\n\tAddProfileRoute: %v\n\tEnableTSL: %v\n\tLengthShortURL: %d\n\tCodeGenerator: %s\n\tStorageTimeout: %s\n\tStorage: %s\n\tCacheSize: %d\n\tCacheTTL: %s\n\tQuotaTotal: %d\n\tQuotaDaily: %d\n\tQuotaBatch: %d\n\tRateCreate: %g/%d\n\tRateRedirect: %g/%d\n\tRatePassword: %g/%d\n\tRateLimitKeys: %d\n\tTrustedProxies: %s\n\tSortQuery: %v\n\tPolicyFile: %s\n\tAllowPrivate: %v\n\tPreviewTemplates: %s\n\tRedirect: %d/%s\n\tQuery: passthrough=%v params=%s conflict=%s",
 		s.ServiceNetAddress, s.BaseURL, s.FileStoragePath, s.DatabaseDSN, s.ConfigNameFile, s.SaveDBtoFile, s.AddProfileRoute, s.EnableTSL,
+		s.LengthShortURL, s.CodeGenerator, s.StorageTimeout, s.StorageKind(), s.CacheSize, s.CacheTTL,
+		s.QuotaTotal, s.QuotaDaily, s.QuotaBatch,
+		s.RateCreate, s.RateCreateBurst, s.RateRedirect, s.RateRedirectBurst, s.RatePassword, s.RatePasswordBurst, s.RateLimitKeys,
+		s.TrustedProxies,
+		s.SortQuery, s.PolicyFile, s.AllowPrivate, s.PreviewTemplates, s.RedirectType, s.RedirectMaxAge,
+		s.QueryPassthrough, s.QueryParams, s.QueryConflict,
 	)
 }
 
 // Config - структура для хранения данных из JSON
 type ConfigJSON struct {
-	ServerAddress   string `json:"server_address"`
-	BaseURL         string `json:"base_url"`
-	FileStoragePath string `json:"file_storage_path"`
-	DatabaseDSN     string `json:"database_dsn"`
-	EnableHTTPS     bool   `json:"enable_https"`
+	ServerAddress     string  `json:"server_address"`
+	BaseURL           string  `json:"base_url"`
+	FileStoragePath   string  `json:"file_storage_path"`
+	DatabaseDSN       string  `json:"database_dsn"`
+	EnableHTTPS       bool    `json:"enable_https"`
+	LengthShortURL    int     `json:"short_url_length"`
+	CodeGenerator     string  `json:"code_generator"`
+	StorageTimeout    string  `json:"storage_timeout"`
+	Storage           string  `json:"storage"`
+	CacheSize         *int    `json:"cache_size"`
+	CacheTTL          string  `json:"cache_ttl"`
+	QuotaTotal        int     `json:"quota_total"`
+	QuotaDaily        int     `json:"quota_daily"`
+	QuotaBatch        int     `json:"quota_batch"`
+	RateCreate        float64 `json:"rate_create"`
+	RateCreateBurst   int     `json:"rate_create_burst"`
+	RateRedirect      float64 `json:"rate_redirect"`
+	RateRedirectBurst int     `json:"rate_redirect_burst"`
+	RatePassword      float64 `json:"rate_password"`
+	RatePasswordBurst int     `json:"rate_password_burst"`
+	RateLimitKeys     int     `json:"rate_limit_keys"`
+	TrustedProxies    string  `json:"trusted_proxies"`
+	SortQuery         bool    `json:"sort_query"`
+	PolicyFile        string  `json:"policy_file"`
+	AllowPrivate      bool    `json:"allow_private"`
+	PreviewTemplates  string  `json:"preview_templates"`
+	RedirectType      int     `json:"redirect_type"`
+	RedirectMaxAge    string  `json:"redirect_max_age"`
+	QueryPassthrough  bool    `json:"query_passthrough"`
+	QueryParams       string  `json:"query_params"`
+	QueryConflict     string  `json:"query_conflict"`
+	ClickHashKey      string  `json:"click_hash_key"`
 }
 
 // ParseConfig - функция для парсинга JSON-файла
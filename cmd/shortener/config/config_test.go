@@ -19,3 +19,11 @@ func TestNetAddress(t *testing.T) {
 	assert.Equal(t, 9999, netAnotherAddress.Port)
 
 }
+
+func TestStorageKind(t *testing.T) {
+
+	assert.Equal(t, StorageMemory, Settings{}.StorageKind())
+	assert.Equal(t, StoragePostgres, Settings{DatabaseDSN: "postgres://localhost/urlservice"}.StorageKind())
+	assert.Equal(t, StorageDisk, Settings{DatabaseDSN: "postgres://localhost/urlservice", Storage: StorageDisk}.StorageKind())
+
+}
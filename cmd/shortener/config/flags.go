@@ -7,12 +7,24 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Настройки по умолчанию.
 const (
 	baseURL         = "http://localhost:8080" // базовый адрес хоста сервиса
 	fileStoragePath = "shorturls.data"        // путь к файлу хранилища ссылок
+	lengthShortURL  = 10                      // длина генерируемых коротких ссылок
+	codeGenerator   = "hash"                  // режим генерации коротких ссылок
+	storageTimeout  = 5 * time.Second         // предельное время операций с хранилищем
+	cacheSize       = 0                       // размер кеша коротких ссылок, кеш включается явно
+	cacheTTL        = time.Minute             // время жизни записи кеша
+	rateLimitKeys   = 100000                  // количество отслеживаемых ограничением частоты клиентов
+	passwordRate    = 1.0 / 60                // попыток ввода пароля ссылки в секунду от клиента
+	passwordBurst   = 5                       // попыток ввода пароля ссылки подряд
+	redirectType    = 307                     // код перенаправления по умолчанию, Temporary Redirect
+	redirectMaxAge  = 24 * time.Hour          // время кеширования постоянного перенаправления
+	queryConflict   = "link"                  // при совпадении имен параметров запроса остается параметр ссылки
 )
 
 // splitHostPort - парсинг строки хоста и порта.
@@ -52,6 +64,93 @@ func initConfig(settings *Settings) {
 	if !settings.EnableTSL {
 		settings.EnableTSL = config.EnableHTTPS
 	}
+	if settings.LengthShortURL == lengthShortURL && config.LengthShortURL > 0 {
+		settings.LengthShortURL = config.LengthShortURL
+	}
+	if settings.CodeGenerator == codeGenerator && config.CodeGenerator != "" {
+		settings.CodeGenerator = config.CodeGenerator
+	}
+	if settings.Storage == "" {
+		settings.Storage = config.Storage
+	}
+	if settings.CacheSize == cacheSize && config.CacheSize != nil {
+		settings.CacheSize = *config.CacheSize
+	}
+	if settings.CacheTTL == cacheTTL && config.CacheTTL != "" {
+		if ttl, err := time.ParseDuration(config.CacheTTL); err == nil {
+			settings.CacheTTL = ttl
+		}
+	}
+	if settings.QuotaTotal == 0 {
+		settings.QuotaTotal = config.QuotaTotal
+	}
+	if settings.QuotaDaily == 0 {
+		settings.QuotaDaily = config.QuotaDaily
+	}
+	if settings.QuotaBatch == 0 {
+		settings.QuotaBatch = config.QuotaBatch
+	}
+	if settings.RateCreate == 0 {
+		settings.RateCreate = config.RateCreate
+	}
+	if settings.RateCreateBurst == 0 {
+		settings.RateCreateBurst = config.RateCreateBurst
+	}
+	if settings.RateRedirect == 0 {
+		settings.RateRedirect = config.RateRedirect
+	}
+	if settings.RateRedirectBurst == 0 {
+		settings.RateRedirectBurst = config.RateRedirectBurst
+	}
+	if settings.RatePassword == passwordRate && config.RatePassword > 0 {
+		settings.RatePassword = config.RatePassword
+	}
+	if settings.RatePasswordBurst == passwordBurst && config.RatePasswordBurst > 0 {
+		settings.RatePasswordBurst = config.RatePasswordBurst
+	}
+	if settings.RateLimitKeys == rateLimitKeys && config.RateLimitKeys > 0 {
+		settings.RateLimitKeys = config.RateLimitKeys
+	}
+	if settings.TrustedProxies == "" {
+		settings.TrustedProxies = config.TrustedProxies
+	}
+	if !settings.SortQuery {
+		settings.SortQuery = config.SortQuery
+	}
+	if settings.PolicyFile == "" {
+		settings.PolicyFile = config.PolicyFile
+	}
+	if !settings.AllowPrivate {
+		settings.AllowPrivate = config.AllowPrivate
+	}
+	if settings.PreviewTemplates == "" {
+		settings.PreviewTemplates = config.PreviewTemplates
+	}
+	if settings.RedirectType == redirectType && config.RedirectType != 0 {
+		settings.RedirectType = config.RedirectType
+	}
+	if settings.RedirectMaxAge == redirectMaxAge && config.RedirectMaxAge != "" {
+		if maxAge, err := time.ParseDuration(config.RedirectMaxAge); err == nil {
+			settings.RedirectMaxAge = maxAge
+		}
+	}
+	if !settings.QueryPassthrough {
+		settings.QueryPassthrough = config.QueryPassthrough
+	}
+	if settings.QueryParams == "" {
+		settings.QueryParams = config.QueryParams
+	}
+	if settings.QueryConflict == queryConflict && config.QueryConflict != "" {
+		settings.QueryConflict = config.QueryConflict
+	}
+	if settings.ClickHashKey == "" {
+		settings.ClickHashKey = config.ClickHashKey
+	}
+	if settings.StorageTimeout == storageTimeout && config.StorageTimeout != "" {
+		if timeout, err := time.ParseDuration(config.StorageTimeout); err == nil {
+			settings.StorageTimeout = timeout
+		}
+	}
 
 }
 
@@ -69,8 +168,36 @@ func ParseFlags() Settings {
 	flag.StringVar(&appSettings.DatabaseDSN, "d", "", "DataBaseDSN connect to DB")
 	flag.StringVar(&appSettings.FileStoragePath, "f", fileStoragePath, "Path to file of storage")
 	flag.BoolVar(&appSettings.SaveDBtoFile, "l", false, "Save db to file")
+	flag.StringVar(&appSettings.Storage, "storage", "", "Storage: memory, disk, postgres (default postgres if -d is set, else memory)")
 	flag.BoolVar(&appSettings.EnableTSL, "s", false, "TSL enable")
 	flag.BoolVar(&appSettings.AddProfileRoute, "p", false, "Add profiling route")
+	flag.IntVar(&appSettings.LengthShortURL, "n", lengthShortURL, "Length of short url")
+	flag.StringVar(&appSettings.CodeGenerator, "g", codeGenerator, "Short url generator: hash, random, sequential")
+	flag.DurationVar(&appSettings.StorageTimeout, "t", storageTimeout, "Storage operations timeout, 0 - no timeout")
+	flag.IntVar(&appSettings.CacheSize, "cache-size", cacheSize, "Short url cache size, 0 - no cache")
+	flag.DurationVar(&appSettings.CacheTTL, "cache-ttl", cacheTTL, "Short url cache entry ttl")
+	flag.IntVar(&appSettings.QuotaTotal, "quota-total", 0, "Max short urls per user, 0 - unlimited")
+	flag.IntVar(&appSettings.QuotaDaily, "quota-daily", 0, "Max short urls per user per day (UTC), 0 - unlimited")
+	flag.IntVar(&appSettings.QuotaBatch, "quota-batch", 0, "Max short urls in one batch request, 0 - unlimited")
+	flag.Float64Var(&appSettings.RateCreate, "rate-create", 0, "Max create requests per second per client, 0 - unlimited")
+	flag.IntVar(&appSettings.RateCreateBurst, "rate-create-burst", 0, "Create requests burst, 0 - one second of requests")
+	flag.Float64Var(&appSettings.RateRedirect, "rate-redirect", 0, "Max redirects per second per client, 0 - unlimited")
+	flag.IntVar(&appSettings.RateRedirectBurst, "rate-redirect-burst", 0, "Redirects burst, 0 - one second of requests")
+	flag.Float64Var(&appSettings.RatePassword, "rate-password", passwordRate, "Max link password attempts per second per link and client, 0 - unlimited")
+	flag.IntVar(&appSettings.RatePasswordBurst, "rate-password-burst", passwordBurst, "Link password attempts burst")
+	flag.IntVar(&appSettings.RateLimitKeys, "rate-keys", rateLimitKeys, "Max clients tracked by rate limiter")
+	flag.StringVar(&appSettings.TrustedProxies, "trusted-proxies", "", "Reverse proxy addresses or networks allowed to set X-Real-IP, e.g. 10.0.0.1,192.168.0.0/16")
+	flag.BoolVar(&appSettings.SortQuery, "sort-query", false, "Sort query params of shortened urls")
+	flag.StringVar(&appSettings.PolicyFile, "policy", "", "File with deny/allow rules for shortened url hosts")
+	flag.BoolVar(&appSettings.AllowPrivate, "allow-private", false, "Allow shortening urls to localhost and private networks")
+	flag.StringVar(&appSettings.PreviewTemplates, "preview-templates", "", "Directory with *.html templates overriding the preview page")
+	flag.IntVar(&appSettings.RedirectType, "redirect-type", redirectType, "Default redirect status code: 301, 302, 307 or 308")
+	flag.DurationVar(&appSettings.RedirectMaxAge, "redirect-max-age", redirectMaxAge, "Client cache lifetime of permanent redirects, 0 - no cache")
+	flag.BoolVar(&appSettings.QueryPassthrough, "query-passthrough", false, "Pass query params of redirect requests to original urls")
+	flag.StringVar(&appSettings.QueryParams, "query-params", "", "Query params added on redirect, e.g. utm_source=short&utm_medium=link")
+	flag.StringVar(&appSettings.QueryConflict, "query-conflict", queryConflict, "Query param kept on name conflict: link or request")
+	flag.StringVar(&appSettings.ClickHashKey, "click-hash-key", "", "Secret for hashing client addresses in click stats, empty - random key per start")
+	flag.BoolVar(&appSettings.DryRunMigrations, "m", false, "List pending database migrations and exit")
 	flag.Parse()
 
 	if appSettings.ConfigNameFile != "" {
@@ -99,6 +226,125 @@ func ParseFlags() Settings {
 	if envDatabaseDSN := os.Getenv("SHORTURL_DATABASE_DSN"); envDatabaseDSN != "" {
 		appSettings.DatabaseDSN = envDatabaseDSN
 	}
+	if envLengthShortURL := os.Getenv("SHORTURL_LENGTH"); envLengthShortURL != "" {
+		if length, err := strconv.Atoi(envLengthShortURL); err == nil && length > 0 {
+			appSettings.LengthShortURL = length
+		}
+	}
+	if envCodeGenerator := os.Getenv("SHORTURL_CODE_GENERATOR"); envCodeGenerator != "" {
+		appSettings.CodeGenerator = envCodeGenerator
+	}
+	if envStorage := os.Getenv("SHORTURL_STORAGE"); envStorage != "" {
+		appSettings.Storage = envStorage
+	}
+	if envCacheSize := os.Getenv("SHORTURL_CACHE_SIZE"); envCacheSize != "" {
+		if size, err := strconv.Atoi(envCacheSize); err == nil && size >= 0 {
+			appSettings.CacheSize = size
+		}
+	}
+	if envCacheTTL := os.Getenv("SHORTURL_CACHE_TTL"); envCacheTTL != "" {
+		if ttl, err := time.ParseDuration(envCacheTTL); err == nil {
+			appSettings.CacheTTL = ttl
+		}
+	}
+	if envQuotaTotal := os.Getenv("SHORTURL_QUOTA_TOTAL"); envQuotaTotal != "" {
+		if limit, err := strconv.Atoi(envQuotaTotal); err == nil && limit >= 0 {
+			appSettings.QuotaTotal = limit
+		}
+	}
+	if envQuotaDaily := os.Getenv("SHORTURL_QUOTA_DAILY"); envQuotaDaily != "" {
+		if limit, err := strconv.Atoi(envQuotaDaily); err == nil && limit >= 0 {
+			appSettings.QuotaDaily = limit
+		}
+	}
+	if envQuotaBatch := os.Getenv("SHORTURL_QUOTA_BATCH"); envQuotaBatch != "" {
+		if limit, err := strconv.Atoi(envQuotaBatch); err == nil && limit >= 0 {
+			appSettings.QuotaBatch = limit
+		}
+	}
+	if envRateCreate := os.Getenv("SHORTURL_RATE_CREATE"); envRateCreate != "" {
+		if rate, err := strconv.ParseFloat(envRateCreate, 64); err == nil && rate >= 0 {
+			appSettings.RateCreate = rate
+		}
+	}
+	if envRateCreateBurst := os.Getenv("SHORTURL_RATE_CREATE_BURST"); envRateCreateBurst != "" {
+		if burst, err := strconv.Atoi(envRateCreateBurst); err == nil && burst >= 0 {
+			appSettings.RateCreateBurst = burst
+		}
+	}
+	if envRateRedirect := os.Getenv("SHORTURL_RATE_REDIRECT"); envRateRedirect != "" {
+		if rate, err := strconv.ParseFloat(envRateRedirect, 64); err == nil && rate >= 0 {
+			appSettings.RateRedirect = rate
+		}
+	}
+	if envRateRedirectBurst := os.Getenv("SHORTURL_RATE_REDIRECT_BURST"); envRateRedirectBurst != "" {
+		if burst, err := strconv.Atoi(envRateRedirectBurst); err == nil && burst >= 0 {
+			appSettings.RateRedirectBurst = burst
+		}
+	}
+	if envRatePassword := os.Getenv("SHORTURL_RATE_PASSWORD"); envRatePassword != "" {
+		if rate, err := strconv.ParseFloat(envRatePassword, 64); err == nil && rate >= 0 {
+			appSettings.RatePassword = rate
+		}
+	}
+	if envRatePasswordBurst := os.Getenv("SHORTURL_RATE_PASSWORD_BURST"); envRatePasswordBurst != "" {
+		if burst, err := strconv.Atoi(envRatePasswordBurst); err == nil && burst > 0 {
+			appSettings.RatePasswordBurst = burst
+		}
+	}
+	if envRateLimitKeys := os.Getenv("SHORTURL_RATE_LIMIT_KEYS"); envRateLimitKeys != "" {
+		if keys, err := strconv.Atoi(envRateLimitKeys); err == nil && keys > 0 {
+			appSettings.RateLimitKeys = keys
+		}
+	}
+	if envTrustedProxies := os.Getenv("SHORTURL_TRUSTED_PROXIES"); envTrustedProxies != "" {
+		appSettings.TrustedProxies = envTrustedProxies
+	}
+	if envSortQuery := os.Getenv("SHORTURL_SORT_QUERY"); envSortQuery != "" {
+		if sortQuery, err := strconv.ParseBool(envSortQuery); err == nil {
+			appSettings.SortQuery = sortQuery
+		}
+	}
+	if envPolicyFile := os.Getenv("SHORTURL_POLICY_FILE"); envPolicyFile != "" {
+		appSettings.PolicyFile = envPolicyFile
+	}
+	if envAllowPrivate := os.Getenv("SHORTURL_ALLOW_PRIVATE"); envAllowPrivate != "" {
+		if allowPrivate, err := strconv.ParseBool(envAllowPrivate); err == nil {
+			appSettings.AllowPrivate = allowPrivate
+		}
+	}
+	if envPreviewTemplates := os.Getenv("SHORTURL_PREVIEW_TEMPLATES"); envPreviewTemplates != "" {
+		appSettings.PreviewTemplates = envPreviewTemplates
+	}
+	if envRedirectType := os.Getenv("SHORTURL_REDIRECT_TYPE"); envRedirectType != "" {
+		if code, err := strconv.Atoi(envRedirectType); err == nil {
+			appSettings.RedirectType = code
+		}
+	}
+	if envRedirectMaxAge := os.Getenv("SHORTURL_REDIRECT_MAX_AGE"); envRedirectMaxAge != "" {
+		if maxAge, err := time.ParseDuration(envRedirectMaxAge); err == nil && maxAge >= 0 {
+			appSettings.RedirectMaxAge = maxAge
+		}
+	}
+	if envQueryPassthrough := os.Getenv("SHORTURL_QUERY_PASSTHROUGH"); envQueryPassthrough != "" {
+		if passthrough, err := strconv.ParseBool(envQueryPassthrough); err == nil {
+			appSettings.QueryPassthrough = passthrough
+		}
+	}
+	if envQueryParams := os.Getenv("SHORTURL_QUERY_PARAMS"); envQueryParams != "" {
+		appSettings.QueryParams = envQueryParams
+	}
+	if envQueryConflict := os.Getenv("SHORTURL_QUERY_CONFLICT"); envQueryConflict != "" {
+		appSettings.QueryConflict = envQueryConflict
+	}
+	if envClickHashKey := os.Getenv("SHORTURL_CLICK_HASH_KEY"); envClickHashKey != "" {
+		appSettings.ClickHashKey = envClickHashKey
+	}
+	if envStorageTimeout := os.Getenv("SHORTURL_STORAGE_TIMEOUT"); envStorageTimeout != "" {
+		if timeout, err := time.ParseDuration(envStorageTimeout); err == nil {
+			appSettings.StorageTimeout = timeout
+		}
+	}
 	if envRunAddr := os.Getenv("SHORTURL_SERVER_ADDRESS"); envRunAddr != "" {
 		host, port, err := splitHostPort(envRunAddr)
 		if err == nil {
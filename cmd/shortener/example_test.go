@@ -17,7 +17,7 @@ const (
 func ExampleShorterURL() {
 
 	inMemoryStorage, _ := storage.NewStorageInMemory(exampleLengthShortURL)
-	targetHandler := handlers.ShorterURL(inMemoryStorage, exampleBaseURL)
+	targetHandler := handlers.ShorterURL(inMemoryStorage, exampleBaseURL, nil, false)
 
 	srv := httptest.NewServer(targetHandler)
 	defer srv.Close()
@@ -2,6 +2,8 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +13,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"syscall"
+	"time"
 
 	hdl "github.com/PerfectStepCoder/shorturl/internal/handlers"
 	"github.com/PerfectStepCoder/shorturl/internal/storage"
@@ -30,15 +33,29 @@ var (
 // mainStorage - хранилище для записи и чтения обработанных ссылок.
 var mainStorage storage.PersistanceStorage
 
+// clickStorage - хранилище аналитики переходов по ссылкам.
+var clickStorage storage.AnalyticsStorage
+
 const (
-	// lengthShortURL — константа длина генерируемых коротких ссылок.
-	lengthShortURL = 10
 	// lengthInputCh - размер буфера для канала обработки ссылок
 	lengthInputCh = 10000
+	// expirationSweepInterval - период фоновой пометки просроченных ссылок
+	expirationSweepInterval = time.Minute
+	// journalCompactInterval - период перезаписи журнала хранилища в памяти снимком
+	journalCompactInterval = 10 * time.Minute
+	// policyReloadInterval - период проверки изменений файла политики адресов
+	policyReloadInterval = 10 * time.Second
 )
 
-func initRoutes(routes *chi.Mux, appSettings config.Settings, logger *logrus.Logger, inputCh chan []string, someStorage storage.PersistanceStorage) error {
+func initRoutes(routes *chi.Mux, appSettings config.Settings, logger *logrus.Logger, inputCh chan []string, someStorage storage.PersistanceStorage, clicks storage.AnalyticsStorage) error {
 	// Middlewares
+	trustedProxies, err := hdl.ParseTrustedProxies(appSettings.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	routes.Use(func(next http.Handler) http.Handler {
+		return hdl.WithRealIP(next.ServeHTTP, trustedProxies)
+	})
 	routes.Use(func(next http.Handler) http.Handler {
 		return hdl.WithLogging(next.ServeHTTP, logger)
 	})
@@ -48,23 +65,123 @@ func initRoutes(routes *chi.Mux, appSettings config.Settings, logger *logrus.Log
 	routes.Use(func(next http.Handler) http.Handler {
 		return hdl.CheckSignedCookie(next.ServeHTTP)
 	})
+	routes.Use(func(next http.Handler) http.Handler {
+		return hdl.WithStorageTimeout(next.ServeHTTP, appSettings.StorageTimeout)
+	})
+	quotas := hdl.NewQuotas(hdl.QuotaLimits{
+		Total: appSettings.QuotaTotal,
+		Daily: appSettings.QuotaDaily,
+		Batch: appSettings.QuotaBatch,
+	}, someStorage)
+	policy, err := storage.NewDomainPolicy(appSettings.PolicyFile, !appSettings.AllowPrivate)
+	if err != nil {
+		return fmt.Errorf("domain policy: %w", err)
+	}
+	if appSettings.PolicyFile != "" {
+		go reloadPolicyPeriodically(policy)
+	}
+	previewPages, err := hdl.NewPreviewPages(appSettings.PreviewTemplates)
+	if err != nil {
+		return fmt.Errorf("preview templates: %w", err)
+	}
+	if !hdl.IsValidRedirectType(appSettings.RedirectType) {
+		return fmt.Errorf("redirect type %d: must be 301, 302, 307 or 308", appSettings.RedirectType)
+	}
+	queryParams, err := storage.ParseQueryParams(appSettings.QueryParams)
+	if err != nil {
+		return fmt.Errorf("query params: %w", err)
+	}
+	passthrough := appSettings.QueryPassthrough
+	queryRules := storage.QueryRules{Passthrough: &passthrough, Params: queryParams, Conflict: appSettings.QueryConflict}
+	if err := queryRules.Validate(); err != nil {
+		return fmt.Errorf("query rules: %w", err)
+	}
+	if appSettings.ClickHashKey == "" {
+		log.Printf("Click hash key is not set, unique clients are counted with a random key until restart")
+	}
+	redirects := &hdl.Redirects{
+		Pages:        previewPages,
+		Options:      hdl.RedirectOptions{Default: appSettings.RedirectType, MaxAge: appSettings.RedirectMaxAge},
+		QueryRules:   queryRules,
+		ClickHashKey: []byte(appSettings.ClickHashKey),
+	}
 
 	if appSettings.AddProfileRoute {
 		// Регистрируем pprof маршрут
 		routes.Mount("/debug/pprof/", http.DefaultServeMux)
+		routes.Mount("/debug/vars", http.DefaultServeMux)
 	}
 
-	routes.Post("/", hdl.Auth(hdl.ShorterURL(someStorage, appSettings.BaseURL)))
-	routes.Get("/{id}", hdl.Auth(hdl.GetURL(someStorage)))
+	// Ограничение частоты запросов по группам маршрутов
+	createLimiter := newRateLimiter(appSettings.RateCreate, appSettings.RateCreateBurst, appSettings.RateLimitKeys)
+	limitCreate := func(next http.Handler) http.Handler {
+		return hdl.WithRateLimit(next.ServeHTTP, createLimiter)
+	}
+	redirectLimiter := newRateLimiter(appSettings.RateRedirect, appSettings.RateRedirectBurst, appSettings.RateLimitKeys)
+	limitRedirect := func(next http.Handler) http.Handler {
+		return hdl.WithRateLimit(next.ServeHTTP, redirectLimiter)
+	}
+	passwordLimiter := newRateLimiter(appSettings.RatePassword, appSettings.RatePasswordBurst, appSettings.RateLimitKeys)
+
+	routes.With(limitCreate).Post("/", hdl.Auth(hdl.ShorterURL(someStorage, appSettings.BaseURL, policy, appSettings.SortQuery)))
+	routes.With(limitRedirect).Get("/{id}", hdl.Auth(hdl.GetURL(someStorage, clicks, redirects)))
+	routes.With(limitRedirect).Post("/{id}", hdl.CheckPassword(someStorage, clicks, passwordLimiter, redirects))
+	routes.With(limitRedirect).Get("/{id}+", hdl.GetPreview(someStorage, redirects))
+	routes.With(limitRedirect).Get("/{id}/qr", hdl.GetQRCode(someStorage, appSettings.BaseURL))
 	routes.Get("/api/user/urls", hdl.Auth(hdl.GetURLs(someStorage, appSettings.BaseURL)))
+	routes.Get("/api/user/urls/search", hdl.Auth(hdl.SearchURLs(someStorage, appSettings.BaseURL)))
+	routes.Get("/api/user/urls/{id}/stats", hdl.Auth(hdl.GetURLStats(someStorage, clicks)))
+	routes.Patch("/api/user/urls/{id}", hdl.Auth(hdl.UpdateURL(someStorage, appSettings.BaseURL, policy, appSettings.SortQuery)))
 	routes.Delete("/api/user/urls", hdl.Auth(hdl.DeleteURLs(someStorage, inputCh)))
-	routes.Post("/api/shorten", hdl.ObjectShorterURL(someStorage, appSettings.BaseURL))
-	routes.Post("/api/shorten/batch", hdl.ObjectsShorterURL(someStorage, appSettings.BaseURL))
+	routes.Post("/api/user/urls/restore", hdl.Auth(hdl.RestoreURLs(someStorage)))
+	routes.Get("/api/user/quota", hdl.Auth(hdl.GetQuota(quotas)))
+	routes.With(limitCreate).Post("/api/shorten", hdl.ObjectShorterURL(someStorage, appSettings.BaseURL, policy, appSettings.SortQuery))
+	routes.With(limitCreate).Post("/api/shorten/batch", hdl.ObjectsShorterURL(someStorage, appSettings.BaseURL, policy, appSettings.SortQuery, quotas))
 	routes.Get("/ping", hdl.PingDatabase(appSettings.DatabaseDSN))
 
 	return nil
 }
 
+// newRateLimiter - ограничение частоты запросов группы маршрутов, nil при нулевой частоте.
+func newRateLimiter(rate float64, burst int, maxKeys int) *hdl.RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return hdl.NewRateLimiter(hdl.RateLimit{Rate: rate, Burst: burst}, maxKeys)
+}
+
+// reloadPolicyPeriodically - перечитывание измененного файла политики адресов без перезапуска сервиса.
+func reloadPolicyPeriodically(policy *storage.DomainPolicy) {
+	ticker := time.NewTicker(policyReloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloaded, err := policy.ReloadIfChanged()
+		if err != nil {
+			log.Printf("Domain policy reload error: %s", err)
+			continue
+		}
+		if reloaded {
+			log.Printf("Domain policy reloaded")
+		}
+	}
+}
+
+// compactPeriodically - периодическая перезапись журнала или лога хранилища только актуальными записями.
+func compactPeriodically(compact func() (int, error)) {
+	ticker := time.NewTicker(journalCompactInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		compacted, err := compact()
+		if err != nil {
+			log.Printf("Journal compaction error: %s", err)
+			continue
+		}
+		if compacted > 0 {
+			log.Printf("Journal compacted: %d recordes\n", compacted)
+		}
+	}
+}
+
 func printBuildFlags() {
 
 	fmt.Printf("Build version: %s\n", buildVersion)
@@ -94,7 +211,7 @@ func main() {
 		go func(inputCh chan []string) {
 			for shortsHashURL := range inputCh {
 				userUID := shortsHashURL[0]
-				err := mainStorage.DeleteByUser(shortsHashURL[1:], userUID)
+				err := mainStorage.DeleteByUser(context.Background(), shortsHashURL[1:], userUID)
 				if err != nil {
 					log.Printf("Delete error: %s", err)
 				}
@@ -108,23 +225,114 @@ func main() {
 	appSettings := config.ParseFlags()
 	log.Print("\n", appSettings, "\n")
 	log.Printf("Count core: %d\n", runtime.NumCPU())
-	if appSettings.DatabaseDSN != "" {
-		var err error
-		mainStorage, err = storage.NewStorageInPostgres(appSettings.DatabaseDSN, lengthShortURL)
+	codeGenerator, err := storage.NewCodeGenerator(appSettings.CodeGenerator, appSettings.LengthShortURL)
+	if err != nil {
+		log.Fatalf("Problem with code generator: %s", err)
+	}
+	if appSettings.DryRunMigrations {
+		// Только список неприменённых миграций, схема БД не изменяется
+		if appSettings.DatabaseDSN == "" {
+			log.Fatalf("Database DSN is required to list migrations")
+		}
+		pending, err := storage.ListPendingMigrations(appSettings.DatabaseDSN)
+		if err != nil {
+			log.Fatalf("Problem with migrations: %s", err)
+		}
+		fmt.Printf("Pending migrations: %d\n", len(pending))
+		for _, migration := range pending {
+			fmt.Println(migration)
+		}
+		return
+	}
+	// loadDump - загрузка ссылок из файла -f в выбранное хранилище
+	loadDump := func() {
+		loaded, err := mainStorage.LoadData(context.Background(), appSettings.FileStoragePath)
+		if err != nil {
+			log.Printf("Load error: %s", err)
+		}
+		log.Printf("Loaded: %d recordes from file: %s\n", loaded, appSettings.FileStoragePath)
+	}
+	storageKind := appSettings.StorageKind()
+	switch storageKind {
+	case config.StoragePostgres:
+		postgresStorage, err := storage.NewStorageInPostgres(appSettings.DatabaseDSN, appSettings.LengthShortURL)
 		if err != nil {
 			log.Fatalf("Problem with database")
 		}
-	} else {
-		mainStorage, _ = storage.NewStorageInMemory(lengthShortURL)
+		postgresStorage.SetCodeGenerator(codeGenerator)
+		mainStorage = postgresStorage
+		clickStorage = storage.NewClicksInPostgres(postgresStorage)
+		if appSettings.SaveDBtoFile {
+			// Восстановление БД из дампа, из нескольких версий ссылки остается последняя
+			loadDump()
+		}
+		// Последовательный генератор продолжает после наибольшего уже выданного кода
+		if err := postgresStorage.SeedCodeGenerator(context.Background()); err != nil {
+			log.Printf("Seed code generator error: %s", err)
+		}
+	case config.StorageDisk:
+		// Лог хранится рядом с файлом -f, который остается в формате хранилища в памяти для импорта и экспорта
+		diskStorage, err := storage.NewStorageOnDisk(appSettings.FileStoragePath+".log", appSettings.LengthShortURL)
+		if err != nil {
+			log.Fatalf("Problem with disk storage: %s", err)
+		}
+		diskStorage.SetCodeGenerator(codeGenerator)
+		mainStorage = diskStorage
+		clickStorage = storage.NewClicksInMemory()
+		if appSettings.SaveDBtoFile {
+			loadDump()
+		}
+		go compactPeriodically(diskStorage.Compact)
+	case config.StorageMemory:
+		memoryStorage, _ := storage.NewStorageInMemory(appSettings.LengthShortURL)
+		memoryStorage.SetCodeGenerator(codeGenerator)
+		mainStorage = memoryStorage
+		clickStorage = storage.NewClicksInMemory()
 		// Load
-		loaded := mainStorage.LoadData(appSettings.FileStoragePath)
-		log.Printf("Loaded: %d recordes from file: %s\n", loaded, appSettings.FileStoragePath)
+		loadDump()
+		// Журнал изменений, чтобы не терять ссылки при аварийном завершении
+		if err := memoryStorage.EnableJournal(appSettings.FileStoragePath); err != nil {
+			log.Printf("Journal error: %s", err)
+		}
+		go compactPeriodically(memoryStorage.Compact)
+	default:
+		log.Fatalf("Unknown storage: %s", storageKind)
+	}
+	// Общая и суточная квоты проверяются хранилищем при вставке, ссылки из дампа загружаются без ограничений
+	mainStorage.SetLinkLimits(storage.LinkLimits{Total: appSettings.QuotaTotal, Daily: appSettings.QuotaDaily})
+
+	if appSettings.CacheSize > 0 {
+		// Кеш коротких ссылок перед любым хранилищем, счетчики доступны в /debug/vars
+		cachedStorage := storage.NewCachedStorage(mainStorage, appSettings.CacheSize, appSettings.CacheTTL)
+		expvar.Publish("storage_cache", expvar.Func(func() any {
+			return cachedStorage.Stats()
+		}))
+		mainStorage = cachedStorage
 	}
 
 	defer mainStorage.Close()
 
+	// Фоновая пометка просроченных ссылок
+	go func() {
+		ticker := time.NewTicker(expirationSweepInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			marked, err := mainStorage.MarkExpired(context.Background(), now)
+			if err != nil {
+				log.Printf("Expiration sweep error: %s", err)
+				continue
+			}
+			if marked > 0 {
+				log.Printf("Marked expired: %d urls\n", marked)
+			}
+		}
+	}()
+
 	routes := chi.NewRouter()
-	initRoutes(routes, appSettings, logger, inputCh, mainStorage) // инициализация маршрутов
+	// инициализация маршрутов
+	if err := initRoutes(routes, appSettings, logger, inputCh, mainStorage, clickStorage); err != nil {
+		log.Fatalf("Problem with routes: %s", err)
+	}
 
 	fmt.Printf("Service is starting host: %s on port: %d\n", appSettings.ServiceNetAddress.Host,
 		appSettings.ServiceNetAddress.Port)
@@ -169,9 +377,12 @@ func main() {
 	log.Println("Shutting down server...")
 	close(inputCh)
 
-	if appSettings.DatabaseDSN == "" {
+	if storageKind == config.StorageMemory || appSettings.SaveDBtoFile {
 		// Save
-		saved := mainStorage.SaveData(appSettings.FileStoragePath)
+		saved, err := mainStorage.SaveData(context.Background(), appSettings.FileStoragePath)
+		if err != nil {
+			log.Printf("Save error: %s", err)
+		}
 		log.Printf("Saved: %d recordes to file: %s\n", saved, appSettings.FileStoragePath)
 	}
 }
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/PerfectStepCoder/shorturl/internal/handlers"
+	"github.com/PerfectStepCoder/shorturl/internal/models"
 	"github.com/PerfectStepCoder/shorturl/internal/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -35,7 +47,7 @@ func TestShorterURL(t *testing.T) {
 	}
 
 	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
-	targetHandler := handlers.ShorterURL(inMemoryStorage, testBaseURL)
+	targetHandler := handlers.ShorterURL(inMemoryStorage, testBaseURL, nil, false)
 
 	srv := httptest.NewServer(targetHandler)
 	defer srv.Close()
@@ -72,7 +84,7 @@ func TestShorterURL(t *testing.T) {
 func TestGetURLwithLoging(t *testing.T) {
 	userUID := uuid.New().String()
 	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
-	shortString, _ := inMemoryStorage.Save("https://yandex.ru/", userUID)
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
 	assert.Equal(t, shortString, "77fca5950e")
 
 	testCases := []struct {
@@ -89,7 +101,7 @@ func TestGetURLwithLoging(t *testing.T) {
 	routes := chi.NewRouter()
 	var logger, logFile = config.GetLogger()
 	defer logFile.Close()
-	routes.Get("/{id}", handlers.WithLogging(handlers.GetURL(inMemoryStorage), logger))
+	routes.Get("/{id}", handlers.WithLogging(handlers.GetURL(inMemoryStorage, nil, nil), logger))
 	srv := httptest.NewServer(routes)
 
 	defer srv.Close()
@@ -131,7 +143,7 @@ func TestObjectsURL(t *testing.T) {
 	}
 
 	routes := chi.NewRouter()
-	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
 	srv := httptest.NewServer(routes)
 
 	defer srv.Close()
@@ -166,10 +178,1171 @@ func TestObjectsURL(t *testing.T) {
 	}
 }
 
+func TestObjectURLWithAlias(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "new alias", body: "{\"url\":\"https://practicum.yandex.ru/\",\"alias\":\"spring-sale\"}", expectedCode: http.StatusCreated, expectedBody: "{\"result\":\"http://localhost:8080/spring-sale\"}"},
+		{name: "taken alias", body: "{\"url\":\"https://yandex.ru/\",\"alias\":\"spring-sale\"}", expectedCode: http.StatusConflict, expectedBody: ""},
+		{name: "invalid alias", body: "{\"url\":\"https://yandex.ru/\",\"alias\":\"no spaces\"}", expectedCode: http.StatusBadRequest, expectedBody: ""},
+	}
+
+	routes := chi.NewRouter()
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	srv := httptest.NewServer(routes)
+
+	defer srv.Close()
+
+	for _, tc := range testCases {
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			resp, err := resty.New().R().
+				SetHeader("Content-Type", "application/json").
+				SetBody(tc.body).
+				Post(srv.URL + "/api/shorten")
+			assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode())
+			if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, string(resp.Body()))
+			}
+		})
+	}
+}
+
+func TestBatchAliasHijack(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	newCookie := func() *http.Cookie {
+		rec := httptest.NewRecorder()
+		handlers.SetNewCookie(rec)
+		return rec.Result().Cookies()[0]
+	}
+	ownerCookie, otherCookie := newCookie(), newCookie()
+
+	routes := chi.NewRouter()
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, nil, false, nil))
+	routes.Get("/{id}", handlers.GetURL(inMemoryStorage, nil, nil))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	resp, err := resty.New().R().SetCookie(ownerCookie).SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://practicum.yandex.ru/","alias":"spring-sale"}`).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+
+	// Другой пользователь пытается занять псевдоним через пакетный запрос
+	resp, err = resty.New().R().SetCookie(otherCookie).SetHeader("Content-Type", "application/json").
+		SetBody(`[{"correlation_id":"spring-sale","original_url":"https://evil.example/"}]`).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode())
+
+	client := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy())
+	resp, _ = client.R().Get(srv.URL + "/spring-sale")
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	assert.Equal(t, "https://practicum.yandex.ru/", resp.Header().Get("Location"))
+	otherUID, _ := handlers.ValidateUserUID(otherCookie.Value)
+	userURLs, err := inMemoryStorage.FindByUserUID(context.Background(), otherUID)
+	assert.NoError(t, err)
+	assert.Empty(t, userURLs)
+}
+
+func TestExpiredURL(t *testing.T) {
+
+	userUID := uuid.New().String()
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	expiresAt := time.Now().Add(time.Hour)
+	shortString, _ := inMemoryStorage.SaveLink(context.Background(), "https://yandex.ru/", "", userUID, storage.LinkOptions{ExpiresAt: expiresAt})
+	inMemoryStorage.MarkExpired(context.Background(), expiresAt)
+
+	routes := chi.NewRouter()
+	routes.Get("/{id}", handlers.GetURL(inMemoryStorage, nil, nil))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	resp, err := resty.New().R().Get(srv.URL + "/" + shortString)
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusGone, resp.StatusCode())
+
+	// Срок действия в прошлом
+	resp, err = resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetBody("{\"url\":\"https://google.ru/\",\"expires_at\":\"2000-01-01T00:00:00Z\"}").
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	// Время жизни в секундах
+	resp, err = resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetBody("{\"url\":\"https://google.ru/\",\"ttl\":3600}").
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	link, err := inMemoryStorage.GetLink(context.Background(), "41c9cc9cba")
+	assert.NoError(t, err)
+	assert.False(t, link.Expired)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), link.ExpiresAt, time.Minute)
+}
+
+func TestURLStats(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	clicks := storage.NewClicksInMemory()
+
+	// Кука владельца ссылки
+	rec := httptest.NewRecorder()
+	userUID, _ := handlers.SetNewCookie(rec)
+	ownerCookie := rec.Result().Cookies()[0]
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+
+	routes := chi.NewRouter()
+	routes.Get("/{id}", handlers.Auth(handlers.GetURL(inMemoryStorage, clicks, nil)))
+	routes.Get("/api/user/urls/{id}/stats", handlers.Auth(handlers.GetURLStats(inMemoryStorage, clicks)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	client := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy())
+	for i := 0; i < 2; i++ {
+		resp, _ := client.R().SetHeader("Referer", "https://ya.ru/").Get(srv.URL + "/" + shortString)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	}
+
+	resp, err := resty.New().R().SetCookie(ownerCookie).Get(srv.URL + "/api/user/urls/" + shortString + "/stats")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	today := time.Now().UTC().Format("2006-01-02")
+	var stats models.ResponseURLStats
+	assert.NoError(t, json.Unmarshal(resp.Body(), &stats))
+	assert.Equal(t, 2, stats.Total)
+	assert.Equal(t, 1, stats.Unique)
+	assert.Equal(t, []models.DailyClicks{{Date: today, Clicks: 2}}, stats.Daily)
+	assert.Len(t, stats.Recent, 2)
+	assert.Equal(t, "https://ya.ru/", stats.Recent[0].Referrer)
+
+	// Статистика недоступна другому пользователю
+	rec = httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	resp, err = resty.New().R().SetCookie(rec.Result().Cookies()[0]).Get(srv.URL + "/api/user/urls/" + shortString + "/stats")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+
+	resp, err = resty.New().R().SetCookie(ownerCookie).Get(srv.URL + "/api/user/urls/NotExist/stats")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	// Адрес клиента хешируется секретом из настроек
+	keyed := storage.NewClicksInMemory()
+	keyedRoutes := chi.NewRouter()
+	keyedRoutes.Get("/{id}", handlers.GetURL(inMemoryStorage, keyed, &handlers.Redirects{ClickHashKey: []byte("click-secret")}))
+	keyedSrv := httptest.NewServer(keyedRoutes)
+	defer keyedSrv.Close()
+	client.R().Get(keyedSrv.URL + "/" + shortString)
+	keyedStats, _ := keyed.ClickStats(context.Background(), shortString)
+	assert.Len(t, keyedStats.Recent, 1)
+	mac := hmac.New(sha256.New, []byte("click-secret"))
+	mac.Write([]byte("127.0.0.1"))
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), keyedStats.Recent[0].IPHash)
+}
+
+func TestRestoreURLs(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	// Кука владельца ссылки
+	rec := httptest.NewRecorder()
+	userUID, _ := handlers.SetNewCookie(rec)
+	ownerCookie := rec.Result().Cookies()[0]
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+	inMemoryStorage.DeleteByUser(context.Background(), []string{shortString}, userUID)
+
+	routes := chi.NewRouter()
+	routes.Get("/{id}", handlers.GetURL(inMemoryStorage, nil, nil))
+	routes.Post("/api/user/urls/restore", handlers.Auth(handlers.RestoreURLs(inMemoryStorage)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	client := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy())
+	resp, _ := client.R().Get(srv.URL + "/" + shortString)
+	assert.Equal(t, http.StatusGone, resp.StatusCode())
+
+	// Чужие ссылки не восстанавливаются
+	rec = httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	resp, err := resty.New().R().SetCookie(rec.Result().Cookies()[0]).
+		SetHeader("Content-Type", "application/json").
+		SetBody("[\"" + shortString + "\"]").
+		Post(srv.URL + "/api/user/urls/restore")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	resp, _ = client.R().Get(srv.URL + "/" + shortString)
+	assert.Equal(t, http.StatusGone, resp.StatusCode())
+
+	resp, err = resty.New().R().SetCookie(ownerCookie).
+		SetHeader("Content-Type", "application/json").
+		SetBody("[\"" + shortString + "\"]").
+		Post(srv.URL + "/api/user/urls/restore")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	resp, _ = client.R().Get(srv.URL + "/" + shortString)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+
+	resp, err = resty.New().R().SetCookie(ownerCookie).SetBody("not json").Post(srv.URL + "/api/user/urls/restore")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+}
+
+func TestSortedQuery(t *testing.T) {
+
+	for _, sortQuery := range []bool{false, true} {
+		inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+		routes := chi.NewRouter()
+		routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, sortQuery))
+		srv := httptest.NewServer(routes)
+
+		shorten := func(url string) int {
+			resp, err := resty.New().R().
+				SetHeader("Content-Type", "application/json").
+				SetBody(fmt.Sprintf("{\"url\":\"%s\"}", url)).
+				Post(srv.URL + "/api/shorten")
+			assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+			return resp.StatusCode()
+		}
+		assert.Equal(t, http.StatusCreated, shorten("https://yandex.ru/search?b=2&a=1"))
+		// С сортировкой ссылка с другим порядком параметров уже сокращена
+		expected := http.StatusCreated
+		if sortQuery {
+			expected = http.StatusConflict
+		}
+		assert.Equal(t, expected, shorten("https://yandex.ru/search?a=1&b=2"))
+		srv.Close()
+	}
+}
+
+func TestUpdateURL(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	rec := httptest.NewRecorder()
+	userUID, _ := handlers.SetNewCookie(rec)
+	ownerCookie := rec.Result().Cookies()[0]
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+	otherShort, _ := inMemoryStorage.Save(context.Background(), "https://google.ru/", userUID)
+
+	routes := chi.NewRouter()
+	routes.Get("/{id}", handlers.GetURL(inMemoryStorage, nil, nil))
+	routes.Patch("/api/user/urls/{id}", handlers.Auth(handlers.UpdateURL(inMemoryStorage, testBaseURL, nil, false)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	// Чужую ссылку менять нельзя
+	rec = httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	resp, err := resty.New().R().SetCookie(rec.Result().Cookies()[0]).
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://ya.ru"}`).
+		Patch(srv.URL + "/api/user/urls/" + shortString)
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+
+	resp, err = resty.New().R().SetCookie(ownerCookie).
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://ya.ru"}`).
+		Patch(srv.URL + "/api/user/urls/unknown")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+
+	// Новая ссылка уже сокращена
+	resp, err = resty.New().R().SetCookie(ownerCookie).
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://google.ru"}`).
+		Patch(srv.URL + "/api/user/urls/" + shortString)
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode())
+	assert.Contains(t, resp.String(), testBaseURL+"/"+otherShort)
+
+	resp, err = resty.New().R().SetCookie(ownerCookie).
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"ftp://ya.ru"}`).
+		Patch(srv.URL + "/api/user/urls/" + shortString)
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	resp, err = resty.New().R().SetCookie(ownerCookie).
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://ya.ru","ttl":3600}`).
+		Patch(srv.URL + "/api/user/urls/" + shortString)
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var updated models.ResponseURL
+	assert.NoError(t, json.Unmarshal(resp.Body(), &updated))
+	assert.Equal(t, "https://ya.ru/", updated.OriginalURL)
+	assert.Equal(t, testBaseURL+"/"+shortString, updated.ShortURL)
+	assert.NotNil(t, updated.ExpiresAt)
+
+	resp, _ = resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(srv.URL + "/" + shortString)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	assert.Equal(t, "https://ya.ru/", resp.Header().Get("Location"))
+
+	// Удаленную ссылку менять нельзя
+	inMemoryStorage.DeleteByUser(context.Background(), []string{shortString}, userUID)
+	resp, err = resty.New().R().SetCookie(ownerCookie).
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://mail.ru"}`).
+		Patch(srv.URL + "/api/user/urls/" + shortString)
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusGone, resp.StatusCode())
+	result, _ := inMemoryStorage.Get(context.Background(), shortString)
+	assert.Equal(t, "https://ya.ru/", result)
+}
+
+func TestGetURLsPage(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	rec := httptest.NewRecorder()
+	userUID, _ := handlers.SetNewCookie(rec)
+	userCookie := rec.Result().Cookies()[0]
+	for _, value := range []string{"https://b.yandex.ru/", "https://google.ru/", "https://a.yandex.ru/"} {
+		inMemoryStorage.Save(context.Background(), value, userUID)
+	}
+	deletedShort, _ := inMemoryStorage.Save(context.Background(), "https://mail.ru/", userUID)
+	inMemoryStorage.DeleteByUser(context.Background(), []string{deletedShort}, userUID)
+
+	routes := chi.NewRouter()
+	routes.Get("/api/user/urls", handlers.Auth(handlers.GetURLs(inMemoryStorage, testBaseURL)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	getPage := func(query string) (int, models.ResponseURLPage) {
+		var page models.ResponseURLPage
+		resp, err := resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls?" + query)
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		if resp.StatusCode() == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(resp.Body(), &page))
+		}
+		return resp.StatusCode(), page
+	}
+
+	// Без параметров - прежний ответ массивом
+	resp, err := resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	var all []models.ResponseURL
+	assert.NoError(t, json.Unmarshal(resp.Body(), &all))
+	assert.Len(t, all, 4)
+	assert.NotNil(t, all[0].CreatedAt)
+
+	// Параметры, не относящиеся к выборке, не меняют вид ответа
+	resp, err = resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls?utm_source=mail")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	all = nil
+	assert.NoError(t, json.Unmarshal(resp.Body(), &all))
+	assert.Len(t, all, 4)
+
+	var originals []string
+	query := "sort=original&order=desc&deleted=false&limit=2"
+	for {
+		code, page := getPage(query)
+		assert.Equal(t, http.StatusOK, code)
+		for _, item := range page.URLs {
+			originals = append(originals, item.OriginalURL)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query = "sort=original&order=desc&deleted=false&limit=2&cursor=" + page.NextCursor
+	}
+	assert.Equal(t, []string{"https://google.ru/", "https://b.yandex.ru/", "https://a.yandex.ru/"}, originals)
+
+	code, page := getPage("domain=yandex.ru&sort=original")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, page.URLs, 2)
+	assert.Equal(t, "https://a.yandex.ru/", page.URLs[0].OriginalURL)
+
+	code, page = getPage("deleted=true")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, page.URLs, 1)
+	assert.True(t, page.URLs[0].Deleted)
+
+	code, page = getPage("expired=true")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, page.URLs)
+	assert.Empty(t, page.URLs)
+
+	for _, query := range []string{"limit=0", "limit=x", "sort=short", "order=up", "deleted=maybe", "cursor=broken", "domain=[bad"} {
+		code, _ = getPage(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestSearchURLs(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	rec := httptest.NewRecorder()
+	userUID, _ := handlers.SetNewCookie(rec)
+	userCookie := rec.Result().Cookies()[0]
+	inMemoryStorage.Save(context.Background(), "https://example.com/pricing", userUID)
+	inMemoryStorage.Save(context.Background(), "https://example.com/about", userUID)
+
+	routes := chi.NewRouter()
+	routes.Get("/api/user/urls/search", handlers.Auth(handlers.SearchURLs(inMemoryStorage, testBaseURL)))
+	routes.Post("/api/shorten", handlers.Auth(handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	resp, err := resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls/search?q=Pricing")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var found []models.ResponseURL
+	assert.NoError(t, json.Unmarshal(resp.Body(), &found))
+	assert.Len(t, found, 1)
+	assert.Equal(t, "https://example.com/pricing", found[0].OriginalURL)
+
+	resp, _ = resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls/search?q=missing")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "[]", strings.TrimSpace(resp.String()))
+
+	// Ссылка находится по заметке
+	resp, _ = resty.New().R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://yandex.ru/","notes":"Competitor pricing"}`).Post(srv.URL + "/api/shorten")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	resp, _ = resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls/search?q=competitor")
+	found = nil
+	assert.NoError(t, json.Unmarshal(resp.Body(), &found))
+	assert.Len(t, found, 1)
+	assert.Equal(t, "https://yandex.ru/", found[0].OriginalURL)
+	assert.Equal(t, "Competitor pricing", found[0].Notes)
+
+	resp, _ = resty.New().R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+		SetBody(fmt.Sprintf(`{"url":"https://mail.ru/","notes":"%s"}`, strings.Repeat("a", 1001))).Post(srv.URL + "/api/shorten")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	assert.Equal(t, "Notes too long: at most 1000 characters\n", string(resp.Body()))
+
+	// Чужие ссылки не находятся
+	rec = httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	resp, _ = resty.New().R().SetCookie(rec.Result().Cookies()[0]).Get(srv.URL + "/api/user/urls/search?q=pricing")
+	assert.Equal(t, "[]", strings.TrimSpace(resp.String()))
+
+	for _, query := range []string{"q=", "q=pricing&limit=0"} {
+		resp, _ = resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls/search?" + query)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), query)
+	}
+}
+
+func TestTags(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	rec := httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	userCookie := rec.Result().Cookies()[0]
+
+	routes := chi.NewRouter()
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, nil, false, nil))
+	routes.Get("/api/user/urls", handlers.Auth(handlers.GetURLs(inMemoryStorage, testBaseURL)))
+	routes.Patch("/api/user/urls/{id}", handlers.Auth(handlers.UpdateURL(inMemoryStorage, testBaseURL, nil, false)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	post := func(path string, body string) *resty.Response {
+		resp, err := resty.New().R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+			SetBody(body).Post(srv.URL + path)
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		return resp
+	}
+	tagged := func(query string) []models.ResponseURL {
+		var page models.ResponseURLPage
+		resp, err := resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls?" + query)
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		assert.NoError(t, json.Unmarshal(resp.Body(), &page))
+		return page.URLs
+	}
+
+	resp := post("/api/shorten", `{"url":"https://yandex.ru/","tags":["Work","news"]}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	resp = post("/api/shorten/batch", `[{"correlation_id":"t1","original_url":"https://google.ru/","tags":["work/reports"]}]`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+
+	resp = post("/api/shorten", `{"url":"https://mail.ru/","tags":["bad tag"]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	resp = post("/api/shorten/batch", `[{"correlation_id":"t2","original_url":"https://mail.ru/","tags":["bad tag"]}]`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	assert.Contains(t, resp.String(), "t2")
+
+	found := tagged("tag=work")
+	assert.Len(t, found, 1)
+	assert.Equal(t, []string{"news", "work"}, found[0].Tags)
+	found = tagged("tag=work/reports")
+	assert.Len(t, found, 1)
+	assert.Equal(t, testBaseURL+"/t1", found[0].ShortURL)
+
+	resp, err := resty.New().R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+		SetBody(`{"tags":["archive"]}`).Patch(srv.URL + "/api/user/urls/t1")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Empty(t, tagged("tag=work/reports"))
+	assert.Len(t, tagged("tag=archive"), 1)
+
+	resp, _ = resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/urls?tag=bad+tag")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+}
+
+func TestQRCode(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	userUID := uuid.New().String()
+	shortURL, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+	deletedURL, _ := inMemoryStorage.Save(context.Background(), "https://google.ru/", userUID)
+	inMemoryStorage.DeleteByUser(context.Background(), []string{deletedURL}, userUID)
+
+	routes := chi.NewRouter()
+	routes.Get("/{id}/qr", handlers.GetQRCode(inMemoryStorage, testBaseURL))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	get := func(path string, accept string) *resty.Response {
+		resp, err := resty.New().R().SetHeader("Accept", accept).Get(srv.URL + path)
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		return resp
+	}
+
+	resp := get("/"+shortURL+"/qr", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "image/png", resp.Header().Get("Content-Type"))
+	img, err := png.Decode(strings.NewReader(resp.String()))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, img.Bounds().Dx(), 256)
+	assert.Equal(t, img.Bounds().Dx(), img.Bounds().Dy())
+
+	resp = get("/"+shortURL+"/qr?size=512&margin=0&level=H", "image/png")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	img, err = png.Decode(strings.NewReader(resp.String()))
+	assert.NoError(t, err)
+	assert.Greater(t, img.Bounds().Dx(), 256)
+
+	resp = get("/"+shortURL+"/qr", "image/svg+xml,image/png;q=0.5")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "image/svg+xml", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.String(), "<svg")
+
+	resp = get("/"+shortURL+"/qr?format=png", "image/svg+xml")
+	assert.Equal(t, "image/png", resp.Header().Get("Content-Type"))
+
+	for _, query := range []string{"format=gif", "size=10", "size=abc", "margin=100", "level=X"} {
+		resp = get("/"+shortURL+"/qr?"+query, "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode(), query)
+	}
+
+	resp = get("/"+deletedURL+"/qr", "")
+	assert.Equal(t, http.StatusGone, resp.StatusCode())
+	resp = get("/unknown/qr", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode())
+}
+
+func TestPreview(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	clicks := storage.NewClicksInMemory()
+
+	rec := httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	userCookie := rec.Result().Cookies()[0]
+
+	routes := chi.NewRouter()
+	routes.Get("/{id}", handlers.GetURL(inMemoryStorage, clicks, nil))
+	routes.Get("/{id}+", handlers.GetPreview(inMemoryStorage, nil))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	routes.Patch("/api/user/urls/{id}", handlers.Auth(handlers.UpdateURL(inMemoryStorage, testBaseURL, nil, false)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	client := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy())
+	shorten := func(body string) string {
+		var result models.ResponseShortURL
+		resp, err := client.R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+			SetBody(body).Post(srv.URL + "/api/shorten")
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+		assert.NoError(t, json.Unmarshal(resp.Body(), &result))
+		return strings.TrimPrefix(result.Result, testBaseURL+"/")
+	}
+	get := func(path string) *resty.Response {
+		resp, _ := client.R().Get(srv.URL + path)
+		return resp
+	}
+
+	// Предпросмотр по запросу, обычный переход не меняется
+	plain := shorten(`{"url":"https://yandex.ru/search?text=<b>"}`)
+	resp := get("/" + plain + "+")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.String(), "yandex.ru")
+	assert.Contains(t, resp.String(), `href="https://yandex.ru/search?text=%3cb%3e"`)
+	assert.NotContains(t, resp.String(), "<b>")
+	assert.Equal(t, http.StatusTemporaryRedirect, get("/"+plain).StatusCode())
+	assert.Equal(t, http.StatusNotFound, get("/unknown+").StatusCode())
+
+	// Ссылка, переход по которой всегда идет через предпросмотр
+	always := shorten(`{"url":"https://google.ru/","preview":true}`)
+	resp = get("/" + always)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Contains(t, resp.String(), `href="https://google.ru/"`)
+	stats, _ := clicks.ClickStats(context.Background(), always)
+	assert.Equal(t, 1, stats.Total)
+
+	resp, err := client.R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+		SetBody(`{"preview":false}`).Patch(srv.URL + "/api/user/urls/" + always)
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.NotContains(t, resp.String(), `"preview"`)
+	assert.Equal(t, http.StatusTemporaryRedirect, get("/"+always).StatusCode())
+
+	// Шаблоны из каталога заменяют встроенные
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "preview.html"), []byte(`<p>{{.Domain}} {{.ShortHash}}</p>`), 0o644))
+	pages, err := handlers.NewPreviewPages(dir)
+	assert.NoError(t, err)
+	custom := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/"+plain+"+", nil)
+	routeCtx := chi.NewRouteContext()
+	routeCtx.URLParams.Add("id", plain)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx))
+	handlers.GetPreview(inMemoryStorage, &handlers.Redirects{Pages: pages})(custom, req)
+	assert.Equal(t, "<p>yandex.ru "+plain+"</p>", custom.Body.String())
+
+	_, err = handlers.NewPreviewPages(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestPasswordProtected(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	clicks := storage.NewClicksInMemory()
+	limiter := handlers.NewRateLimiter(handlers.RateLimit{Rate: 0.001, Burst: 2}, 100)
+	proxies, err := handlers.ParseTrustedProxies("127.0.0.1")
+	assert.NoError(t, err)
+
+	routes := chi.NewRouter()
+	// Тестовый клиент выступает доверенным прокси и передает адрес клиента в X-Real-IP
+	routes.Use(func(next http.Handler) http.Handler {
+		return handlers.WithRealIP(next.ServeHTTP, proxies)
+	})
+	routes.Get("/{id}", handlers.GetURL(inMemoryStorage, clicks, nil))
+	routes.Post("/{id}", handlers.CheckPassword(inMemoryStorage, clicks, limiter, nil))
+	routes.Get("/{id}+", handlers.GetPreview(inMemoryStorage, nil))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, nil, false, nil))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	client := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy())
+	shorten := func(body string) string {
+		var result models.ResponseShortURL
+		resp, err := client.R().SetHeader("Content-Type", "application/json").SetBody(body).Post(srv.URL + "/api/shorten")
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+		assert.NoError(t, json.Unmarshal(resp.Body(), &result))
+		return strings.TrimPrefix(result.Result, testBaseURL+"/")
+	}
+	submit := func(id string, password string, ip string) *resty.Response {
+		// Ошибка перенаправления без перехода ожидаема
+		resp, _ := client.R().SetHeader("X-Real-IP", ip).SetFormData(map[string]string{"password": password}).
+			Post(srv.URL + "/" + id)
+		return resp
+	}
+
+	protected := shorten(`{"url":"https://intranet.example.com/wiki","password":"s3cret"}`)
+	link, err := inMemoryStorage.GetLink(context.Background(), protected)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link.PasswordHash, "$2a$"), "хранится только хеш bcrypt")
+
+	// Форма пароля вместо перехода и предпросмотра, ссылка не раскрывается
+	for _, path := range []string{"/" + protected, "/" + protected + "+"} {
+		resp, _ := client.R().Get(srv.URL + path)
+		assert.Equal(t, http.StatusOK, resp.StatusCode(), path)
+		assert.Equal(t, "no-store", resp.Header().Get("Cache-Control"))
+		assert.Contains(t, resp.String(), `<form method="post" action="`+protected+`">`)
+		assert.NotContains(t, resp.String(), "intranet.example.com")
+	}
+
+	resp := submit(protected, "wrong", "10.0.0.1")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+	assert.Contains(t, resp.String(), "Неверный пароль")
+	resp = submit(protected, "s3cret", "10.0.0.1")
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode())
+	assert.Equal(t, "https://intranet.example.com/wiki", resp.Header().Get("Location"))
+	stats, _ := clicks.ClickStats(context.Background(), protected)
+	assert.Equal(t, 1, stats.Total, "учитывается только переход с верным паролем")
+
+	// Верный пароль сбрасывает счетчик, неверные ограничиваются по ссылке и клиенту
+	assert.Equal(t, http.StatusForbidden, submit(protected, "wrong", "10.0.0.1").StatusCode())
+	assert.Equal(t, http.StatusForbidden, submit(protected, "wrong", "10.0.0.1").StatusCode())
+	resp = submit(protected, "s3cret", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusSeeOther, submit(protected, "s3cret", "10.0.0.2").StatusCode())
+	other := shorten(`{"url":"https://intranet.example.com/docs","password":"other"}`)
+	assert.Equal(t, http.StatusSeeOther, submit(other, "other", "10.0.0.1").StatusCode())
+
+	// Ссылка с паролем и предпросмотром после верного пароля показывает страницу предпросмотра
+	previewed := shorten(`{"url":"https://intranet.example.com/plans","password":"s3cret","preview":true}`)
+	resp = submit(previewed, "s3cret", "10.0.0.3")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Empty(t, resp.Header().Get("Location"))
+	assert.Contains(t, resp.String(), "https://intranet.example.com/plans")
+	assert.NotContains(t, resp.String(), "<form")
+
+	// Ссылка без пароля открывается сразу
+	plain := shorten(`{"url":"https://yandex.ru/"}`)
+	resp, _ = client.R().Get(srv.URL + "/" + plain)
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode())
+	assert.Equal(t, http.StatusSeeOther, submit(plain, "", "10.0.0.1").StatusCode())
+	assert.Equal(t, http.StatusNotFound, submit("unknown", "s3cret", "10.0.0.1").StatusCode())
+
+	// Пароль в пакетном запросе и слишком длинный пароль
+	resp, err = client.R().SetHeader("Content-Type", "application/json").
+		SetBody(`[{"correlation_id":"locked-1","original_url":"https://intranet.example.com/batch","password":"batch"}]`).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.Equal(t, http.StatusForbidden, submit("locked-1", "wrong", "10.0.0.3").StatusCode())
+	assert.Equal(t, http.StatusSeeOther, submit("locked-1", "batch", "10.0.0.3").StatusCode())
+
+	resp, err = client.R().SetHeader("Content-Type", "application/json").
+		SetBody(`{"url":"https://intranet.example.com/long","password":"` + strings.Repeat("x", 73) + `"}`).
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+}
+
+func TestRealIP(t *testing.T) {
+
+	proxies, err := handlers.ParseTrustedProxies("10.0.0.1, 192.168.0.0/16")
+	assert.NoError(t, err)
+	_, err = handlers.ParseTrustedProxies("10.0.0.300")
+	assert.Error(t, err)
+
+	var remoteAddr string
+	handler := handlers.WithRealIP(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}, proxies)
+
+	testCases := []struct {
+		name     string
+		peer     string
+		realIP   string
+		expected string
+	}{
+		{name: "trusted proxy", peer: "10.0.0.1:5000", realIP: "203.0.113.7", expected: "203.0.113.7:0"},
+		{name: "trusted network", peer: "192.168.1.10:5000", realIP: "203.0.113.7", expected: "203.0.113.7:0"},
+		{name: "untrusted client", peer: "198.51.100.1:5000", realIP: "203.0.113.7", expected: "198.51.100.1:5000"},
+		{name: "bad header", peer: "10.0.0.1:5000", realIP: "not-an-ip", expected: "10.0.0.1:5000"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.peer
+			req.Header.Set("X-Real-IP", tc.realIP)
+			handler(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.expected, remoteAddr)
+		})
+	}
+}
+
+func TestRedirectType(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	rec := httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	userCookie := rec.Result().Cookies()[0]
+
+	routes := chi.NewRouter()
+	redirects := &handlers.Redirects{Options: handlers.RedirectOptions{Default: http.StatusFound, MaxAge: time.Hour}}
+	routes.Get("/{id}", handlers.GetURL(inMemoryStorage, nil, redirects))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, nil, false, nil))
+	routes.Patch("/api/user/urls/{id}", handlers.Auth(handlers.UpdateURL(inMemoryStorage, testBaseURL, nil, false)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	client := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy())
+	shorten := func(body string) *resty.Response {
+		resp, err := client.R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+			SetBody(body).Post(srv.URL + "/api/shorten")
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		return resp
+	}
+	shortHash := func(resp *resty.Response) string {
+		var result models.ResponseShortURL
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+		assert.NoError(t, json.Unmarshal(resp.Body(), &result))
+		return strings.TrimPrefix(result.Result, testBaseURL+"/")
+	}
+	get := func(id string) *resty.Response {
+		resp, _ := client.R().Get(srv.URL + "/" + id)
+		return resp
+	}
+
+	// Код по умолчанию из настроек, временное перенаправление не кешируется
+	plain := shortHash(shorten(`{"url":"https://yandex.ru/"}`))
+	resp := get(plain)
+	assert.Equal(t, http.StatusFound, resp.StatusCode())
+	assert.Equal(t, "https://yandex.ru/", resp.Header().Get("Location"))
+	assert.Equal(t, "no-cache", resp.Header().Get("Cache-Control"))
+
+	// Постоянное перенаправление кешируется, но не дольше срока действия ссылки
+	permanent := shortHash(shorten(`{"url":"https://google.ru/","redirect_type":301}`))
+	resp = get(permanent)
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode())
+	assert.Equal(t, "public, max-age=3600", resp.Header().Get("Cache-Control"))
+	expiring := shortHash(shorten(`{"url":"https://ya.ru/","redirect_type":308,"ttl":600}`))
+	resp = get(expiring)
+	assert.Equal(t, http.StatusPermanentRedirect, resp.StatusCode())
+	maxAge, _ := strconv.Atoi(strings.TrimPrefix(resp.Header().Get("Cache-Control"), "public, max-age="))
+	assert.InDelta(t, 600, maxAge, 5)
+
+	// Изменение кода владельцем, 0 возвращает код по умолчанию
+	patch := func(id string, body string) *resty.Response {
+		resp, err := client.R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+			SetBody(body).Patch(srv.URL + "/api/user/urls/" + id)
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		return resp
+	}
+	resp = patch(plain, `{"redirect_type":307}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Contains(t, resp.String(), `"redirect_type":307`)
+	assert.Equal(t, http.StatusTemporaryRedirect, get(plain).StatusCode())
+	assert.Equal(t, http.StatusOK, patch(permanent, `{"redirect_type":0}`).StatusCode())
+	assert.Equal(t, http.StatusFound, get(permanent).StatusCode())
+	assert.Equal(t, http.StatusBadRequest, patch(plain, `{"redirect_type":303}`).StatusCode())
+
+	// Недопустимый код при создании, код в пакетном запросе
+	assert.Equal(t, http.StatusBadRequest, shorten(`{"url":"https://ya.ru/other","redirect_type":200}`).StatusCode())
+	resp, err := client.R().SetHeader("Content-Type", "application/json").
+		SetBody(`[{"correlation_id":"seo-1","original_url":"https://ya.ru/seo","redirect_type":308},
+			{"correlation_id":"seo-2","original_url":"https://ya.ru/seo2","redirect_type":304}]`).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	assert.Contains(t, resp.String(), "for seo-2")
+	resp, err = client.R().SetHeader("Content-Type", "application/json").
+		SetBody(`[{"correlation_id":"seo-1","original_url":"https://ya.ru/seo","redirect_type":308}]`).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.Equal(t, http.StatusPermanentRedirect, get("seo-1").StatusCode())
+}
+
+func TestQueryRules(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	rec := httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	userCookie := rec.Result().Cookies()[0]
+
+	passthrough := true
+	globalRules := storage.QueryRules{Passthrough: &passthrough, Params: map[string]string{"utm_source": "short"}}
+	routes := chi.NewRouter()
+	redirects := &handlers.Redirects{QueryRules: globalRules}
+	routes.Get("/{id}", handlers.GetURL(inMemoryStorage, nil, redirects))
+	routes.Post("/{id}", handlers.CheckPassword(inMemoryStorage, nil, nil, redirects))
+	routes.Get("/{id}+", handlers.GetPreview(inMemoryStorage, redirects))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, nil, false, nil))
+	routes.Patch("/api/user/urls/{id}", handlers.Auth(handlers.UpdateURL(inMemoryStorage, testBaseURL, nil, false)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	client := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy())
+	shorten := func(body string) *resty.Response {
+		resp, err := client.R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+			SetBody(body).Post(srv.URL + "/api/shorten")
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		return resp
+	}
+	shortHash := func(resp *resty.Response) string {
+		var result models.ResponseShortURL
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+		assert.NoError(t, json.Unmarshal(resp.Body(), &result))
+		return strings.TrimPrefix(result.Result, testBaseURL+"/")
+	}
+	location := func(path string) string {
+		// Ошибка перенаправления без перехода ожидаема
+		resp, _ := client.R().Get(srv.URL + "/" + path)
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode(), path)
+		return resp.Header().Get("Location")
+	}
+
+	// Общие правила: запрос передается, параметр ссылки побеждает, фрагмент остается в конце
+	plain := shortHash(shorten(`{"url":"https://yandex.ru/page?ref=1#top"}`))
+	assert.Equal(t, "https://yandex.ru/page?ref=1&utm_source=short#top", location(plain))
+	assert.Equal(t, "https://yandex.ru/page?ref=1&utm_source=short&q=%D0%BF+%26&x#top",
+		location(plain+"?ref=2&q=%D0%BF%20%26&x&utm_source=ads&bad=%zz"))
+
+	// Правила ссылки поверх общих: без передачи запроса, общий параметр отключен
+	campaign := shortHash(shorten(`{"url":"https://yandex.ru/news",
+		"query_rules":{"passthrough":false,"params":{"utm_source":"","utm_campaign":"весна"}}}`))
+	assert.Equal(t, "https://yandex.ru/news?utm_campaign=%D0%B2%D0%B5%D1%81%D0%BD%D0%B0", location(campaign+"?ref=2"))
+
+	// Побеждает запрос перехода
+	ads := shortHash(shorten(`{"url":"https://yandex.ru/?utm_source=mail&id=7","query_rules":{"conflict":"request"}}`))
+	assert.Equal(t, "https://yandex.ru/?id=7&utm_source=ads", location(ads+"?utm_source=ads"))
+	assert.Equal(t, "https://yandex.ru/?utm_source=mail&id=7", location(ads))
+
+	// Правила в ответе, изменение и сброс владельцем
+	patch := func(id string, body string) *resty.Response {
+		resp, err := client.R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+			SetBody(body).Patch(srv.URL + "/api/user/urls/" + id)
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		return resp
+	}
+	resp := patch(plain, `{"query_rules":{"params":{"utm_medium":"link"}}}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Contains(t, resp.String(), `"query_rules":{"params":{"utm_medium":"link"}}`)
+	assert.Equal(t, "https://yandex.ru/page?ref=1&utm_medium=link&utm_source=short#top", location(plain))
+	resp = patch(plain, `{"query_rules":{}}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.NotContains(t, resp.String(), "query_rules")
+	assert.Equal(t, "https://yandex.ru/page?ref=1&utm_source=short#top", location(plain))
+	assert.Equal(t, http.StatusBadRequest, patch(plain, `{"query_rules":{"conflict":"both"}}`).StatusCode())
+
+	// Предпросмотр и форма пароля ведут по тем же правилам
+	preview, _ := client.R().Get(srv.URL + "/" + campaign + "+")
+	assert.Equal(t, http.StatusOK, preview.StatusCode())
+	assert.Contains(t, preview.String(), `href="https://yandex.ru/news?utm_campaign=%D0%B2%D0%B5%D1%81%D0%BD%D0%B0"`)
+	protected := shortHash(shorten(`{"url":"https://yandex.ru/secret","password":"s3cret"}`))
+	form, _ := client.R().Get(srv.URL + "/" + protected + "?ref=2")
+	assert.Contains(t, form.String(), `action="`+protected+`?ref=2"`)
+	resp, _ = client.R().SetFormData(map[string]string{"password": "s3cret"}).Post(srv.URL + "/" + protected + "?ref=2")
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode())
+	assert.Equal(t, "https://yandex.ru/secret?utm_source=short&ref=2", resp.Header().Get("Location"))
+
+	// Недопустимые правила при создании и в пакетном запросе
+	assert.Equal(t, http.StatusBadRequest, shorten(`{"url":"https://yandex.ru/bad","query_rules":{"params":{"":"x"}}}`).StatusCode())
+	resp, err := client.R().SetHeader("Content-Type", "application/json").
+		SetBody(`[{"correlation_id":"utm-1","original_url":"https://ya.ru/a","query_rules":{"params":{"utm_source":"batch"}}},
+			{"correlation_id":"utm-2","original_url":"https://ya.ru/b","query_rules":{"conflict":"none"}}]`).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	assert.Contains(t, resp.String(), "for utm-2")
+	resp, err = client.R().SetHeader("Content-Type", "application/json").
+		SetBody(`[{"correlation_id":"utm-1","original_url":"https://ya.ru/a","query_rules":{"params":{"utm_source":"batch"}}}]`).
+		Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.Equal(t, "https://ya.ru/a?utm_source=batch", location("utm-1"))
+}
+
+func TestQuota(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	inMemoryStorage.SetLinkLimits(storage.LinkLimits{Total: 3, Daily: 2})
+	quotas := handlers.NewQuotas(handlers.QuotaLimits{Total: 3, Daily: 2, Batch: 2}, inMemoryStorage)
+
+	rec := httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	userCookie := rec.Result().Cookies()[0]
+
+	routes := chi.NewRouter()
+	routes.Post("/", handlers.Auth(handlers.ShorterURL(inMemoryStorage, testBaseURL, nil, false)))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, nil, false, quotas))
+	routes.Get("/api/user/quota", handlers.Auth(handlers.GetQuota(quotas)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	// Пакет больше ограничения
+	batch := "[{\"correlation_id\":\"a1\",\"original_url\":\"http://a1.ru\"},{\"correlation_id\":\"a2\",\"original_url\":\"http://a2.ru\"},{\"correlation_id\":\"a3\",\"original_url\":\"http://a3.ru\"}]"
+	resp, err := resty.New().R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").SetBody(batch).Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+	assert.JSONEq(t, "{\"error\":\"quota_exceeded\",\"limit\":\"batch\",\"max\":2,\"used\":0,\"requested\":3}", string(resp.Body()))
+
+	resp, err = resty.New().R().SetCookie(userCookie).SetBody("https://yandex.ru/").Post(srv.URL + "/")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+
+	// Повторная ссылка не расходует квоту
+	resp, _ = resty.New().R().SetCookie(userCookie).SetBody("https://yandex.ru/").Post(srv.URL + "/")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode())
+	resp, _ = resty.New().R().SetCookie(userCookie).SetBody("https://google.ru/").Post(srv.URL + "/")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+
+	// Суточная квота исчерпана
+	resp, err = resty.New().R().SetCookie(userCookie).SetBody("https://mail.ru/").Post(srv.URL + "/")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	var exceeded models.ResponseQuotaExceeded
+	assert.NoError(t, json.Unmarshal(resp.Body(), &exceeded))
+	assert.Equal(t, handlers.QuotaDaily, exceeded.Limit)
+	assert.Equal(t, 2, exceeded.Used)
+	resp, err = resty.New().R().SetCookie(userCookie).SetHeader("Content-Type", "application/json").
+		SetBody("[{\"correlation_id\":\"b1\",\"original_url\":\"http://b1.ru\"}]").Post(srv.URL + "/api/shorten/batch")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+	_, found := inMemoryStorage.Get(context.Background(), "b1")
+	assert.False(t, found)
+
+	resp, err = resty.New().R().SetCookie(userCookie).Get(srv.URL + "/api/user/quota")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var usage models.ResponseQuota
+	assert.NoError(t, json.Unmarshal(resp.Body(), &usage))
+	assert.Equal(t, models.QuotaUsage{Limit: 3, Used: 2}, usage.Total)
+	assert.Equal(t, 2, usage.Daily.Used)
+	assert.Equal(t, 2, usage.BatchLimit)
+
+	// Общая квота не зависит от суток, ограничения проверяются хранилищем
+	inMemoryStorage.SetLinkLimits(storage.LinkLimits{Total: 2})
+	routes = chi.NewRouter()
+	routes.Post("/", handlers.Auth(handlers.ShorterURL(inMemoryStorage, testBaseURL, nil, false)))
+	totalSrv := httptest.NewServer(routes)
+	defer totalSrv.Close()
+	resp, err = resty.New().R().SetCookie(userCookie).SetBody("https://mail.ru/").Post(totalSrv.URL + "/")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode())
+	assert.JSONEq(t, "{\"error\":\"quota_exceeded\",\"limit\":\"total\",\"max\":2,\"used\":2,\"requested\":1}", string(resp.Body()))
+}
+
+func TestRateLimit(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	limiter := handlers.NewRateLimiter(handlers.RateLimit{Rate: 0.01, Burst: 2}, 1)
+	proxies, _ := handlers.ParseTrustedProxies("127.0.0.1")
+
+	routes := chi.NewRouter()
+	routes.Post("/", handlers.WithRealIP(handlers.WithRateLimit(handlers.Auth(handlers.ShorterURL(inMemoryStorage, testBaseURL, nil, false)), limiter), proxies))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	for i, remaining := range []string{"1", "0"} {
+		resp, err := resty.New().R().SetHeader("X-Real-IP", "10.0.0.1").SetBody(fmt.Sprintf("https://yandex.ru/%d", i)).Post(srv.URL + "/")
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		assert.Equal(t, http.StatusCreated, resp.StatusCode())
+		assert.Equal(t, "2", resp.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, remaining, resp.Header().Get("RateLimit-Remaining"))
+	}
+
+	resp, err := resty.New().R().SetHeader("X-Real-IP", "10.0.0.1").SetBody("https://yandex.ru/2").Post(srv.URL + "/")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+	assert.Equal(t, "100", resp.Header().Get("Retry-After"))
+
+	// Новая кука не сбрасывает ограничение адреса
+	rec := httptest.NewRecorder()
+	handlers.SetNewCookie(rec)
+	resp, err = resty.New().R().SetHeader("X-Real-IP", "10.0.0.1").SetCookie(rec.Result().Cookies()[0]).SetBody("https://yandex.ru/3").Post(srv.URL + "/")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+
+	// При заполненной таблице новый клиент вытесняет самую давно использованную корзину
+	resp, err = resty.New().R().SetHeader("X-Real-IP", "10.0.0.2").SetBody("https://yandex.ru/4").Post(srv.URL + "/")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.Equal(t, "1", resp.Header().Get("RateLimit-Remaining"))
+
+	// Вытеснение выбирает корзину, к которой дольше всего не обращались
+	lru := handlers.NewRateLimiter(handlers.RateLimit{Rate: 0.001, Burst: 1}, 2)
+	assert.True(t, lru.Allow("ip:10.0.0.1").Allowed)
+	assert.True(t, lru.Allow("ip:10.0.0.2").Allowed)
+	assert.False(t, lru.Allow("ip:10.0.0.1").Allowed)
+	assert.True(t, lru.Allow("ip:10.0.0.3").Allowed)
+	assert.False(t, lru.Allow("ip:10.0.0.1").Allowed)
+	assert.True(t, lru.Allow("ip:10.0.0.2").Allowed)
+}
+
+func TestURLValidation(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+
+	routes := chi.NewRouter()
+	routes.Post("/", handlers.Auth(handlers.ShorterURL(inMemoryStorage, testBaseURL, nil, false)))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, nil, false, nil))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	testCases := []struct {
+		path         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{path: "/", body: "not a url", expectedCode: http.StatusBadRequest, expectedBody: "Invalid URL: missing scheme, expected http or https\n"},
+		{path: "/", body: "javascript:alert(1)", expectedCode: http.StatusBadRequest, expectedBody: "Invalid URL: unsupported scheme \"javascript\", expected http or https\n"},
+		{path: "/", body: "  HTTPS://Yandex.RU:443  ", expectedCode: http.StatusCreated, expectedBody: "http://localhost:8080/77fca5950e"},
+		{path: "/", body: "https://yandex.ru", expectedCode: http.StatusConflict, expectedBody: "http://localhost:8080/77fca5950e"},
+		{path: "/api/shorten", body: "{\"url\":\"https://YANDEX.ru/\"}", expectedCode: http.StatusConflict, expectedBody: "{\"result\":\"http://localhost:8080/77fca5950e\"}"},
+		{path: "/api/shorten", body: "{\"url\":\"ftp://ya.ru/\"}", expectedCode: http.StatusBadRequest, expectedBody: "Invalid URL: unsupported scheme \"ftp\", expected http or https\n"},
+		{path: "/api/shorten/batch", body: "[{\"correlation_id\":\"a1\",\"original_url\":\"http://\"}]", expectedCode: http.StatusBadRequest, expectedBody: "Invalid URL for a1: missing host\n"},
+	}
+	for _, tc := range testCases {
+		resp, err := resty.New().R().SetHeader("Content-Type", "application/json").SetBody(tc.body).Post(srv.URL + tc.path)
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		assert.Equal(t, tc.expectedCode, resp.StatusCode(), tc.body)
+		assert.Equal(t, tc.expectedBody, string(resp.Body()), tc.body)
+	}
+}
+
+func TestDomainPolicy(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	policyFile := filepath.Join(t.TempDir(), "policy.txt")
+	os.WriteFile(policyFile, []byte("deny *.phishing.example\n"), 0666)
+	policy, err := storage.NewDomainPolicy(policyFile, true)
+	assert.NoError(t, err)
+
+	routes := chi.NewRouter()
+	routes.Post("/", handlers.Auth(handlers.ShorterURL(inMemoryStorage, testBaseURL, policy, false)))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, policy, false))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, policy, false, nil))
+	routes.Post("/default", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	testCases := []struct {
+		path         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{path: "/", body: "http://localhost:8080/admin", expectedCode: http.StatusUnprocessableEntity, expectedBody: "{\"error\":\"policy_violation\",\"host\":\"localhost\",\"rule\":\"private address\"}"},
+		{path: "/api/shorten", body: "{\"url\":\"http://10.0.0.1/\"}", expectedCode: http.StatusUnprocessableEntity, expectedBody: "{\"error\":\"policy_violation\",\"host\":\"10.0.0.1\",\"rule\":\"private address\"}"},
+		{path: "/api/shorten/batch", body: "[{\"correlation_id\":\"a1\",\"original_url\":\"https://login.phishing.example/\"}]", expectedCode: http.StatusUnprocessableEntity, expectedBody: "{\"error\":\"policy_violation\",\"host\":\"login.phishing.example\",\"rule\":\"deny *.phishing.example\",\"correlation_id\":\"a1\"}"},
+		{path: "/api/shorten", body: "{\"url\":\"https://yandex.ru/\"}", expectedCode: http.StatusCreated, expectedBody: "{\"result\":\"http://localhost:8080/77fca5950e\"}"},
+		// Без политики внутренние адреса все равно запрещены
+		{path: "/default", body: "{\"url\":\"http://127.0.0.1/\"}", expectedCode: http.StatusUnprocessableEntity, expectedBody: "{\"error\":\"policy_violation\",\"host\":\"127.0.0.1\",\"rule\":\"private address\"}"},
+	}
+	for _, tc := range testCases {
+		resp, err := resty.New().R().SetHeader("Content-Type", "application/json").SetBody(tc.body).Post(srv.URL + tc.path)
+		assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+		assert.Equal(t, tc.expectedCode, resp.StatusCode(), tc.body)
+		assert.JSONEq(t, tc.expectedBody, string(resp.Body()), tc.body)
+	}
+}
+
 func TestGzipCompression(t *testing.T) {
 	userUID := uuid.New().String()
 	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
-	shortString, _ := inMemoryStorage.Save("https://practicum.yandex.ru/", userUID)
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://practicum.yandex.ru/", userUID)
 	assert.Equal(t, shortString, "42b3e75f92")
 
 	testCases := []struct {
@@ -185,7 +1358,7 @@ func TestGzipCompression(t *testing.T) {
 	}
 
 	routes := chi.NewRouter()
-	routes.Post("/api/shorten", handlers.GzipCompress(handlers.ObjectShorterURL(inMemoryStorage, testBaseURL)))
+	routes.Post("/api/shorten", handlers.GzipCompress(handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false)))
 	srv := httptest.NewServer(routes)
 
 	defer srv.Close()
@@ -246,7 +1419,7 @@ func TestAuthApiShorten(t *testing.T) {
 	}
 
 	routes := chi.NewRouter()
-	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(inMemoryStorage, testBaseURL, nil, false))
 	routes.Get("/api/user/urls", handlers.CheckSignedCookie(handlers.Auth(handlers.GetURLs(inMemoryStorage, testBaseURL))))
 
 	srv := httptest.NewServer(routes)
@@ -307,7 +1480,7 @@ func TestBatchDelete(t *testing.T) {
 	inputCh := make(chan []string, 10000)
 
 	routes := chi.NewRouter()
-	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL))
+	routes.Post("/api/shorten/batch", handlers.ObjectsShorterURL(inMemoryStorage, testBaseURL, nil, false, nil))
 	routes.Delete("/api/user/urls", handlers.Auth(handlers.DeleteURLs(mainStorage, inputCh)))
 	srv := httptest.NewServer(routes)
 	defer srv.Close()
@@ -366,12 +1539,25 @@ func TestInitRoutes(t *testing.T) {
 	appSettings := config.ParseFlags()
 	lengthInputCh := 1000
 	inputCh := make(chan []string, lengthInputCh)
-	mainStorage, _ = storage.NewStorageInMemory(lengthShortURL)
+	mainStorage, _ = storage.NewStorageInMemory(appSettings.LengthShortURL)
 	routes := chi.NewRouter()
 
-	err := initRoutes(routes, appSettings, logger, inputCh, mainStorage)
+	err := initRoutes(routes, appSettings, logger, inputCh, mainStorage, storage.NewClicksInMemory())
 	assert.NoError(t, err)
 
+	appSettings.RedirectType = http.StatusSeeOther
+	err = initRoutes(chi.NewRouter(), appSettings, logger, inputCh, mainStorage, storage.NewClicksInMemory())
+	assert.Error(t, err, "недопустимый код перенаправления")
+
+	appSettings.RedirectType = http.StatusTemporaryRedirect
+	appSettings.QueryConflict = "both"
+	err = initRoutes(chi.NewRouter(), appSettings, logger, inputCh, mainStorage, storage.NewClicksInMemory())
+	assert.Error(t, err, "недопустимая сторона при совпадении параметров")
+	appSettings.QueryConflict = ""
+	appSettings.QueryParams = "utm_source=%zz"
+	err = initRoutes(chi.NewRouter(), appSettings, logger, inputCh, mainStorage, storage.NewClicksInMemory())
+	assert.Error(t, err, "недопустимые добавляемые параметры")
+
 }
 
 func findInCookie(resp *resty.Response) (string, bool) { // userUUID, bool
@@ -383,3 +1569,145 @@ func findInCookie(resp *resty.Response) (string, bool) { // userUUID, bool
 	}
 	return "", false
 }
+
+// slowStorage - хранилище, операции которого завершаются только по отмене контекста.
+type slowStorage struct {
+	*storage.StorageInMemory
+}
+
+func (s *slowStorage) GetLink(ctx context.Context, hashKey string) (storage.ShortHashURL, error) {
+	<-ctx.Done()
+	return storage.ShortHashURL{}, storage.NewStorageError(ctx.Err())
+}
+
+func (s *slowStorage) SaveLink(ctx context.Context, value string, alias string, userUID string,
+	options storage.LinkOptions) (string, error) {
+	<-ctx.Done()
+	return "", storage.NewStorageError(ctx.Err())
+}
+
+func TestStorageTimeout(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	slow := &slowStorage{inMemoryStorage}
+
+	routes := chi.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return handlers.WithStorageTimeout(next.ServeHTTP, 10*time.Millisecond)
+	})
+	routes.Get("/{id}", handlers.GetURL(slow, nil, nil))
+	routes.Post("/api/shorten", handlers.ObjectShorterURL(slow, testBaseURL, nil, false))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	resp, err := resty.New().R().Get(srv.URL + "/NotExist")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode())
+
+	resp, err = resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetBody("{\"url\":\"https://google.ru/\"}").
+		Post(srv.URL + "/api/shorten")
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode())
+}
+
+// delayedStorage - хранилище, чтение и изменение ссылки в котором занимают delay.
+type delayedStorage struct {
+	*storage.StorageInMemory
+	delay time.Duration
+}
+
+func (s *delayedStorage) wait(ctx context.Context) error {
+	select {
+	case <-time.After(s.delay):
+		return nil
+	case <-ctx.Done():
+		return storage.NewStorageError(ctx.Err())
+	}
+}
+
+func (s *delayedStorage) GetLink(ctx context.Context, hashKey string) (storage.ShortHashURL, error) {
+	if err := s.wait(ctx); err != nil {
+		return storage.ShortHashURL{}, err
+	}
+	return s.StorageInMemory.GetLink(ctx, hashKey)
+}
+
+func (s *delayedStorage) UpdateByUser(ctx context.Context, hashKey string, patch storage.LinkPatch, userUID string) error {
+	if err := s.wait(ctx); err != nil {
+		return err
+	}
+	return s.StorageInMemory.UpdateByUser(ctx, hashKey, patch, userUID)
+}
+
+// TestStorageTimeoutPerOperation - срок отсчитывается для каждой операции, а не для всего запроса.
+func TestStorageTimeoutPerOperation(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	delayed := &delayedStorage{StorageInMemory: inMemoryStorage, delay: 40 * time.Millisecond}
+	rec := httptest.NewRecorder()
+	userUID, _ := handlers.SetNewCookie(rec)
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+
+	routes := chi.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return handlers.WithStorageTimeout(next.ServeHTTP, 100*time.Millisecond)
+	})
+	routes.Patch("/api/user/urls/{id}", handlers.Auth(handlers.UpdateURL(delayed, testBaseURL, nil, false)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	// Чтение, изменение и повторное чтение по 40 мс дольше срока, но каждое в него укладывается
+	resp, err := resty.New().R().SetCookie(rec.Result().Cookies()[0]).
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"tags":["news"]}`).
+		Patch(srv.URL + "/api/user/urls/" + shortString)
+	assert.NoError(t, err, "ошибка при отправке HTTP-запроса")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+}
+
+// countingStorage - хранилище, считающее чтения ссылки.
+type countingStorage struct {
+	*storage.StorageInMemory
+	reads atomic.Int32
+}
+
+func (s *countingStorage) Get(ctx context.Context, hashKey string) (string, bool) {
+	s.reads.Add(1)
+	return s.StorageInMemory.Get(ctx, hashKey)
+}
+
+func (s *countingStorage) GetLink(ctx context.Context, hashKey string) (storage.ShortHashURL, error) {
+	s.reads.Add(1)
+	return s.StorageInMemory.GetLink(ctx, hashKey)
+}
+
+func (s *countingStorage) IsDeleted(ctx context.Context, hashKey string) (bool, error) {
+	s.reads.Add(1)
+	return s.StorageInMemory.IsDeleted(ctx, hashKey)
+}
+
+// TestRedirectSingleRead - переход по ссылке читает ее из хранилища один раз.
+func TestRedirectSingleRead(t *testing.T) {
+
+	inMemoryStorage, _ := storage.NewStorageInMemory(testLengthShortURL)
+	counting := &countingStorage{StorageInMemory: inMemoryStorage}
+	ctx := context.Background()
+	passthrough := true
+	shortString, _ := inMemoryStorage.SaveLink(ctx, "https://yandex.ru/", "", "", storage.LinkOptions{
+		ExpiresAt:    time.Now().Add(time.Hour),
+		RedirectType: http.StatusMovedPermanently,
+		QueryRules:   &storage.QueryRules{Passthrough: &passthrough},
+	})
+
+	routes := chi.NewRouter()
+	routes.Get("/{id}", handlers.GetURL(counting, nil, nil))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	resp, _ := resty.New().SetRedirectPolicy(resty.NoRedirectPolicy()).R().Get(srv.URL + "/" + shortString + "?q=1")
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode())
+	assert.Equal(t, "https://yandex.ru/?q=1", resp.Header().Get("Location"))
+	assert.Equal(t, int32(1), counting.reads.Load())
+}
@@ -0,0 +1,103 @@
+// Модуль содержит обработчики аналитики переходов по ссылкам.
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/PerfectStepCoder/shorturl/internal/models"
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultClickHashKey - случайный ключ хеширования адресов, если секрет не задан настройками.
+// Хеши с ним не совпадают между запусками сервиса.
+var defaultClickHashKey = newClickHashKey()
+
+// newClickHashKey - случайный ключ хеширования адресов клиентов.
+func newClickHashKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("click hash key: %s", err))
+	}
+	return key
+}
+
+// hashClientIP - HMAC адреса клиента с секретом key, чтобы не хранить персональные данные.
+// Без секрета адрес восстанавливается перебором адресов, поэтому ключ подписи кук для этого не используется.
+func hashClientIP(ip string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordClick - сохраняет переход по ссылке, адрес клиента хешируется секретом key, ошибки только логируются.
+func recordClick(ctx context.Context, clicks storage.AnalyticsStorage, shortURL string, req *http.Request, key []byte) {
+	if clicks == nil {
+		return
+	}
+	ctx, cancel := storageCall(ctx)
+	defer cancel()
+	err := clicks.RecordClick(ctx, storage.ClickEvent{
+		ShortHash: shortURL,
+		Timestamp: time.Now(),
+		Referrer:  req.Referer(),
+		UserAgent: req.UserAgent(),
+		IPHash:    hashClientIP(clientIP(req), key),
+	})
+	if err != nil {
+		log.Printf("Record click error: %s", err)
+	}
+}
+
+// GetURLStats - возвращает статистику переходов по ссылке ее владельцу.
+func GetURLStats(mainStorage storage.Storage, clicks storage.AnalyticsStorage) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		// Аутентификация
+		userUID := fmt.Sprintf("%s", req.Context().Value(UserKeyUID))
+
+		shortURL := chi.URLParam(req, "id")
+		ctx := req.Context()
+		if _, ok := lookupOwnedLink(ctx, res, mainStorage, shortURL, userUID); !ok {
+			return
+		}
+
+		var stats storage.ClickStats
+		err := callStorage(ctx, func(ctx context.Context) (err error) {
+			stats, err = clicks.ClickStats(ctx, shortURL)
+			return err
+		})
+		if err != nil {
+			log.Printf("Click stats error: %s", err)
+			http.Error(res, "Error", http.StatusInternalServerError)
+			return
+		}
+
+		resp := models.ResponseURLStats{Total: stats.Total, Unique: stats.Unique, Daily: []models.DailyClicks{},
+			Recent: []models.RecentClick{}}
+		for _, day := range stats.Daily {
+			resp.Daily = append(resp.Daily, models.DailyClicks{Date: day.Date, Clicks: day.Clicks})
+		}
+		for _, event := range stats.Recent {
+			resp.Recent = append(resp.Recent, models.RecentClick{Timestamp: event.Timestamp, Referrer: event.Referrer,
+				UserAgent: event.UserAgent})
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		// Cериализуем ответ сервера
+		enc := json.NewEncoder(res)
+		if err := enc.Encode(resp); err != nil {
+			log.Printf("Error writing response: %s", err)
+			return
+		}
+	}
+}
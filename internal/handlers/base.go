@@ -2,12 +2,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/PerfectStepCoder/shorturl/internal/models"
@@ -19,7 +21,9 @@ import (
 const batchSize = 15
 
 // ShorterURL - обработчик ссылок.
-func ShorterURL(mainStorage storage.Storage, baseURL string) http.HandlerFunc {
+// При sortQuery параметры запроса ссылки сортируются, ссылки с разным порядком параметров сохраняются одной записью.
+func ShorterURL(mainStorage storage.Storage, baseURL string, policy *storage.DomainPolicy, sortQuery bool) http.HandlerFunc {
+	policy = defaultPolicy(policy)
 	return func(res http.ResponseWriter, req *http.Request) {
 
 		// Аутентификация
@@ -32,7 +36,22 @@ func ShorterURL(mainStorage storage.Storage, baseURL string) http.HandlerFunc {
 			http.Error(res, "URL not send", http.StatusBadRequest)
 			return
 		}
-		shortURL, err := mainStorage.Save(originURL, userUID)
+		originURL, err := storage.NormalizeURL(originURL, sortQuery)
+		if err != nil {
+			writeInvalidURL(res, err)
+			return
+		}
+		if !checkPolicy(res, policy, originURL, "") {
+			return
+		}
+		expiresAt, err := parseExpirationQuery(req.URL.Query().Get("expires_at"), req.URL.Query().Get("ttl"))
+		if err != nil {
+			http.Error(res, fmt.Sprintf("Bad expiration: %s", err), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := storageContext(req)
+		defer cancel()
+		shortURL, err := mainStorage.SaveLink(ctx, originURL, "", userUID, storage.LinkOptions{ExpiresAt: expiresAt})
 		if err != nil {
 			var ue *storage.UniqURLError
 			if errors.As(err, &ue) {
@@ -41,6 +60,11 @@ func ShorterURL(mainStorage storage.Storage, baseURL string) http.HandlerFunc {
 				res.Write([]byte(originShortURL))
 				return
 			}
+			if quotaExceeded(res, userUID, err) {
+				return
+			}
+			writeStorageError(res, err)
+			return
 		}
 		shortURLfull := strings.TrimSuffix(fmt.Sprintf("%s/%s", baseURL, shortURL), "\n")
 		res.WriteHeader(http.StatusCreated)
@@ -52,7 +76,12 @@ func ShorterURL(mainStorage storage.Storage, baseURL string) http.HandlerFunc {
 }
 
 // GetURL - возвращает оригинальную ссылку по передаваемой сокращенной ссылке.
-func GetURL(storage storage.Storage) http.HandlerFunc {
+// Каждый переход сохраняется в clicks, если хранилище аналитики передано.
+// Для ссылки с включенным предпросмотром вместо перенаправления отдается страница предпросмотра,
+// для ссылки с паролем - форма ввода пароля, переход учитывается после верного пароля.
+// Код перенаправления задается ссылкой или настройками переходов redirects, без redirects - настройки по умолчанию.
+func GetURL(storage storage.Storage, clicks storage.AnalyticsStorage, redirects *Redirects) http.HandlerFunc {
+	settings := defaultRedirects(redirects)
 	return func(res http.ResponseWriter, req *http.Request) {
 
 		shortURL := chi.URLParam(req, "id")
@@ -60,23 +89,71 @@ func GetURL(storage storage.Storage) http.HandlerFunc {
 			http.Error(res, "ShortURL not send", http.StatusBadRequest)
 			return
 		}
-		originURL, exists := storage.Get(shortURL)
-		if !exists {
-			http.Error(res, "Not Found", http.StatusNotFound)
+		ctx := req.Context()
+		link, ok := lookupActiveLink(ctx, res, storage, shortURL)
+		if !ok {
 			return
 		}
-		result, _ := storage.IsDeleted(shortURL)
-		if result {
-			res.WriteHeader(http.StatusGone)
+		if requirePassword(res, req, link, settings.Pages) {
+			return
+		}
+		recordClick(ctx, clicks, shortURL, req, settings.ClickHashKey)
+		if link.Preview {
+			writePreview(res, req, link, settings)
 			return
 		}
-		res.Header().Set("Location", originURL)
-		res.WriteHeader(http.StatusTemporaryRedirect)
+		writeRedirect(res, req, link, settings)
+	}
+}
+
+// lookupLink - ссылка со всеми полями одним чтением из хранилища.
+// Для неизвестной ссылки отвечает 404 и возвращает false.
+func lookupLink(ctx context.Context, res http.ResponseWriter, mainStorage storage.Storage, shortURL string) (storage.ShortHashURL, bool) {
+	ctx, cancel := storageCall(ctx)
+	defer cancel()
+	link, err := mainStorage.GetLink(ctx, shortURL)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(res, "Not Found", http.StatusNotFound)
+		return link, false
+	}
+	if err != nil {
+		writeStorageError(res, err)
+		return link, false
 	}
+	return link, true
+}
+
+// lookupActiveLink - ссылка для перехода по короткой.
+// Для неизвестной ссылки отвечает 404, для удаленной или истекшей 410 и возвращает false.
+func lookupActiveLink(ctx context.Context, res http.ResponseWriter, mainStorage storage.Storage, shortURL string) (storage.ShortHashURL, bool) {
+	link, ok := lookupLink(ctx, res, mainStorage, shortURL)
+	if !ok {
+		return link, false
+	}
+	if link.Deleted || link.Expired {
+		res.WriteHeader(http.StatusGone)
+		return link, false
+	}
+	return link, true
+}
+
+// lookupOwnedLink - ссылка пользователя userUID, для чужой ссылки отвечает 403 и возвращает false.
+func lookupOwnedLink(ctx context.Context, res http.ResponseWriter, mainStorage storage.Storage, shortURL string, userUID string) (storage.ShortHashURL, bool) {
+	link, ok := lookupLink(ctx, res, mainStorage, shortURL)
+	if !ok {
+		return link, false
+	}
+	if link.UserUID == "" || link.UserUID != userUID {
+		http.Error(res, "Forbidden", http.StatusForbidden)
+		return link, false
+	}
+	return link, true
 }
 
 // GetURLs - возвращает оригинальные ссылки по передаваемым сокращенным ссылкам.
-func GetURLs(storage storage.Storage, baseURL string) http.HandlerFunc {
+// Без параметров выборки возвращается массив всех ссылок, с любым из параметров limit, cursor, sort, order,
+// deleted, expired, domain, tag - страница ссылок и курсор следующей страницы.
+func GetURLs(mainStorage storage.Storage, baseURL string) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 
 		// Аутентификация
@@ -84,18 +161,46 @@ func GetURLs(storage storage.Storage, baseURL string) http.HandlerFunc {
 
 		var outputURLs []models.ResponseURL
 
-		allURLs, err := storage.FindByUserUID(userUID)
+		values := req.URL.Query()
+		paginated := isPageRequest(values)
+		query := storage.URLQuery{}
+		if paginated {
+			var err error
+			if query, err = parseURLQuery(values); err != nil {
+				http.Error(res, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := storageContext(req)
+		defer cancel()
+		page, err := mainStorage.QueryByUser(ctx, userUID, query)
+		if errors.Is(err, storage.ErrInvalidQuery) {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			http.Error(res, "Error", http.StatusInternalServerError)
+			writeStorageError(res, err)
+			return
 		}
-		for _, url := range allURLs {
-			outputURLs = append(outputURLs, models.ResponseURL{
-				OriginalURL: url.OriginalURL, ShortURL: fmt.Sprintf("%s/%s", baseURL, url.ShortHash),
-			})
+		for _, url := range page.URLs {
+			outputURLs = append(outputURLs, toResponseURL(baseURL, url))
 		}
 
 		res.Header().Set("Content-Type", "application/json")
 
+		if paginated {
+			resp := models.ResponseURLPage{URLs: outputURLs, NextCursor: page.NextCursor}
+			if resp.URLs == nil {
+				resp.URLs = []models.ResponseURL{}
+			}
+			// Cериализуем ответ сервера
+			if err := json.NewEncoder(res).Encode(resp); err != nil {
+				log.Printf("Error writing response: %s", err)
+			}
+			return
+		}
+
 		if len(outputURLs) == 0 {
 			http.Error(res, "NoContent", http.StatusNoContent)
 		} else {
@@ -109,6 +214,45 @@ func GetURLs(storage storage.Storage, baseURL string) http.HandlerFunc {
 	}
 }
 
+// SearchURLs - поиск ссылок пользователя по словам запроса q в оригинальной ссылке и коротком коде.
+// Заметок у ссылок нет, поэтому поиск по ним не выполняется. Ответ - массив найденных ссылок от новых к старым, не больше limit.
+func SearchURLs(mainStorage storage.Storage, baseURL string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		// Аутентификация
+		userUID := fmt.Sprintf("%s", req.Context().Value(UserKeyUID))
+
+		text := strings.TrimSpace(req.URL.Query().Get("q"))
+		if text == "" {
+			http.Error(res, "Empty search query", http.StatusBadRequest)
+			return
+		}
+		query, err := parseURLQuery(url.Values{"limit": req.URL.Query()["limit"]})
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := storageContext(req)
+		defer cancel()
+		found, err := mainStorage.SearchByUser(ctx, userUID, text, query.Limit)
+		if err != nil {
+			writeStorageError(res, err)
+			return
+		}
+		outputURLs := []models.ResponseURL{}
+		for _, item := range found {
+			outputURLs = append(outputURLs, toResponseURL(baseURL, item))
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		// Cериализуем ответ сервера
+		if err := json.NewEncoder(res).Encode(outputURLs); err != nil {
+			log.Printf("Error writing response: %s", err)
+		}
+	}
+}
+
 // DeleteURLs - обработчик удаления ссылок.
 func DeleteURLs(mainStorage storage.Storage, inputCh chan []string) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
@@ -139,6 +283,150 @@ func DeleteURLs(mainStorage storage.Storage, inputCh chan []string) http.Handler
 	}
 }
 
+// RestoreURLs - восстановление удаленных ссылок пользователя.
+func RestoreURLs(mainStorage storage.Storage) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		// Аутентификация
+		userUID := fmt.Sprintf("%s", req.Context().Value(UserKeyUID))
+
+		shortHashs, _ := io.ReadAll(req.Body)
+
+		var shortsHashURL []string
+
+		err := json.Unmarshal(shortHashs, &shortsHashURL)
+		if err != nil {
+			log.Printf("Error parsing JSON: %s", err)
+			http.Error(res, "Bad JSON data", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := storageContext(req)
+		defer cancel()
+
+		if err := mainStorage.RestoreByUser(ctx, shortsHashURL, userUID); err != nil {
+			log.Printf("Restore error: %s", err)
+			writeStorageError(res, err)
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+// UpdateURL - изменение оригинальной ссылки, срока действия и признаков владельцем, короткая ссылка остается прежней.
+// Изменения применяются вместе, удаленную ссылку изменить нельзя - ответ 410.
+// При sortQuery параметры запроса новой ссылки сортируются.
+func UpdateURL(mainStorage storage.Storage, baseURL string, policy *storage.DomainPolicy, sortQuery bool) http.HandlerFunc {
+	policy = defaultPolicy(policy)
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		// Аутентификация
+		userUID := fmt.Sprintf("%s", req.Context().Value(UserKeyUID))
+
+		shortURL := chi.URLParam(req, "id")
+
+		var requestUpdateURL models.RequestUpdateURL
+		if err := json.NewDecoder(req.Body).Decode(&requestUpdateURL); err != nil {
+			log.Printf("Error parsing JSON: %s", err)
+			http.Error(res, "Bad JSON data", http.StatusBadRequest)
+			return
+		}
+		if requestUpdateURL.URL != "" {
+			var err error
+			requestUpdateURL.URL, err = storage.NormalizeURL(requestUpdateURL.URL, sortQuery)
+			if err != nil {
+				writeInvalidURL(res, err)
+				return
+			}
+			if !checkPolicy(res, policy, requestUpdateURL.URL, "") {
+				return
+			}
+		}
+		expiresAt, err := parseExpiration(requestUpdateURL.ExpiresAt, requestUpdateURL.TTL)
+		if err != nil {
+			http.Error(res, fmt.Sprintf("Bad expiration: %s", err), http.StatusBadRequest)
+			return
+		}
+		patch := storage.LinkPatch{OriginalURL: requestUpdateURL.URL, ExpiresAt: expiresAt,
+			Preview: requestUpdateURL.Preview, RedirectType: requestUpdateURL.RedirectType, Notes: requestUpdateURL.Notes}
+		if requestUpdateURL.Tags != nil {
+			tags, valid := normalizeTags(res, *requestUpdateURL.Tags, "")
+			if !valid {
+				return
+			}
+			patch.Tags = &tags
+		}
+		if requestUpdateURL.RedirectType != nil && !validRedirectType(res, *requestUpdateURL.RedirectType, "") {
+			return
+		}
+		if requestUpdateURL.Notes != nil && !validNotes(res, *requestUpdateURL.Notes, "") {
+			return
+		}
+		if requestUpdateURL.QueryRules != nil {
+			queryRules, valid := linkQueryRules(res, requestUpdateURL.QueryRules, "")
+			if !valid {
+				return
+			}
+			// Пустые правила возвращают ссылке общие правила
+			patch.QueryRules = &storage.QueryRules{}
+			if queryRules != nil {
+				patch.QueryRules = queryRules
+			}
+		}
+
+		ctx := req.Context()
+		link, ok := lookupOwnedLink(ctx, res, mainStorage, shortURL, userUID)
+		if !ok {
+			return
+		}
+		if link.Deleted {
+			res.WriteHeader(http.StatusGone)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+
+		// Все изменения применяются одной операцией с хранилищем
+		err = callStorage(ctx, func(ctx context.Context) error {
+			return mainStorage.UpdateByUser(ctx, shortURL, patch, userUID)
+		})
+		var ue *storage.UniqURLError
+		if errors.As(err, &ue) {
+			// Новая ссылка уже сокращена, возвращаем ее короткую ссылку
+			res.WriteHeader(http.StatusConflict)
+			resp := models.ResponseShortURL{Result: fmt.Sprintf("%s/%s", baseURL, ue.ShortHash)}
+			if err := json.NewEncoder(res).Encode(resp); err != nil {
+				log.Printf("Error writing response: %s", err)
+			}
+			return
+		}
+		if errors.Is(err, storage.ErrDeleted) {
+			// Ссылку удалили после проверки
+			res.WriteHeader(http.StatusGone)
+			return
+		}
+		if err != nil {
+			log.Printf("Update error: %s", err)
+			writeStorageError(res, err)
+			return
+		}
+
+		err = callStorage(ctx, func(ctx context.Context) (err error) {
+			link, err = mainStorage.GetLink(ctx, shortURL)
+			return err
+		})
+		if err != nil {
+			writeStorageError(res, err)
+			return
+		}
+		// Cериализуем ответ сервера
+		if err := json.NewEncoder(res).Encode(toResponseURL(baseURL, link)); err != nil {
+			log.Printf("Error writing response: %s", err)
+		}
+	}
+}
+
 func chunkStrings(arr []string, batchSize int, userUID string) [][]string {
 	var batches [][]string
 
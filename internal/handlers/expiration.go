@@ -0,0 +1,45 @@
+// Модуль содержит разбор срока действия создаваемых ссылок.
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// errExpirationInPast - срок действия ссылки уже истек на момент создания.
+var errExpirationInPast = errors.New("expiration time is in the past")
+
+// parseExpiration - вычисляет момент истечения ссылки по абсолютному времени или TTL в секундах.
+// Нулевое значение означает бессрочную ссылку.
+func parseExpiration(expiresAt time.Time, ttl int64) (time.Time, error) {
+	now := time.Now()
+	if ttl < 0 {
+		return time.Time{}, errors.New("ttl must be positive")
+	}
+	if ttl > 0 {
+		expiresAt = now.Add(time.Duration(ttl) * time.Second)
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return time.Time{}, errExpirationInPast
+	}
+	return expiresAt, nil
+}
+
+// parseExpirationQuery - разбор срока действия из параметров запроса expires_at и ttl.
+func parseExpirationQuery(expiresAtParam string, ttlParam string) (time.Time, error) {
+	var expiresAt time.Time
+	var ttl int64
+	var err error
+	if expiresAtParam != "" {
+		if expiresAt, err = time.Parse(time.RFC3339, expiresAtParam); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if ttlParam != "" {
+		if ttl, err = strconv.ParseInt(ttlParam, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return parseExpiration(expiresAt, ttl)
+}
@@ -166,7 +166,7 @@ func Auth(h http.HandlerFunc) http.HandlerFunc {
 		cookie, err := r.Cookie("userUID")
 
 		if err != nil {
-			if r.Method == http.MethodGet && r.URL.Path == "/api/user/urls" {
+			if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/user/urls") {
 				encodedUserUID := r.Header.Get("Authorization")
 				var validErr bool
 				userUID, validErr := ValidateUserUID(encodedUserUID)
@@ -14,7 +14,9 @@ import (
 )
 
 // ObjectShorterURL - обработка одной ссылоки за один запрос.
-func ObjectShorterURL(mainStorage storage.Storage, baseURL string) http.HandlerFunc {
+// При sortQuery параметры запроса ссылки сортируются.
+func ObjectShorterURL(mainStorage storage.Storage, baseURL string, policy *storage.DomainPolicy, sortQuery bool) http.HandlerFunc {
+	policy = defaultPolicy(policy)
 	return func(res http.ResponseWriter, req *http.Request) {
 
 		// Аутентификация
@@ -43,10 +45,62 @@ func ObjectShorterURL(mainStorage storage.Storage, baseURL string) http.HandlerF
 			return
 		}
 
+		requestFullURL.URL, err = storage.NormalizeURL(requestFullURL.URL, sortQuery)
+		if err != nil {
+			writeInvalidURL(res, err)
+			return
+		}
+		if !checkPolicy(res, policy, requestFullURL.URL, "") {
+			return
+		}
+		if requestFullURL.Alias != "" && !storage.IsValidAlias(requestFullURL.Alias) {
+			http.Error(res, "Invalid alias", http.StatusBadRequest)
+			return
+		}
+		expiresAt, err := parseExpiration(requestFullURL.ExpiresAt, requestFullURL.TTL)
+		if err != nil {
+			http.Error(res, fmt.Sprintf("Bad expiration: %s", err), http.StatusBadRequest)
+			return
+		}
+		tags, valid := normalizeTags(res, requestFullURL.Tags, "")
+		if !valid {
+			return
+		}
+		passwordHash, valid := hashPassword(res, requestFullURL.Password, "")
+		if !valid {
+			return
+		}
+		if !validRedirectType(res, requestFullURL.RedirectType, "") {
+			return
+		}
+		queryRules, valid := linkQueryRules(res, requestFullURL.QueryRules, "")
+		if !valid {
+			return
+		}
+		if !validNotes(res, requestFullURL.Notes, "") {
+			return
+		}
+
 		res.Header().Set("Content-Type", "application/json")
 
-		shortURL, err := mainStorage.Save(requestFullURL.URL, userUID)
+		ctx, cancel := storageContext(req)
+		defer cancel()
+		// Признаки сохраняются одной записью со ссылкой, чтобы она не открывалась без пароля или срока действия
+		shortURL, err := mainStorage.SaveLink(ctx, requestFullURL.URL, requestFullURL.Alias, userUID, storage.LinkOptions{
+			ExpiresAt:    expiresAt,
+			Tags:         tags,
+			Preview:      requestFullURL.Preview,
+			PasswordHash: passwordHash,
+			RedirectType: requestFullURL.RedirectType,
+			QueryRules:   queryRules,
+			Notes:        requestFullURL.Notes,
+		})
 		if err != nil {
+			var ae *storage.AliasTakenError
+			if errors.As(err, &ae) {
+				http.Error(res, ae.Error(), http.StatusConflict)
+				return
+			}
 			var ue *storage.UniqURLError
 			if errors.As(err, &ue) {
 				originShortURL := strings.TrimSuffix(fmt.Sprintf("%s/%s", baseURL, ue.ShortHash), "\n")
@@ -63,6 +117,11 @@ func ObjectShorterURL(mainStorage storage.Storage, baseURL string) http.HandlerF
 				res.Write(jsonResp)
 				return
 			}
+			if quotaExceeded(res, userUID, err) {
+				return
+			}
+			writeStorageError(res, err)
+			return
 		}
 
 		resp := models.ResponseShortURL{
@@ -83,7 +142,10 @@ func ObjectShorterURL(mainStorage storage.Storage, baseURL string) http.HandlerF
 }
 
 // ObjectsShorterURL - обработка несколько ссылок за один запрос.
-func ObjectsShorterURL(mainStorage storage.CorrelationStorage, baseURL string) http.HandlerFunc {
+// Пакет больше квоты quotas отклоняется целиком, nil quotas - без ограничения размера пакета.
+// При sortQuery параметры запроса ссылок сортируются.
+func ObjectsShorterURL(mainStorage storage.CorrelationStorage, baseURL string, policy *storage.DomainPolicy, sortQuery bool, quotas *Quotas) http.HandlerFunc {
+	policy = defaultPolicy(policy)
 	return func(res http.ResponseWriter, req *http.Request) {
 
 		// Аутентификация
@@ -107,21 +169,80 @@ func ObjectsShorterURL(mainStorage storage.CorrelationStorage, baseURL string) h
 		var correlationURLs []storage.CorrelationURL
 
 		for _, value := range requestCorrelationURLs {
+			originalURL, err := storage.NormalizeURL(value.OriginalURL, sortQuery)
+			if err != nil {
+				var ie *storage.InvalidURLError
+				if errors.As(err, &ie) {
+					http.Error(res, fmt.Sprintf("Invalid URL for %s: %s", value.CorrelationID, ie.Reason), http.StatusBadRequest)
+					return
+				}
+				writeInvalidURL(res, err)
+				return
+			}
+			if !checkPolicy(res, policy, originalURL, value.CorrelationID) {
+				return
+			}
+			expiresAt, err := parseExpiration(value.ExpiresAt, value.TTL)
+			if err != nil {
+				http.Error(res, fmt.Sprintf("Bad expiration for %s: %s", value.CorrelationID, err), http.StatusBadRequest)
+				return
+			}
+			linkTags, valid := normalizeTags(res, value.Tags, value.CorrelationID)
+			if !valid {
+				return
+			}
+			passwordHash, valid := hashPassword(res, value.Password, value.CorrelationID)
+			if !valid {
+				return
+			}
+			if !validRedirectType(res, value.RedirectType, value.CorrelationID) {
+				return
+			}
+			linkRules, valid := linkQueryRules(res, value.QueryRules, value.CorrelationID)
+			if !valid {
+				return
+			}
+			if !validNotes(res, value.Notes, value.CorrelationID) {
+				return
+			}
 			correlationURLs = append(correlationURLs, storage.CorrelationURL{
 				CorrelationID: value.CorrelationID,
-				OriginalURL:   value.OriginalURL,
+				OriginalURL:   originalURL,
+				Options: storage.LinkOptions{
+					ExpiresAt:    expiresAt,
+					Tags:         linkTags,
+					Preview:      value.Preview,
+					PasswordHash: passwordHash,
+					RedirectType: value.RedirectType,
+					QueryRules:   linkRules,
+					Notes:        value.Notes,
+				},
 			})
 		}
 
-		shortURLs, err := mainStorage.CorrelationsSave(correlationURLs, userUID)
-
+		if !checkBatch(res, quotas, userUID, len(correlationURLs)) {
+			return
+		}
+		ctx, cancel := storageContext(req)
+		defer cancel()
+		shortURLs, err := mainStorage.CorrelationsSave(ctx, correlationURLs, userUID)
 		if err != nil {
+			var ae *storage.AliasTakenError
+			if errors.As(err, &ae) {
+				http.Error(res, ae.Error(), http.StatusConflict)
+				return
+			}
 			var ue *storage.UniqURLError
 			if errors.As(err, &ue) {
 				originShortURL := strings.TrimSuffix(fmt.Sprintf("%s/%s", baseURL, ue.ShortHash), "\n")
 				http.Error(res, originShortURL, http.StatusConflict)
 				return
 			}
+			if quotaExceeded(res, userUID, err) {
+				return
+			}
+			writeStorageError(res, err)
+			return
 		}
 
 		// Кодирование ответа
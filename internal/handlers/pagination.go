@@ -0,0 +1,108 @@
+// Модуль содержит разбор параметров выборки ссылок пользователя.
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/PerfectStepCoder/shorturl/internal/models"
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+)
+
+// Размер страницы ссылок пользователя.
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// pageParams - параметры выборки, любой из них переключает ответ на страницы.
+var pageParams = []string{"limit", "cursor", "sort", "order", "deleted", "expired", "domain", "tag"}
+
+// isPageRequest - задан ли в запросе хотя бы один параметр выборки, остальные параметры не меняют вид ответа.
+func isPageRequest(values url.Values) bool {
+	for _, name := range pageParams {
+		if values.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseURLQuery - параметры выборки из запроса: limit, cursor, sort, order, deleted, expired, domain и tag.
+// Параметр tag можно повторять, тогда ссылка должна иметь все теги.
+func parseURLQuery(values url.Values) (storage.URLQuery, error) {
+	query := storage.URLQuery{
+		Limit:  defaultPageLimit,
+		Cursor: values.Get("cursor"),
+		SortBy: storage.SortByCreatedAt,
+		Domain: values.Get("domain"),
+	}
+	if limit := values.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > maxPageLimit {
+			return query, fmt.Errorf("limit must be from 1 to %d", maxPageLimit)
+		}
+		query.Limit = value
+	}
+	switch sortBy := values.Get("sort"); sortBy {
+	case "", storage.SortByCreatedAt:
+	case storage.SortByOriginal:
+		query.SortBy = sortBy
+	default:
+		return query, fmt.Errorf("unknown sort %q, expected %s or %s", sortBy, storage.SortByCreatedAt, storage.SortByOriginal)
+	}
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("unknown order %q, expected asc or desc", order)
+	}
+	var err error
+	if query.Deleted, err = parseBoolFilter(values, "deleted"); err != nil {
+		return query, err
+	}
+	if query.Expired, err = parseBoolFilter(values, "expired"); err != nil {
+		return query, err
+	}
+	if query.Tags, err = storage.NormalizeTags(values["tag"]); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+// parseBoolFilter - необязательный логический фильтр, nil если параметр не задан.
+func parseBoolFilter(values url.Values, name string) (*bool, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+	return &value, nil
+}
+
+// toResponseURL - ссылка пользователя в ответе сервиса.
+func toResponseURL(baseURL string, item storage.ShortHashURL) models.ResponseURL {
+	output := models.ResponseURL{
+		OriginalURL: item.OriginalURL, ShortURL: fmt.Sprintf("%s/%s", baseURL, item.ShortHash),
+		Expired: item.Expired, Deleted: item.Deleted, Tags: item.Tags, Preview: item.Preview,
+		RedirectType: item.RedirectType, Notes: item.Notes,
+	}
+	if item.QueryRules != nil {
+		rules := models.QueryRules(*item.QueryRules)
+		output.QueryRules = &rules
+	}
+	if !item.CreatedAt.IsZero() {
+		createdAt := item.CreatedAt
+		output.CreatedAt = &createdAt
+	}
+	if !item.ExpiresAt.IsZero() {
+		expiresAt := item.ExpiresAt
+		output.ExpiresAt = &expiresAt
+	}
+	return output
+}
@@ -0,0 +1,121 @@
+// Модуль содержит ссылки, открывающиеся только после ввода пароля.
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordTemplate - имя шаблона формы ввода пароля.
+const passwordTemplate = "password.html"
+
+// maxPasswordLength - предельная длина пароля в байтах, bcrypt учитывает только первые 72 байта.
+const maxPasswordLength = 72
+
+// maxPasswordFormSize - предельный размер тела запроса с формой пароля.
+const maxPasswordFormSize = 4096
+
+// passwordData - данные формы ввода пароля для шаблона.
+type passwordData struct {
+	ShortHash string
+	Action    string // адрес отправки формы, сохраняет параметры запроса перехода
+	Error     string // пусто при первом показе формы
+}
+
+// newPasswordData - данные формы ввода пароля для ссылки shortURL.
+func newPasswordData(req *http.Request, shortURL string, message string) passwordData {
+	action := shortURL
+	if req.URL.RawQuery != "" {
+		action += "?" + req.URL.RawQuery
+	}
+	return passwordData{ShortHash: shortURL, Action: action, Error: message}
+}
+
+// hashPassword - медленный хеш bcrypt пароля ссылки, для пустого пароля пустой хеш.
+// Для слишком длинного пароля отвечает 400 и возвращает false.
+func hashPassword(res http.ResponseWriter, password string, correlationID string) (string, bool) {
+	if password == "" {
+		return "", true
+	}
+	if len(password) > maxPasswordLength {
+		message := fmt.Sprintf("Password longer than %d bytes", maxPasswordLength)
+		if correlationID != "" {
+			message = fmt.Sprintf("%s for %s", message, correlationID)
+		}
+		http.Error(res, message, http.StatusBadRequest)
+		return "", false
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Password hash error: %s", err)
+		http.Error(res, "Error", http.StatusInternalServerError)
+		return "", false
+	}
+	return string(passwordHash), true
+}
+
+// requirePassword - для ссылки с паролем отвечает формой ввода пароля вместо перехода.
+// Возвращает true, если ответ уже отправлен.
+func requirePassword(res http.ResponseWriter, req *http.Request, link storage.ShortHashURL, pages *PreviewPages) bool {
+	if link.PasswordHash == "" {
+		return false
+	}
+	writePage(res, pages, passwordTemplate, http.StatusOK, newPasswordData(req, link.ShortHash, ""))
+	return true
+}
+
+// CheckPassword - проверка пароля из формы по адресу /{id} и перенаправление на оригинальную ссылку,
+// для ссылки с включенным предпросмотром после верного пароля отдается страница предпросмотра, как в GetURL.
+// Попытки ограничиваются limiter по ссылке и адресу клиента, верный пароль сбрасывает счетчик попыток.
+// Без limiter попытки не ограничиваются, без redirects - настройки переходов по умолчанию.
+func CheckPassword(mainStorage storage.Storage, clicks storage.AnalyticsStorage, limiter *RateLimiter, redirects *Redirects) http.HandlerFunc {
+	settings := defaultRedirects(redirects)
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		shortURL := chi.URLParam(req, "id")
+		if shortURL == "" {
+			http.Error(res, "ShortURL not send", http.StatusBadRequest)
+			return
+		}
+		ctx := req.Context()
+		link, ok := lookupActiveLink(ctx, res, mainStorage, shortURL)
+		if !ok {
+			return
+		}
+		// Пароль мог быть снят после показа формы
+		if link.PasswordHash != "" {
+			key := shortURL + " " + clientIP(req)
+			if limiter != nil {
+				if decision := limiter.Allow(key); !decision.Allowed {
+					res.Header().Set("Retry-After", ceilSeconds(decision.RetryAfter))
+					writePage(res, settings.Pages, passwordTemplate, http.StatusTooManyRequests,
+						newPasswordData(req, shortURL, "Слишком много неверных попыток, повторите позже"))
+					return
+				}
+			}
+			req.Body = http.MaxBytesReader(res, req.Body, maxPasswordFormSize)
+			password := req.PostFormValue("password")
+			if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+				writePage(res, settings.Pages, passwordTemplate, http.StatusForbidden,
+					newPasswordData(req, shortURL, "Неверный пароль"))
+				return
+			}
+			if limiter != nil {
+				limiter.Reset(key)
+			}
+		}
+		recordClick(ctx, clicks, shortURL, req, settings.ClickHashKey)
+		if link.Preview {
+			writePreview(res, req, link, settings)
+			return
+		}
+		location := redirectLocation(req, link, settings.QueryRules)
+		res.Header().Set("Location", location)
+		res.WriteHeader(http.StatusSeeOther)
+	}
+}
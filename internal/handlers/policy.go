@@ -0,0 +1,48 @@
+// Модуль содержит проверку сокращаемых ссылок политикой адресов.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/PerfectStepCoder/shorturl/internal/models"
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+)
+
+// defaultPolicy - политика без политики от вызывающего: запрещает адреса локальных и внутренних сетей.
+func defaultPolicy(policy *storage.DomainPolicy) *storage.DomainPolicy {
+	if policy != nil {
+		return policy
+	}
+	policy, _ = storage.NewDomainPolicy("", true)
+	return policy
+}
+
+// checkPolicy - проверка ссылки политикой адресов.
+// Запрещенная ссылка получает ответ 422 с сработавшим правилом.
+func checkPolicy(res http.ResponseWriter, policy *storage.DomainPolicy, originalURL string, correlationID string) bool {
+	err := policy.Check(originalURL)
+	if err == nil {
+		return true
+	}
+	var pe *storage.PolicyError
+	if !errors.As(err, &pe) {
+		writeInvalidURL(res, err)
+		return false
+	}
+	log.Printf("Rejected by policy: %s", pe)
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusUnprocessableEntity)
+	resp := models.ResponsePolicyViolation{
+		Error:         "policy_violation",
+		Host:          pe.Host,
+		Rule:          pe.Rule,
+		CorrelationID: correlationID,
+	}
+	if err := json.NewEncoder(res).Encode(resp); err != nil {
+		log.Println("Error writing response:", err)
+	}
+	return false
+}
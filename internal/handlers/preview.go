@@ -0,0 +1,117 @@
+// Модуль содержит страницу предпросмотра ссылки перед переходом.
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// previewTemplate - имя шаблона страницы предпросмотра.
+const previewTemplate = "preview.html"
+
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+// defaultPreviewPages - встроенные шаблоны, когда шаблоны не переданы.
+var defaultPreviewPages = template.Must(template.ParseFS(embeddedTemplates, "templates/*.html"))
+
+// PreviewPages - шаблоны страницы предпросмотра и формы ввода пароля.
+type PreviewPages struct {
+	templates *template.Template
+}
+
+// previewData - данные страницы предпросмотра для шаблона.
+type previewData struct {
+	ShortHash   string
+	OriginalURL string    // адрес перехода с параметрами запроса по правилам
+	Domain      string    // хост оригинальной ссылки
+	CreatedAt   time.Time // нулевое значение - ссылка сохранена до появления поля
+}
+
+// NewPreviewPages - встроенные шаблоны, файлы *.html из каталога dir заменяют шаблоны с теми же именами.
+// Пустой dir - только встроенные шаблоны.
+func NewPreviewPages(dir string) (*PreviewPages, error) {
+	// Встроенные шаблоны разбираются заново, выполненный шаблон нельзя клонировать
+	templates, err := template.ParseFS(embeddedTemplates, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no *.html templates in %s", dir)
+		}
+		if templates, err = templates.ParseFiles(files...); err != nil {
+			return nil, err
+		}
+	}
+	return &PreviewPages{templates: templates}, nil
+}
+
+// GetPreview - страница предпросмотра по адресу /{id}+ для любой ссылки, переход не учитывается в статистике.
+// Для ссылки с паролем вместо предпросмотра отдается форма ввода пароля. Без redirects - настройки по умолчанию.
+func GetPreview(mainStorage storage.Storage, redirects *Redirects) http.HandlerFunc {
+	settings := defaultRedirects(redirects)
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		shortURL := chi.URLParam(req, "id")
+		if shortURL == "" {
+			http.Error(res, "ShortURL not send", http.StatusBadRequest)
+			return
+		}
+		link, ok := lookupActiveLink(req.Context(), res, mainStorage, shortURL)
+		if !ok {
+			return
+		}
+		if requirePassword(res, req, link, settings.Pages) {
+			return
+		}
+		writePreview(res, req, link, settings)
+	}
+}
+
+// writePreview - ответ страницей предпросмотра ссылки вместо перехода.
+func writePreview(res http.ResponseWriter, req *http.Request, link storage.ShortHashURL, redirects Redirects) {
+	// Страница ведет туда же, куда вело бы перенаправление
+	location := redirectLocation(req, link, redirects.QueryRules)
+	data := previewData{ShortHash: link.ShortHash, OriginalURL: location, CreatedAt: link.CreatedAt}
+	if parsed, err := url.Parse(location); err == nil {
+		data.Domain = parsed.Hostname()
+	}
+	writePage(res, redirects.Pages, previewTemplate, http.StatusOK, data)
+}
+
+// writePage - ответ HTML страницей по шаблону name из pages, nil - из встроенных шаблонов.
+func writePage(res http.ResponseWriter, pages *PreviewPages, name string, status int, data any) {
+	templates := defaultPreviewPages
+	if pages != nil {
+		templates = pages.templates
+	}
+	// Страница собирается целиком, чтобы ошибка шаблона не оборвала ответ
+	var page bytes.Buffer
+	if err := templates.ExecuteTemplate(&page, name, data); err != nil {
+		log.Printf("Page template %s error: %s", name, err)
+		http.Error(res, "Error", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Content-Length", strconv.Itoa(page.Len()))
+	// Признаки ссылки могут измениться, страница не кешируется
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(status)
+	res.Write(page.Bytes())
+}
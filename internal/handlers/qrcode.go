@@ -0,0 +1,154 @@
+// Модуль содержит выдачу QR кода короткой ссылки.
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PerfectStepCoder/shorturl/internal/qrcode"
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// Форматы изображения QR кода.
+const (
+	qrFormatPNG = "png"
+	qrFormatSVG = "svg"
+)
+
+// Параметры изображения QR кода по умолчанию и их границы.
+const (
+	defaultQRSize   = 256
+	minQRSize       = 64
+	maxQRSize       = 2048
+	defaultQRMargin = 4
+	maxQRMargin     = 16
+)
+
+// qrOptions - параметры изображения QR кода из запроса.
+type qrOptions struct {
+	format string
+	size   int
+	margin int
+	level  qrcode.Level
+}
+
+// GetQRCode - QR код короткой ссылки в формате PNG или SVG.
+// Формат задается параметром format или заголовком Accept, параметры size, margin и level
+// задают сторону изображения в пикселях, поле в модулях и уровень коррекции ошибок L, M, Q или H.
+func GetQRCode(mainStorage storage.Storage, baseURL string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		shortURL := chi.URLParam(req, "id")
+		if shortURL == "" {
+			http.Error(res, "ShortURL not send", http.StatusBadRequest)
+			return
+		}
+		options, err := parseQROptions(req)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, ok := lookupActiveLink(req.Context(), res, mainStorage, shortURL); !ok {
+			return
+		}
+
+		code, err := qrcode.Encode([]byte(fmt.Sprintf("%s/%s", baseURL, shortURL)), options.level)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var output bytes.Buffer
+		contentType := "image/png"
+		if options.format == qrFormatSVG {
+			contentType = "image/svg+xml"
+			err = code.SVG(&output, options.size, options.margin)
+		} else {
+			err = code.PNG(&output, options.size, options.margin)
+		}
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Header().Set("Content-Type", contentType)
+		res.Header().Set("Content-Length", strconv.Itoa(output.Len()))
+		res.Header().Set("Vary", "Accept")
+		res.WriteHeader(http.StatusOK)
+		res.Write(output.Bytes())
+	}
+}
+
+// parseQROptions - разбор и проверка параметров изображения QR кода.
+func parseQROptions(req *http.Request) (qrOptions, error) {
+	params := req.URL.Query()
+	options := qrOptions{
+		format: params.Get("format"),
+		size:   defaultQRSize,
+		margin: defaultQRMargin,
+		level:  qrcode.LevelM,
+	}
+
+	switch strings.ToLower(options.format) {
+	case "":
+		options.format = negotiateQRFormat(req.Header.Get("Accept"))
+	case qrFormatPNG, qrFormatSVG:
+		options.format = strings.ToLower(options.format)
+	default:
+		return options, fmt.Errorf("unknown format %q, expected png or svg", options.format)
+	}
+
+	if value := params.Get("size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < minQRSize || size > maxQRSize {
+			return options, fmt.Errorf("size must be from %d to %d", minQRSize, maxQRSize)
+		}
+		options.size = size
+	}
+	if value := params.Get("margin"); value != "" {
+		margin, err := strconv.Atoi(value)
+		if err != nil || margin < 0 || margin > maxQRMargin {
+			return options, fmt.Errorf("margin must be from 0 to %d", maxQRMargin)
+		}
+		options.margin = margin
+	}
+	if value := params.Get("level"); value != "" {
+		level, err := qrcode.ParseLevel(value)
+		if err != nil {
+			return options, err
+		}
+		options.level = level
+	}
+	return options, nil
+}
+
+// negotiateQRFormat - формат по заголовку Accept, SVG выбирается только при большем приоритете, чем у PNG.
+func negotiateQRFormat(accept string) string {
+	svgQuality, pngQuality := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if value, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "image/svg+xml":
+			svgQuality = max(svgQuality, quality)
+		case "image/png":
+			pngQuality = max(pngQuality, quality)
+		}
+	}
+	if svgQuality > pngQuality {
+		return qrFormatSVG
+	}
+	return qrFormatPNG
+}
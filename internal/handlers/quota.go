@@ -0,0 +1,158 @@
+// Модуль содержит квоты пользователей на создание ссылок.
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PerfectStepCoder/shorturl/internal/models"
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+)
+
+// Виды ограничений квоты.
+const (
+	QuotaTotal = "total" // всего ссылок пользователя
+	QuotaDaily = "daily" // ссылок за текущие сутки UTC
+	QuotaBatch = "batch" // ссылок в одном пакетном запросе
+)
+
+// QuotaLimits - ограничения на создание ссылок одним пользователем, 0 - без ограничения.
+type QuotaLimits struct {
+	Total int
+	Daily int
+	Batch int
+}
+
+// QuotaExceededError - превышение квоты пользователя.
+type QuotaExceededError struct {
+	Limit     string    // вид ограничения: total, daily, batch
+	Max       int       // значение ограничения
+	Used      int       // использовано до запроса
+	Requested int       // запрошено ссылок
+	ResetAt   time.Time // сброс суточной квоты
+}
+
+// Error - реализация метода.
+func (qe *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded: used %d of %d, requested %d", qe.Limit, qe.Used, qe.Max, qe.Requested)
+}
+
+// Quotas - квоты пользователей на создание ссылок.
+// Общая и суточная квоты проверяются хранилищем при вставке ссылок, поэтому параллельные запросы их не превышают,
+// а суточные счетчики считаются по времени создания ссылок и переживают перезапуск.
+// Ограничения хранилищу устанавливаются при его создании через SetLinkLimits.
+// Удаленные ссылки учитываются, так как могут быть восстановлены.
+type Quotas struct {
+	limits      QuotaLimits
+	mainStorage storage.Storage
+}
+
+// NewQuotas - конструктор.
+func NewQuotas(limits QuotaLimits, mainStorage storage.Storage) *Quotas {
+	return &Quotas{
+		limits:      limits,
+		mainStorage: mainStorage,
+	}
+}
+
+// Limits - ограничения квоты.
+func (q *Quotas) Limits() QuotaLimits {
+	return q.limits
+}
+
+// resetAt - сброс суточной квоты, начало следующих суток UTC.
+func resetAt(now time.Time) time.Time {
+	return storage.DayStart(now).Add(24 * time.Hour)
+}
+
+// Usage - использование квот пользователем.
+func (q *Quotas) Usage(ctx context.Context, userUID string) (models.ResponseQuota, error) {
+	count, err := q.mainStorage.CountByUser(ctx, userUID)
+	if err != nil {
+		return models.ResponseQuota{}, err
+	}
+	reset := resetAt(time.Now())
+	return models.ResponseQuota{
+		Total:      models.QuotaUsage{Limit: q.limits.Total, Used: count.Total},
+		Daily:      models.QuotaUsage{Limit: q.limits.Daily, Used: count.Daily, ResetAt: &reset},
+		BatchLimit: q.limits.Batch,
+	}, nil
+}
+
+// checkBatch - проверка размера пакетного запроса, nil quotas - без ограничения.
+// При превышении пишет ответ и возвращает false.
+func checkBatch(res http.ResponseWriter, quotas *Quotas, userUID string, count int) bool {
+	if quotas == nil || quotas.limits.Batch == 0 || count <= quotas.limits.Batch {
+		return true
+	}
+	writeQuotaExceeded(res, userUID, &QuotaExceededError{Limit: QuotaBatch, Max: quotas.limits.Batch, Requested: count})
+	return false
+}
+
+// quotaExceeded - ответ о превышении квоты для ошибки хранилища, false - ошибка не связана с квотой.
+func quotaExceeded(res http.ResponseWriter, userUID string, err error) bool {
+	var le *storage.LinkLimitError
+	if !errors.As(err, &le) {
+		return false
+	}
+	qe := &QuotaExceededError{Limit: QuotaTotal, Max: le.Max, Used: le.Used, Requested: le.Requested}
+	if le.Daily {
+		qe.Limit = QuotaDaily
+		qe.ResetAt = resetAt(time.Now())
+	}
+	writeQuotaExceeded(res, userUID, qe)
+	return true
+}
+
+// writeQuotaExceeded - ответ о превышении квоты.
+func writeQuotaExceeded(res http.ResponseWriter, userUID string, qe *QuotaExceededError) {
+	log.Printf("User %s: %s", userUID, qe)
+	resp := models.ResponseQuotaExceeded{
+		Error:     "quota_exceeded",
+		Limit:     qe.Limit,
+		Max:       qe.Max,
+		Used:      qe.Used,
+		Requested: qe.Requested,
+	}
+	status := http.StatusForbidden
+	if qe.Limit == QuotaDaily {
+		// Суточная квота восстановится, остальные - только после изменения ограничений
+		status = http.StatusTooManyRequests
+		resp.ResetAt = &qe.ResetAt
+		res.Header().Set("Retry-After", strconv.Itoa(int(time.Until(qe.ResetAt).Seconds())+1))
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(resp); err != nil {
+		log.Println("Error writing response:", err)
+	}
+}
+
+// GetQuota - использование квот текущим пользователем.
+func GetQuota(quotas *Quotas) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+
+		// Аутентификация
+		userUID := fmt.Sprintf("%s", req.Context().Value(UserKeyUID))
+
+		ctx, cancel := storageContext(req)
+		defer cancel()
+		usage, err := quotas.Usage(ctx, userUID)
+		if err != nil {
+			writeStorageError(res, err)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(res).Encode(usage); err != nil {
+			log.Println("Error writing response:", err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Модуль содержит ограничение частоты запросов.
+package handlers
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit - ограничение частоты: Burst запросов подряд, далее Rate запросов в секунду.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// tokenBucket - корзина токенов одного клиента.
+type tokenBucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter - ограничение частоты запросов алгоритмом token bucket по адресу клиента.
+// Хранит не больше maxKeys корзин, при заполнении таблицы вытесняется самая давно использованная корзина,
+// новые клиенты не отклоняются из-за размера таблицы.
+type RateLimiter struct {
+	limit   RateLimit
+	maxKeys int
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	order   *list.List // от недавно использованных к давно использованным
+}
+
+// NewRateLimiter - конструктор, при Burst 0 допускается всплеск в одну секунду запросов.
+func NewRateLimiter(limit RateLimit, maxKeys int) *RateLimiter {
+	if limit.Burst <= 0 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	return &RateLimiter{
+		limit:   limit,
+		maxKeys: maxKeys,
+		buckets: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// RateDecision - результат проверки запроса.
+type RateDecision struct {
+	Allowed    bool
+	Limit      int           // размер корзины
+	Remaining  int           // остаток токенов после запроса
+	Reset      time.Duration // время до полного восстановления корзины
+	RetryAfter time.Duration // время до появления токена, если запрос отклонен
+}
+
+// Allow - списание токена из корзины клиента key.
+func (l *RateLimiter) Allow(key string) RateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	burst := float64(l.limit.Burst)
+	decision := RateDecision{Limit: l.limit.Burst}
+	element, exists := l.buckets[key]
+	if !exists {
+		if l.maxKeys > 0 && l.order.Len() >= l.maxKeys {
+			l.evict()
+		}
+		element = l.order.PushFront(&tokenBucket{key: key, tokens: burst, updated: now})
+		l.buckets[key] = element
+	} else {
+		l.order.MoveToFront(element)
+	}
+	bucket := element.Value.(*tokenBucket)
+
+	// Пополнение за прошедшее время
+	bucket.tokens = l.refilled(bucket, now)
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = l.refillTime(1 - bucket.tokens)
+	}
+	decision.Remaining = int(bucket.tokens)
+	decision.Reset = l.refillTime(burst - bucket.tokens)
+	return decision
+}
+
+// refilled - количество токенов в корзине на момент now.
+func (l *RateLimiter) refilled(bucket *tokenBucket, now time.Time) float64 {
+	return math.Min(float64(l.limit.Burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*l.limit.Rate)
+}
+
+// evict - вытеснение самой давно использованной корзины.
+func (l *RateLimiter) evict() {
+	oldest := l.order.Back()
+	l.order.Remove(oldest)
+	delete(l.buckets, oldest.Value.(*tokenBucket).key)
+}
+
+// Reset - удаление корзины клиента key, следующий запрос начинается с полной корзины.
+func (l *RateLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if element, exists := l.buckets[key]; exists {
+		l.order.Remove(element)
+		delete(l.buckets, key)
+	}
+}
+
+// refillTime - время пополнения корзины на tokens токенов.
+func (l *RateLimiter) refillTime(tokens float64) time.Duration {
+	if l.limit.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / l.limit.Rate * float64(time.Second))
+}
+
+// rateLimitKey - ключ клиента: адрес соединения или X-Real-IP от доверенного прокси.
+// Кука userUID не подходит, сервер выдает новую любому клиенту.
+func rateLimitKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// ceilSeconds - длительность в целых секундах с округлением вверх.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// WithRateLimit - декоратор ограничения частоты запросов, без limiter запросы не ограничиваются.
+// Отвечает заголовками RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, при превышении - 429 и Retry-After.
+func WithRateLimit(h http.HandlerFunc, limiter *RateLimiter) http.HandlerFunc {
+	if limiter == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		decision := limiter.Allow(rateLimitKey(r))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", ceilSeconds(decision.Reset))
+		if !decision.Allowed {
+			w.Header().Set("Retry-After", ceilSeconds(decision.RetryAfter))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
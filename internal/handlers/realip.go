@@ -0,0 +1,80 @@
+// Модуль содержит определение адреса клиента за обратным прокси.
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies - сети обратных прокси, которым разрешено передавать адрес клиента в X-Real-IP.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies - разбор адресов и сетей через запятую, например 10.0.0.1,192.168.0.0/16.
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		// Отдельный адрес - сеть из одного адреса
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("bad proxy address %q", item)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy network %q", item)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Contains - является ли адрес доверенным прокси.
+func (p TrustedProxies) Contains(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP - адрес клиента без порта, за доверенным прокси его подставляет WithRealIP.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// WithRealIP - декоратор, подставляющий в RemoteAddr адрес клиента из X-Real-IP.
+// Заголовок учитывается только от доверенных прокси, иначе его может подделать любой клиент.
+func WithRealIP(h http.HandlerFunc, proxies TrustedProxies) http.HandlerFunc {
+	if len(proxies) == 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		realIP := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+		if realIP != "" && net.ParseIP(realIP) != nil && proxies.Contains(clientIP(r)) {
+			r.RemoteAddr = net.JoinHostPort(realIP, "0")
+		}
+		h(w, r)
+	}
+}
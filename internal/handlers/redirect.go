@@ -0,0 +1,126 @@
+// Модуль содержит выбор кода перенаправления и заголовков кеширования перехода по ссылке.
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PerfectStepCoder/shorturl/internal/models"
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+)
+
+// RedirectOptions - настройки перенаправления по короткой ссылке.
+type RedirectOptions struct {
+	Default int           // код для ссылок без собственного кода
+	MaxAge  time.Duration // время кеширования постоянного перенаправления клиентом, 0 - без кеширования
+}
+
+// defaultRedirectOptions - настройки по умолчанию.
+var defaultRedirectOptions = RedirectOptions{Default: http.StatusTemporaryRedirect}
+
+// Redirects - настройки обработчиков переходов по коротким ссылкам.
+type Redirects struct {
+	Pages        *PreviewPages      // шаблоны страниц предпросмотра и ввода пароля, nil - встроенные шаблоны
+	Options      RedirectOptions    // нулевой Default - код по умолчанию
+	QueryRules   storage.QueryRules // общие правила параметров запроса
+	ClickHashKey []byte             // секрет хеширования адресов клиентов в статистике, пусто - случайный ключ процесса
+}
+
+// defaultRedirects - настройки переходов с заполненными значениями по умолчанию, nil - все по умолчанию.
+func defaultRedirects(redirects *Redirects) Redirects {
+	if redirects == nil {
+		return Redirects{Options: defaultRedirectOptions, ClickHashKey: defaultClickHashKey}
+	}
+	settings := *redirects
+	if settings.Options.Default == 0 {
+		settings.Options.Default = defaultRedirectOptions.Default
+	}
+	if len(settings.ClickHashKey) == 0 {
+		settings.ClickHashKey = defaultClickHashKey
+	}
+	return settings
+}
+
+// IsValidRedirectType - допустимый код перенаправления: 301, 302, 307 или 308.
+func IsValidRedirectType(redirectType int) bool {
+	switch redirectType {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// isPermanentRedirect - постоянное ли перенаправление.
+func isPermanentRedirect(redirectType int) bool {
+	return redirectType == http.StatusMovedPermanently || redirectType == http.StatusPermanentRedirect
+}
+
+// validRedirectType - проверка кода перенаправления из запроса, 0 - код по умолчанию.
+// Для недопустимого кода отвечает 400 и возвращает false.
+func validRedirectType(res http.ResponseWriter, redirectType int, correlationID string) bool {
+	if redirectType == 0 || IsValidRedirectType(redirectType) {
+		return true
+	}
+	message := fmt.Sprintf("Invalid redirect type %d: must be 301, 302, 307 or 308", redirectType)
+	if correlationID != "" {
+		message = fmt.Sprintf("%s for %s", message, correlationID)
+	}
+	http.Error(res, message, http.StatusBadRequest)
+	return false
+}
+
+// linkQueryRules - проверенные правила параметров запроса ссылки, nil - только общие правила.
+// Для недопустимых правил отвечает 400 и возвращает false.
+func linkQueryRules(res http.ResponseWriter, rules *models.QueryRules, correlationID string) (*storage.QueryRules, bool) {
+	if rules == nil {
+		return nil, true
+	}
+	linkRules := storage.QueryRules(*rules)
+	if err := linkRules.Validate(); err != nil {
+		message := fmt.Sprintf("Invalid query rules: %s", err)
+		if correlationID != "" {
+			message = fmt.Sprintf("%s for %s", message, correlationID)
+		}
+		http.Error(res, message, http.StatusBadRequest)
+		return nil, false
+	}
+	if linkRules.IsZero() {
+		return nil, true
+	}
+	return &linkRules, true
+}
+
+// redirectLocation - адрес перехода: оригинальная ссылка с параметрами по правилам ссылки поверх общих правил.
+func redirectLocation(req *http.Request, link storage.ShortHashURL, global storage.QueryRules) string {
+	return global.Merge(link.QueryRules).Apply(link.OriginalURL, req.URL.RawQuery)
+}
+
+// writeRedirect - перенаправление по ссылке с ее кодом или кодом по умолчанию.
+// Постоянное перенаправление кешируется не дольше MaxAge и срока действия ссылки, временное не кешируется,
+// чтобы каждый переход доходил до сервиса. Параметры запроса перехода и добавляемые параметры
+// объединяются с адресом по правилам параметров запроса.
+func writeRedirect(res http.ResponseWriter, req *http.Request, link storage.ShortHashURL, redirects Redirects) {
+	options := redirects.Options
+	location := redirectLocation(req, link, redirects.QueryRules)
+	redirectType := link.RedirectType
+	if redirectType == 0 {
+		redirectType = options.Default
+	}
+
+	maxAge := time.Duration(0)
+	if isPermanentRedirect(redirectType) && options.MaxAge > 0 {
+		maxAge = options.MaxAge
+		if !link.ExpiresAt.IsZero() {
+			maxAge = min(maxAge, time.Until(link.ExpiresAt))
+		}
+	}
+	if maxAge >= time.Second {
+		res.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	} else {
+		res.Header().Set("Cache-Control", "no-cache")
+	}
+	res.Header().Set("Location", location)
+	res.WriteHeader(redirectType)
+}
@@ -0,0 +1,46 @@
+// Модуль содержит проверку тегов и заметок ссылок из запросов.
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+)
+
+// normalizeTags - проверка тегов из запроса, при ошибке отвечает 400 с причиной.
+// correlationID указывается в ответе для ссылки из пакетного запроса.
+func normalizeTags(res http.ResponseWriter, tags []string, correlationID string) ([]string, bool) {
+	normalized, err := storage.NormalizeTags(tags)
+	if err == nil {
+		return normalized, true
+	}
+	message := err.Error()
+	var te *storage.InvalidTagError
+	if errors.As(err, &te) {
+		message = fmt.Sprintf("Invalid tag %q: %s", te.Tag, te.Reason)
+	}
+	if correlationID != "" {
+		message = fmt.Sprintf("%s for %s", message, correlationID)
+	}
+	http.Error(res, message, http.StatusBadRequest)
+	return nil, false
+}
+
+// maxNotesLength - наибольшая длина заметки ссылки в символах.
+const maxNotesLength = 1000
+
+// validNotes - проверка длины заметки из запроса, для слишком длинной заметки отвечает 400 и возвращает false.
+func validNotes(res http.ResponseWriter, notes string, correlationID string) bool {
+	if utf8.RuneCountInString(notes) <= maxNotesLength {
+		return true
+	}
+	message := fmt.Sprintf("Notes too long: at most %d characters", maxNotesLength)
+	if correlationID != "" {
+		message = fmt.Sprintf("%s for %s", message, correlationID)
+	}
+	http.Error(res, message, http.StatusBadRequest)
+	return false
+}
@@ -0,0 +1,70 @@
+// Модуль содержит ограничение времени операций с хранилищем.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// storageTimeoutKey - предельное время одной операции с хранилищем, передается в контексте запроса.
+const storageTimeoutKey contextKey = "storageTimeout"
+
+// WithStorageTimeout - декоратор, задающий предельное время каждой операции с хранилищем в запросе.
+// Нулевое значение - операции ограничены только временем жизни запроса.
+func WithStorageTimeout(h http.HandlerFunc, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if timeout > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), storageTimeoutKey, timeout))
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// storageContext - контекст единственной операции с хранилищем в обработчике запроса.
+func storageContext(req *http.Request) (context.Context, context.CancelFunc) {
+	return storageCall(req.Context())
+}
+
+// storageCall - контекст одной операции с хранилищем, производный от контекста запроса ctx.
+// Отменяется при отключении клиента или по истечении времени из WithStorageTimeout.
+// Время отсчитывается от начала операции, операции одного запроса не делят общий срок.
+func storageCall(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, ok := ctx.Value(storageTimeoutKey).(time.Duration)
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// callStorage - выполнение операции с хранилищем в собственном контексте из storageCall.
+func callStorage(ctx context.Context, operation func(ctx context.Context) error) error {
+	ctx, cancel := storageCall(ctx)
+	defer cancel()
+	return operation(ctx)
+}
+
+// contextErrorStatus - HTTP статус для прерванной операции: 504 по таймауту, 503 при отмене.
+func contextErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, true
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, true
+	default:
+		return 0, false
+	}
+}
+
+// writeStorageError - ответ на ошибку хранилища, прерванные операции не считаются внутренней ошибкой.
+func writeStorageError(res http.ResponseWriter, err error) {
+	if status, ok := contextErrorStatus(err); ok {
+		log.Printf("Storage operation interrupted: %s", err)
+		http.Error(res, http.StatusText(status), status)
+		return
+	}
+	log.Printf("Storage error: %s", err)
+	http.Error(res, "Error", http.StatusInternalServerError)
+}
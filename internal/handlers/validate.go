@@ -0,0 +1,20 @@
+// Модуль содержит проверку сокращаемых ссылок.
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PerfectStepCoder/shorturl/internal/storage"
+)
+
+// writeInvalidURL - ответ 400 с причиной, по которой ссылка не принята.
+func writeInvalidURL(res http.ResponseWriter, err error) {
+	var ie *storage.InvalidURLError
+	if errors.As(err, &ie) {
+		http.Error(res, fmt.Sprintf("Invalid URL: %s", ie.Reason), http.StatusBadRequest)
+		return
+	}
+	http.Error(res, "Invalid URL", http.StatusBadRequest)
+}
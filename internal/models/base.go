@@ -1,9 +1,39 @@
 // Модуль models содержит описание получаемых и возвращаемых сущностей HTTP сервисом.
 package models
 
+import "time"
+
 // RequestFullURL - передача полной ссылке для обработки.
 type RequestFullURL struct {
-	URL string `json:"url"`
+	URL          string      `json:"url"`
+	Alias        string      `json:"alias,omitempty"`         // необязательный пользовательский псевдоним
+	ExpiresAt    time.Time   `json:"expires_at"`              // момент истечения ссылки (RFC 3339)
+	TTL          int64       `json:"ttl,omitempty"`           // время жизни ссылки в секундах
+	Tags         []string    `json:"tags,omitempty"`          // теги пользователя, / разделяет вложенные папки
+	Preview      bool        `json:"preview,omitempty"`       // переход через страницу предпросмотра
+	Password     string      `json:"password,omitempty"`      // переход только после ввода пароля
+	RedirectType int         `json:"redirect_type,omitempty"` // код перенаправления: 301, 302, 307 или 308
+	QueryRules   *QueryRules `json:"query_rules,omitempty"`   // параметры запроса при переходе поверх общих правил
+	Notes        string      `json:"notes,omitempty"`         // заметка владельца, участвует в поиске
+}
+
+// RequestUpdateURL - запрос на изменение ссылки владельцем, пустые поля не меняются.
+type RequestUpdateURL struct {
+	URL          string      `json:"url,omitempty"`           // новая оригинальная ссылка
+	ExpiresAt    time.Time   `json:"expires_at,omitempty"`    // новый момент истечения ссылки (RFC 3339)
+	TTL          int64       `json:"ttl,omitempty"`           // новое время жизни ссылки в секундах
+	Tags         *[]string   `json:"tags,omitempty"`          // новые теги, пустой список удаляет все теги
+	Preview      *bool       `json:"preview,omitempty"`       // включение или выключение страницы предпросмотра
+	RedirectType *int        `json:"redirect_type,omitempty"` // новый код перенаправления, 0 - код по умолчанию
+	QueryRules   *QueryRules `json:"query_rules,omitempty"`   // новые правила параметров запроса, {} - только общие правила
+	Notes        *string     `json:"notes,omitempty"`         // новая заметка, пустая строка удаляет заметку
+}
+
+// QueryRules - правила параметров запроса при переходе по ссылке, незаданные поля берутся из общих правил.
+type QueryRules struct {
+	Passthrough *bool             `json:"passthrough,omitempty"` // передавать параметры запроса перехода
+	Params      map[string]string `json:"params,omitempty"`      // добавляемые параметры, пустое значение отключает общий параметр
+	Conflict    string            `json:"conflict,omitempty"`    // при совпадении имен остается параметр link - ссылки или request - запроса
 }
 
 // ResponseShortURL - возвращаемая короткая ссылка.
@@ -13,8 +43,16 @@ type ResponseShortURL struct {
 
 // RequestCorrelationURL - запрос на обработку полной ссылке с идентификатором.
 type RequestCorrelationURL struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string      `json:"correlation_id"`
+	OriginalURL   string      `json:"original_url"`
+	ExpiresAt     time.Time   `json:"expires_at"`
+	TTL           int64       `json:"ttl,omitempty"`
+	Tags          []string    `json:"tags,omitempty"`
+	Preview       bool        `json:"preview,omitempty"`
+	Password      string      `json:"password,omitempty"`
+	RedirectType  int         `json:"redirect_type,omitempty"`
+	QueryRules    *QueryRules `json:"query_rules,omitempty"`
+	Notes         string      `json:"notes,omitempty"`
 }
 
 // ResponseCorrelationURL - возвращаемый результат обработки полной ссылке с идентификатором.
@@ -25,6 +63,74 @@ type ResponseCorrelationURL struct {
 
 // ResponseURL - полный ответ, вклучая оригинальную ссылку и короткую.
 type ResponseURL struct {
-	OriginalURL string `json:"original_url"`
-	ShortURL    string `json:"short_url"`
+	OriginalURL  string      `json:"original_url"`
+	ShortURL     string      `json:"short_url"`
+	CreatedAt    *time.Time  `json:"created_at,omitempty"`
+	ExpiresAt    *time.Time  `json:"expires_at,omitempty"`
+	Expired      bool        `json:"expired,omitempty"`
+	Deleted      bool        `json:"deleted,omitempty"`
+	Tags         []string    `json:"tags,omitempty"`
+	Preview      bool        `json:"preview,omitempty"`
+	RedirectType int         `json:"redirect_type,omitempty"`
+	QueryRules   *QueryRules `json:"query_rules,omitempty"`
+	Notes        string      `json:"notes,omitempty"`
+}
+
+// ResponseURLPage - страница ссылок пользователя.
+type ResponseURLPage struct {
+	URLs       []ResponseURL `json:"urls"`
+	NextCursor string        `json:"next_cursor,omitempty"` // пусто на последней странице
+}
+
+// DailyClicks - количество переходов по ссылке за день.
+type DailyClicks struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+// RecentClick - один из последних переходов по ссылке.
+type RecentClick struct {
+	Timestamp time.Time `json:"timestamp"`
+	Referrer  string    `json:"referrer"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// ResponseURLStats - статистика переходов по ссылке.
+type ResponseURLStats struct {
+	Total  int           `json:"total"`
+	Unique int           `json:"unique"`
+	Daily  []DailyClicks `json:"daily"`
+	Recent []RecentClick `json:"recent"` // от новых к старым
+}
+
+// QuotaUsage - ограничение квоты и его использование, 0 - без ограничения.
+type QuotaUsage struct {
+	Limit   int        `json:"limit"`
+	Used    int        `json:"used"`
+	ResetAt *time.Time `json:"reset_at,omitempty"` // сброс суточной квоты
+}
+
+// ResponseQuota - квоты пользователя на создание ссылок.
+type ResponseQuota struct {
+	Total      QuotaUsage `json:"total"`
+	Daily      QuotaUsage `json:"daily"`
+	BatchLimit int        `json:"batch_limit"`
+}
+
+// ResponseQuotaExceeded - ответ при превышении квоты.
+type ResponseQuotaExceeded struct {
+	Error     string     `json:"error"`
+	Limit     string     `json:"limit"` // вид ограничения: total, daily, batch
+	Max       int        `json:"max"`
+	Used      int        `json:"used"`
+	Requested int        `json:"requested"`
+	ResetAt   *time.Time `json:"reset_at,omitempty"`
+}
+
+// ResponsePolicyViolation - ответ, когда ссылка запрещена политикой адресов.
+type ResponsePolicyViolation struct {
+	Error         string `json:"error"`
+	Host          string `json:"host"`
+	Rule          string `json:"rule"`                     // сработавшее правило политики
+	CorrelationID string `json:"correlation_id,omitempty"` // ссылка пакетного запроса
 }
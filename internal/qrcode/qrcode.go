@@ -0,0 +1,506 @@
+// Package qrcode содержит кодирование данных в QR код по ISO/IEC 18004 без внешних зависимостей.
+// Поддерживается байтовый режим, версии с 1 по 40 и все уровни коррекции ошибок.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Level - уровень коррекции ошибок QR кода.
+type Level int
+
+// Уровни коррекции ошибок, в скобках доля восстанавливаемых данных.
+const (
+	LevelL Level = iota // ~7%
+	LevelM              // ~15%
+	LevelQ              // ~25%
+	LevelH              // ~30%
+)
+
+// Границы версий QR кода.
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+// ErrDataTooLong - данные не помещаются в QR код версии 40 с выбранным уровнем коррекции.
+var ErrDataTooLong = errors.New("data too long for qr code")
+
+// eccCodewordsPerBlock - число кодовых слов коррекции в блоке по уровню и версии.
+var eccCodewordsPerBlock = [4][maxVersion + 1]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// eccBlocks - число блоков коррекции ошибок по уровню и версии.
+var eccBlocks = [4][maxVersion + 1]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// formatLevelBits - биты уровня коррекции в информации о формате.
+var formatLevelBits = [4]int{1, 0, 3, 2}
+
+// ParseLevel - уровень коррекции по букве L, M, Q или H.
+func ParseLevel(value string) (Level, error) {
+	switch strings.ToUpper(value) {
+	case "L":
+		return LevelL, nil
+	case "M":
+		return LevelM, nil
+	case "Q":
+		return LevelQ, nil
+	case "H":
+		return LevelH, nil
+	}
+	return LevelM, fmt.Errorf("unknown error correction level %q", value)
+}
+
+// String - буква уровня коррекции.
+func (l Level) String() string {
+	return [4]string{"L", "M", "Q", "H"}[l]
+}
+
+// Code - QR код в виде квадратной матрицы модулей.
+type Code struct {
+	Version int    // версия от 1 до 40
+	Size    int    // сторона матрицы в модулях
+	Level   Level  // уровень коррекции ошибок
+	Mask    int    // номер примененной маски
+	modules []bool // темные модули построчно
+}
+
+// Dark - темный ли модуль в столбце x и строке y.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y*c.Size+x]
+}
+
+// Encode - кодирование данных в байтовом режиме в QR код наименьшей подходящей версии.
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < LevelL || level > LevelH {
+		return nil, fmt.Errorf("unknown error correction level %d", level)
+	}
+	version := minVersion
+	for ; version <= maxVersion; version++ {
+		if 4+charCountBits(version)+len(data)*8 <= dataCodewords(version, level)*8 {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, ErrDataTooLong
+	}
+
+	// Режим 0100, длина, данные, терминатор и дополнение до емкости версии
+	capacity := dataCodewords(version, level) * 8
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, capacity-bits.len()))
+	bits.append(0, (8-bits.len()%8)%8)
+	for pad := 0xec; bits.len() < capacity; pad ^= 0xec ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	code := newCode(version, level)
+	code.drawCodewords(addECC(bits.bytes(), version, level))
+	code.applyBestMask()
+	return &code.Code, nil
+}
+
+// charCountBits - длина поля числа байт для версии.
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// rawDataModules - число модулей для данных и коррекции ошибок без служебных шаблонов.
+func rawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// dataCodewords - число кодовых слов данных для версии и уровня коррекции.
+func dataCodewords(version int, level Level) int {
+	return rawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// addECC - разбиение данных на блоки, добавление кодов коррекции и чередование кодовых слов.
+func addECC(data []byte, version int, level Level) []byte {
+	numBlocks := eccBlocks[level][version]
+	blockECCLen := eccCodewordsPerBlock[level][version]
+	rawCodewords := rawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	// Короткие блоки идут первыми, длинные содержат на одно слово данных больше.
+	// В короткие блоки добавляется пустое слово, чтобы все блоки были одной длины.
+	generator := rsGenerator(blockECCLen)
+	padIndex := shortBlockLen - blockECCLen
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		dataLen := padIndex
+		if i >= numShortBlocks {
+			dataLen++
+		}
+		block := data[k : k+dataLen]
+		k += dataLen
+		output := append([]byte{}, block...)
+		if i < numShortBlocks {
+			output = append(output, 0)
+		}
+		blocks[i] = append(output, rsRemainder(block, generator)...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i <= shortBlockLen; i++ {
+		for j, block := range blocks {
+			if i != padIndex || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// bitBuffer - последовательность бит для потока данных.
+type bitBuffer []bool
+
+// append - добавление length младших бит value начиная со старшего.
+func (b *bitBuffer) append(value int, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 != 0)
+	}
+}
+
+// len - число бит.
+func (b *bitBuffer) len() int {
+	return len(*b)
+}
+
+// bytes - упаковка бит в байты, длина буфера кратна 8.
+func (b *bitBuffer) bytes() []byte {
+	result := make([]byte, len(*b)/8)
+	for i, bit := range *b {
+		if bit {
+			result[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return result
+}
+
+// builder - построение матрицы QR кода.
+type builder struct {
+	Code
+	function []bool // модули служебных шаблонов, не содержащие данных
+}
+
+// newCode - матрица версии с нарисованными служебными шаблонами.
+func newCode(version int, level Level) *builder {
+	size := version*4 + 17
+	b := &builder{
+		Code:     Code{Version: version, Size: size, Level: level, modules: make([]bool, size*size)},
+		function: make([]bool, size*size),
+	}
+
+	// Шаблоны синхронизации
+	for i := 0; i < size; i++ {
+		b.setFunction(6, i, i%2 == 0)
+		b.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Поисковые узоры в трех углах вместе с разделителями
+	b.drawFinder(3, 3)
+	b.drawFinder(size-4, 3)
+	b.drawFinder(3, size-4)
+
+	// Выравнивающие узоры кроме пересекающихся с поисковыми
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			b.drawAlignment(x, y)
+		}
+	}
+
+	// Резервирование места под формат, реальные биты рисуются после выбора маски
+	b.drawFormat(0)
+	b.drawVersion()
+	return b
+}
+
+// setFunction - установка модуля служебного шаблона.
+func (b *builder) setFunction(x, y int, dark bool) {
+	b.modules[y*b.Size+x] = dark
+	b.function[y*b.Size+x] = true
+}
+
+// drawFinder - поисковый узор 7x7 с центром в (x, y) и светлой рамкой вокруг.
+func (b *builder) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= b.Size || yy < 0 || yy >= b.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			b.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment - выравнивающий узор 5x5 с центром в (x, y).
+func (b *builder) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			b.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat - информация об уровне коррекции и маске, две копии с кодом БЧХ.
+func (b *builder) drawFormat(mask int) {
+	bits := formatBits(b.Level, mask)
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	// Копия вокруг левого верхнего поискового узора
+	for i := 0; i <= 5; i++ {
+		b.setFunction(8, i, bit(i))
+	}
+	b.setFunction(8, 7, bit(6))
+	b.setFunction(8, 8, bit(7))
+	b.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		b.setFunction(14-i, 8, bit(i))
+	}
+
+	// Копия у правого верхнего и левого нижнего поисковых узоров
+	for i := 0; i < 8; i++ {
+		b.setFunction(b.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		b.setFunction(8, b.Size-15+i, bit(i))
+	}
+	b.setFunction(8, b.Size-8, true) // всегда темный модуль
+}
+
+// drawVersion - информация о версии для версий от 7, две копии с кодом Голея.
+func (b *builder) drawVersion() {
+	if b.Version < 7 {
+		return
+	}
+	bits := versionBits(b.Version)
+	for i := 0; i < 18; i++ {
+		dark := (bits>>i)&1 != 0
+		x, y := b.Size-11+i%3, i/3
+		b.setFunction(x, y, dark)
+		b.setFunction(y, x, dark)
+	}
+}
+
+// drawCodewords - размещение кодовых слов зигзагом парами столбцов справа налево.
+func (b *builder) drawCodewords(data []byte) {
+	i := 0
+	for right := b.Size - 1; right >= 1; right -= 2 {
+		// Столбец шаблона синхронизации пропускается
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < b.Size; vert++ {
+			y := vert
+			if upward {
+				y = b.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if b.function[y*b.Size+x] || i >= len(data)*8 {
+					continue
+				}
+				b.modules[y*b.Size+x] = (data[i/8]>>(7-i%8))&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask - инверсия модулей данных по маске, повторное применение снимает маску.
+func (b *builder) applyMask(mask int) {
+	for y := 0; y < b.Size; y++ {
+		for x := 0; x < b.Size; x++ {
+			if !b.function[y*b.Size+x] && maskBit(mask, x, y) {
+				b.modules[y*b.Size+x] = !b.modules[y*b.Size+x]
+			}
+		}
+	}
+}
+
+// applyBestMask - выбор маски с наименьшим штрафом и запись информации о формате.
+func (b *builder) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		b.applyMask(mask)
+		b.drawFormat(mask)
+		if penalty := b.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		b.applyMask(mask)
+	}
+	b.applyMask(best)
+	b.drawFormat(best)
+	b.Mask = best
+}
+
+// maskBit - инвертируется ли модуль (x, y) маской.
+func maskBit(mask int, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// penalty - штраф матрицы по четырем правилам стандарта, чем меньше, тем легче считывание.
+func (b *builder) penalty() int {
+	size := b.Size
+	result := 0
+	dark := 0
+	// Серии одного цвета и узоры, похожие на поисковые, по строкам и столбцам
+	line := make([]bool, size)
+	for _, vertical := range []bool{false, true} {
+		for i := 0; i < size; i++ {
+			for j := 0; j < size; j++ {
+				if vertical {
+					line[j] = b.Dark(i, j)
+				} else {
+					line[j] = b.Dark(j, i)
+				}
+			}
+			result += linePenalty(line)
+		}
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			color := b.Dark(x, y)
+			if color {
+				dark++
+			}
+			// Блоки 2x2 одного цвета
+			if x < size-1 && y < size-1 && color == b.Dark(x+1, y) && color == b.Dark(x, y+1) && color == b.Dark(x+1, y+1) {
+				result += 3
+			}
+		}
+	}
+	// Отклонение доли темных модулей от половины, по 10 за каждые 5%
+	total := size * size
+	result += abs(dark*20-total*10) / total * 10
+	return result
+}
+
+// finderLike - узор 1:1:3:1:1 со светлой полосой из четырех модулей.
+var finderLike = []bool{true, false, true, true, true, false, true, false, false, false, false}
+
+// linePenalty - штраф за серии и узоры, похожие на поисковые, в одной строке или столбце.
+func linePenalty(line []bool) int {
+	result := 0
+	run := 1
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+		if run >= 5 {
+			result += 3 + run - 5
+		}
+		run = 1
+	}
+	for i := 0; i+len(finderLike) <= len(line); i++ {
+		forward, backward := true, true
+		for j, value := range finderLike {
+			forward = forward && line[i+j] == value
+			backward = backward && line[i+len(finderLike)-1-j] == value
+		}
+		if forward {
+			result += 40
+		}
+		if backward {
+			result += 40
+		}
+	}
+	return result
+}
+
+// formatBits - 15 бит информации о формате с кодом БЧХ и маской 101010000010010.
+func formatBits(level Level, mask int) int {
+	data := formatLevelBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionBits - 18 бит информации о версии с кодом Голея.
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+	return version<<12 | rem
+}
+
+// alignmentPositions - координаты центров выравнивающих узоров по каждой оси.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// abs - модуль целого числа.
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,239 @@
+// Модуль содержит тесты кодирования QR кода
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// Пример HELLO WORLD версии 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	assert.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, rsRemainder(data, rsGenerator(10)))
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	assert.Equal(t, 0b111011111000100, formatBits(LevelL, 0))
+	assert.Equal(t, 0b101010000010010, formatBits(LevelM, 0))
+	assert.Equal(t, 0b011010101011111, formatBits(LevelQ, 0))
+	assert.Equal(t, 0b001011010001001, formatBits(LevelH, 0))
+	assert.Equal(t, 0b100101010100000, formatBits(LevelM, 7))
+	assert.Equal(t, 0b000111110010010100, versionBits(7))
+	assert.Equal(t, 0b101000110001101001, versionBits(40))
+}
+
+func TestCapacity(t *testing.T) {
+	assert.Nil(t, alignmentPositions(1))
+	assert.Equal(t, []int{6, 22, 38}, alignmentPositions(7))
+	assert.Equal(t, []int{6, 34, 60, 86, 112, 138}, alignmentPositions(32))
+	assert.Equal(t, []int{6, 30, 58, 86, 114, 142, 170}, alignmentPositions(40))
+
+	assert.Equal(t, 19, dataCodewords(1, LevelL))
+	assert.Equal(t, 16, dataCodewords(1, LevelM))
+	assert.Equal(t, 154, dataCodewords(10, LevelQ))
+	assert.Equal(t, 2956, dataCodewords(40, LevelL))
+	assert.Equal(t, 1276, dataCodewords(40, LevelH))
+
+	// Число служебных модулей совпадает с нарисованными шаблонами
+	for version := minVersion; version <= maxVersion; version++ {
+		b := newCode(version, LevelM)
+		free := 0
+		for _, function := range b.function {
+			if !function {
+				free++
+			}
+		}
+		assert.Equal(t, rawDataModules(version), free, version)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	code, err := Encode([]byte("https://example.com/abc"), LevelM)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, code.Version)
+	assert.Equal(t, 25, code.Size)
+
+	code, err = Encode([]byte("https://example.com/abc"), LevelH)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code.Version)
+
+	_, err = Encode(make([]byte, 2953), LevelL)
+	assert.NoError(t, err)
+	_, err = Encode(make([]byte, 2332), LevelM)
+	assert.ErrorIs(t, err, ErrDataTooLong)
+
+	level, err := ParseLevel("q")
+	assert.NoError(t, err)
+	assert.Equal(t, LevelQ, level)
+	_, err = ParseLevel("X")
+	assert.Error(t, err)
+}
+
+// decode - чтение данных из матрицы в обратном порядке построения для проверки кодирования.
+func decode(t *testing.T, code *Code) []byte {
+	b := newCode(code.Version, code.Level)
+	copy(b.modules, code.modules)
+
+	// Обе копии информации о формате совпадают с маской кода
+	var first, second int
+	for i := 0; i < 15; i++ {
+		var x1, y1, x2, y2 int
+		switch {
+		case i <= 5:
+			x1, y1 = 8, i
+		case i <= 7:
+			x1, y1 = 8, i+1
+		case i == 8:
+			x1, y1 = 7, 8
+		default:
+			x1, y1 = 14-i, 8
+		}
+		if i < 8 {
+			x2, y2 = code.Size-1-i, 8
+		} else {
+			x2, y2 = 8, code.Size-15+i
+		}
+		if code.Dark(x1, y1) {
+			first |= 1 << i
+		}
+		if code.Dark(x2, y2) {
+			second |= 1 << i
+		}
+	}
+	if !assert.Equal(t, formatBits(code.Level, code.Mask), first) || !assert.Equal(t, first, second) {
+		return nil
+	}
+
+	// Снятие маски и чтение кодовых слов зигзагом
+	b.applyMask(code.Mask)
+	var raw []byte
+	var current byte
+	count := 0
+	for right := b.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < b.Size; vert++ {
+			y := vert
+			if upward {
+				y = b.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if b.function[y*b.Size+x] {
+					continue
+				}
+				current <<= 1
+				if b.Dark(x, y) {
+					current |= 1
+				}
+				if count++; count%8 == 0 {
+					raw = append(raw, current)
+				}
+			}
+		}
+	}
+
+	// Сборка блоков из чередующихся кодовых слов и проверка кодов коррекции
+	numBlocks := eccBlocks[code.Level][code.Version]
+	blockECCLen := eccCodewordsPerBlock[code.Level][code.Version]
+	rawCodewords := rawDataModules(code.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	padIndex := rawCodewords/numBlocks - blockECCLen
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= rawCodewords/numBlocks; i++ {
+		for j := range blocks {
+			if i != padIndex || j >= numShortBlocks {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for j, block := range blocks {
+		dataLen := padIndex
+		if j >= numShortBlocks {
+			dataLen++
+		}
+		if !assert.Equal(t, block[dataLen:], rsRemainder(block[:dataLen], rsGenerator(blockECCLen))) {
+			return nil
+		}
+		data = append(data, block[:dataLen]...)
+	}
+
+	// Режим байтов, длина и сами данные
+	var bits bitBuffer
+	for _, value := range data {
+		bits.append(int(value), 8)
+	}
+	read := func(offset, length int) int {
+		value := 0
+		for _, bit := range bits[offset : offset+length] {
+			value <<= 1
+			if bit {
+				value |= 1
+			}
+		}
+		return value
+	}
+	if !assert.Equal(t, 0x4, read(0, 4)) {
+		return nil
+	}
+	length := read(4, charCountBits(code.Version))
+	output := make([]byte, length)
+	for i := range output {
+		output[i] = byte(read(4+charCountBits(code.Version)+i*8, 8))
+	}
+	return output
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, size := range []int{0, 1, 17, 100, 300, 1000, 2300} {
+		for level := LevelL; level <= LevelH; level++ {
+			data := make([]byte, size)
+			for i := range data {
+				data[i] = byte(i*31 + size)
+			}
+			code, err := Encode(data, level)
+			if err != nil {
+				assert.ErrorIs(t, err, ErrDataTooLong)
+				continue
+			}
+			t.Run(fmt.Sprintf("%d-%s-v%d", size, level, code.Version), func(t *testing.T) {
+				assert.Equal(t, data, decode(t, code))
+			})
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode([]byte("https://example.com/abc"), LevelM)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, code.PNG(&buf, 300, 4))
+	img, err := png.Decode(&buf)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// 25 модулей и два поля по 4 модуля, по 9 пикселей на модуль
+	assert.Equal(t, 297, img.Bounds().Dx())
+	r, _, _, _ := img.At(4*9, 4*9).RGBA()
+	assert.Zero(t, r, "левый верхний угол поискового узора темный")
+	r, _, _, _ = img.At(0, 0).RGBA()
+	assert.NotZero(t, r, "поле светлое")
+
+	buf.Reset()
+	assert.NoError(t, code.SVG(&buf, 300, 2))
+	svg := buf.String()
+	assert.True(t, strings.HasPrefix(svg, "<?xml"))
+	assert.Contains(t, svg, `width="300" height="300" viewBox="0 0 29 29"`)
+	assert.Contains(t, svg, `M2 2h7v1h-7z`)
+}
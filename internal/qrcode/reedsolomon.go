@@ -0,0 +1,52 @@
+// Модуль содержит коды Рида-Соломона над полем GF(256) для исправления ошибок QR кода.
+package qrcode
+
+// gfPoly - образующий многочлен поля GF(256) x^8 + x^4 + x^3 + x^2 + 1.
+const gfPoly = 0x11d
+
+// gfMultiply - умножение в поле GF(256).
+func gfMultiply(x, y byte) byte {
+	var result byte
+	for i := 7; i >= 0; i-- {
+		// Умножение результата на x с приведением по модулю образующего многочлена
+		carry := result >> 7
+		result = result<<1 ^ byte(carry*(gfPoly&0xff))
+		if (y>>i)&1 != 0 {
+			result ^= x
+		}
+	}
+	return result
+}
+
+// rsGenerator - коэффициенты порождающего многочлена степени degree без старшего,
+// произведение (x - a^i) для i от 0 до degree-1, где a = 2.
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1 // многочлен 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		// Умножение на (x - root)
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+	return result
+}
+
+// rsRemainder - кодовые слова исправления ошибок для блока данных.
+func rsRemainder(data []byte, generator []byte) []byte {
+	result := make([]byte, len(generator))
+	for _, value := range data {
+		factor := value ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coefficient := range generator {
+			result[i] ^= gfMultiply(coefficient, factor)
+		}
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+// Модуль содержит вывод QR кода в форматах PNG и SVG.
+package qrcode
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Scale - размер модуля в пикселях, чтобы код с полем margin модулей помещался в size пикселей.
+// Размер модуля не меньше одного пикселя.
+func (c *Code) Scale(size int, margin int) int {
+	return max(1, size/(c.Size+2*margin))
+}
+
+// PNG - черно-белое изображение с полем margin модулей не больше size пикселей по стороне.
+// Размер модуля целый, поэтому изображение может быть меньше size.
+func (c *Code) PNG(w io.Writer, size int, margin int) error {
+	scale := c.Scale(size, margin)
+	side := (c.Size + 2*margin) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				offset := img.PixOffset((x+margin)*scale, (y+margin)*scale+dy)
+				for dx := 0; dx < scale; dx++ {
+					img.Pix[offset+dx] = 1
+				}
+			}
+		}
+	}
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	return encoder.Encode(w, img)
+}
+
+// SVG - векторное изображение с полем margin модулей и стороной size пикселей.
+// Темные модули одной строки объединяются в прямоугольники одного пути.
+func (c *Code) SVG(w io.Writer, size int, margin int) error {
+	side := c.Size + 2*margin
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		size, size, side, side)
+	fmt.Fprintf(out, `<rect width="100%%" height="100%%" fill="#ffffff"/>`+"\n")
+	fmt.Fprint(out, `<path fill="#000000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; {
+			if !c.Dark(x, y) {
+				x++
+				continue
+			}
+			run := 1
+			for x+run < c.Size && c.Dark(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(out, "M%d %dh%dv1h-%dz", x+margin, y+margin, run, run)
+			x += run
+		}
+	}
+	fmt.Fprint(out, `"/>`+"\n</svg>\n")
+	return out.Flush()
+}
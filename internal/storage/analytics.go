@@ -0,0 +1,160 @@
+// Модуль содержит хранилище аналитики переходов по коротким ссылкам.
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dayLayout - формат даты для группировки переходов по дням.
+const dayLayout = "2006-01-02"
+
+// maxRecentClicks - количество последних переходов по ссылке, возвращаемых в статистике.
+const maxRecentClicks = 20
+
+// clickRetentionDays - сколько суток, считая сутки последнего перехода, хранятся переходы по дням
+// и уникальные клиенты в памяти.
+const clickRetentionDays = 30
+
+// ClickEvent - событие перехода по короткой ссылке.
+type ClickEvent struct {
+	ShortHash string
+	Timestamp time.Time
+	Referrer  string
+	UserAgent string
+	IPHash    string // хеш адреса клиента, сам адрес не хранится
+}
+
+// DailyClicks - количество переходов за день.
+type DailyClicks struct {
+	Date   string
+	Clicks int
+}
+
+// ClickStats - сводная статистика переходов по ссылке.
+type ClickStats struct {
+	Total  int
+	Unique int // количество уникальных клиентов, в памяти - за хранимые сутки
+	Daily  []DailyClicks
+	Recent []ClickEvent // последние переходы, от новых к старым
+}
+
+// AnalyticsStorage - интерфейс хранилища аналитики переходов.
+// Операции прерываются при отмене или истечении срока переданного контекста.
+type AnalyticsStorage interface {
+	RecordClick(ctx context.Context, event ClickEvent) error              // сохраняет событие перехода
+	ClickStats(ctx context.Context, shortHash string) (ClickStats, error) // возвращает статистику по ссылке
+}
+
+// dayClicks - переходы по ссылке за одни сутки.
+type dayClicks struct {
+	clicks int
+	unique map[string]struct{} // хеши адресов клиентов за сутки
+}
+
+// linkClicks - агрегированные переходы по одной ссылке.
+type linkClicks struct {
+	total  int
+	days   map[string]*dayClicks // дата -> переходы за сутки, не больше clickRetentionDays суток
+	latest time.Time             // начало суток последнего перехода
+	recent []ClickEvent          // кольцевой буфер последних переходов
+	next   int                   // позиция следующей записи в recent
+}
+
+// oldestDay - самая ранняя хранимая дата.
+func (l *linkClicks) oldestDay() string {
+	return l.latest.AddDate(0, 0, -(clickRetentionDays - 1)).Format(dayLayout)
+}
+
+// prune - удаление суток старше срока хранения.
+func (l *linkClicks) prune() {
+	oldest := l.oldestDay()
+	for date := range l.days {
+		if date < oldest {
+			delete(l.days, date)
+		}
+	}
+}
+
+// ClicksInMemory - хранилище аналитики в памяти ПК.
+// События не накапливаются: по ссылке хранятся общий счетчик, не больше maxRecentClicks последних переходов
+// и переходы с уникальными клиентами по суткам за clickRetentionDays суток до последнего перехода.
+type ClicksInMemory struct {
+	mu    sync.Mutex
+	links map[string]*linkClicks // hash -> переходы по ссылке
+}
+
+// NewClicksInMemory - конструктор.
+func NewClicksInMemory() *ClicksInMemory {
+	return &ClicksInMemory{links: make(map[string]*linkClicks)}
+}
+
+// RecordClick - сохранение события перехода.
+func (c *ClicksInMemory) RecordClick(ctx context.Context, event ClickEvent) error {
+	if err := ctx.Err(); err != nil {
+		return NewStorageError(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	link, exists := c.links[event.ShortHash]
+	if !exists {
+		link = &linkClicks{days: make(map[string]*dayClicks)}
+		c.links[event.ShortHash] = link
+	}
+	link.total += 1
+	if dayStart := DayStart(event.Timestamp); dayStart.After(link.latest) {
+		link.latest = dayStart
+		link.prune()
+	}
+	// Переход старше срока хранения учитывается только в общем счетчике
+	if date := event.Timestamp.UTC().Format(dayLayout); date >= link.oldestDay() {
+		day, exists := link.days[date]
+		if !exists {
+			day = &dayClicks{unique: make(map[string]struct{})}
+			link.days[date] = day
+		}
+		day.clicks += 1
+		day.unique[event.IPHash] = struct{}{}
+	}
+	if len(link.recent) < maxRecentClicks {
+		link.recent = append(link.recent, event)
+	} else {
+		link.recent[link.next] = event
+	}
+	link.next = (link.next + 1) % maxRecentClicks
+	return nil
+}
+
+// ClickStats - статистика переходов по ссылке.
+func (c *ClicksInMemory) ClickStats(ctx context.Context, shortHash string) (ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return ClickStats{}, NewStorageError(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := ClickStats{}
+	link, exists := c.links[shortHash]
+	if !exists {
+		return stats, nil
+	}
+	stats.Total = link.total
+	unique := make(map[string]struct{})
+	for date, day := range link.days {
+		stats.Daily = append(stats.Daily, DailyClicks{Date: date, Clicks: day.clicks})
+		for ipHash := range day.unique {
+			unique[ipHash] = struct{}{}
+		}
+	}
+	stats.Unique = len(unique)
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date < stats.Daily[j].Date
+	})
+	// Обход кольцевого буфера от последней записи назад
+	for i := 1; i <= len(link.recent); i++ {
+		stats.Recent = append(stats.Recent, link.recent[(link.next-i+maxRecentClicks)%maxRecentClicks])
+	}
+	return stats, nil
+}
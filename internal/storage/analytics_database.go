@@ -0,0 +1,96 @@
+// Модуль содержит хранилище аналитики переходов в БД Postgres
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ClicksInPostgres - хранилище аналитики в базе данных Postgres.
+type ClicksInPostgres struct {
+	poolConnectionToDB DBPool
+}
+
+// NewClicksInPostgres - конструктор, использует пул соединений основного хранилища.
+func NewClicksInPostgres(mainStorage *StorageInPostgres) *ClicksInPostgres {
+	return &ClicksInPostgres{poolConnectionToDB: mainStorage.poolConnectionToDB}
+}
+
+// RecordClick - сохранение события перехода.
+func (c *ClicksInPostgres) RecordClick(ctx context.Context, event ClickEvent) error {
+	query := `
+		INSERT INTO clicks (short, clicked_at, referrer, user_agent, ip_hash)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := c.poolConnectionToDB.Exec(ctx, query,
+		event.ShortHash, event.Timestamp, event.Referrer, event.UserAgent, event.IPHash)
+	if err != nil {
+		log.Printf("Failed to record click: %v\n", err)
+		return NewStorageError(err)
+	}
+	return nil
+}
+
+// ClickStats - статистика переходов по ссылке.
+func (c *ClicksInPostgres) ClickStats(ctx context.Context, shortHash string) (ClickStats, error) {
+	stats := ClickStats{}
+
+	query := "SELECT count(*), count(DISTINCT ip_hash) FROM clicks WHERE short = $1"
+	err := c.poolConnectionToDB.QueryRow(ctx, query, shortHash).Scan(&stats.Total, &stats.Unique)
+	if err != nil {
+		log.Printf("Failed to count clicks: %v\n", err)
+		return stats, NewStorageError(err)
+	}
+
+	query = `
+		SELECT date_trunc('day', clicked_at AT TIME ZONE 'UTC') AS day, count(*)
+		FROM clicks WHERE short = $1
+		GROUP BY day ORDER BY day
+	`
+	rows, err := c.poolConnectionToDB.Query(ctx, query, shortHash)
+	if err != nil {
+		log.Printf("Failed to group clicks: %v\n", err)
+		return stats, NewStorageError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day time.Time
+		var clicks int
+		if err := rows.Scan(&day, &clicks); err != nil {
+			log.Printf("failed to scan row: %s", err)
+			return stats, NewStorageError(err)
+		}
+		stats.Daily = append(stats.Daily, DailyClicks{Date: day.Format(dayLayout), Clicks: clicks})
+	}
+
+	if rows.Err() != nil {
+		return stats, NewStorageError(rows.Err())
+	}
+
+	query = `
+		SELECT clicked_at, referrer, user_agent, ip_hash
+		FROM clicks WHERE short = $1
+		ORDER BY clicked_at DESC LIMIT $2
+	`
+	recentRows, err := c.poolConnectionToDB.Query(ctx, query, shortHash, maxRecentClicks)
+	if err != nil {
+		log.Printf("Failed to read recent clicks: %v\n", err)
+		return stats, NewStorageError(err)
+	}
+	defer recentRows.Close()
+
+	for recentRows.Next() {
+		event := ClickEvent{ShortHash: shortHash}
+		if err := recentRows.Scan(&event.Timestamp, &event.Referrer, &event.UserAgent, &event.IPHash); err != nil {
+			log.Printf("failed to scan row: %s", err)
+			return stats, NewStorageError(err)
+		}
+		stats.Recent = append(stats.Recent, event)
+	}
+	if recentRows.Err() != nil {
+		return stats, NewStorageError(recentRows.Err())
+	}
+	return stats, nil
+}
@@ -0,0 +1,123 @@
+// Модуль содержит тесты хранилища аналитики переходов
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClicksInMemory - тестирование статистики переходов в памяти.
+func TestClicksInMemory(t *testing.T) {
+
+	clicks := NewClicksInMemory()
+	firstDay := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	secondDay := firstDay.Add(24 * time.Hour)
+
+	assert.NoError(t, clicks.RecordClick(context.Background(), ClickEvent{ShortHash: "77fca5950e", Timestamp: firstDay, IPHash: "a"}))
+	assert.NoError(t, clicks.RecordClick(context.Background(), ClickEvent{ShortHash: "77fca5950e", Timestamp: secondDay, IPHash: "a"}))
+	assert.NoError(t, clicks.RecordClick(context.Background(), ClickEvent{ShortHash: "77fca5950e", Timestamp: secondDay, IPHash: "b"}))
+	assert.NoError(t, clicks.RecordClick(context.Background(), ClickEvent{ShortHash: "41c9cc9cba", Timestamp: secondDay, IPHash: "b"}))
+
+	stats, err := clicks.ClickStats(context.Background(), "77fca5950e")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, 2, stats.Unique)
+	assert.Equal(t, []DailyClicks{{Date: "2024-05-01", Clicks: 1}, {Date: "2024-05-02", Clicks: 2}}, stats.Daily)
+	assert.Len(t, stats.Recent, 3)
+	assert.Equal(t, "b", stats.Recent[0].IPHash)
+	assert.True(t, firstDay.Equal(stats.Recent[2].Timestamp))
+
+	stats, err = clicks.ClickStats(context.Background(), "NotExist")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.Total)
+
+	// Прерванный контекст
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, clicks.RecordClick(ctx, ClickEvent{ShortHash: "77fca5950e", Timestamp: firstDay, IPHash: "c"}))
+	_, err = clicks.ClickStats(ctx, "77fca5950e")
+	assert.Error(t, err)
+}
+
+// TestClicksInMemoryRecentWindow - хранится не больше maxRecentClicks последних событий, счетчики учитывают все.
+func TestClicksInMemoryRecentWindow(t *testing.T) {
+
+	clicks := NewClicksInMemory()
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	total := maxRecentClicks*2 + 5
+	for i := 0; i < total; i++ {
+		event := ClickEvent{ShortHash: "77fca5950e", Timestamp: start.Add(time.Duration(i) * time.Minute), IPHash: "a"}
+		assert.NoError(t, clicks.RecordClick(context.Background(), event))
+	}
+
+	stats, err := clicks.ClickStats(context.Background(), "77fca5950e")
+	assert.NoError(t, err)
+	assert.Equal(t, total, stats.Total)
+	assert.Equal(t, 1, stats.Unique)
+	assert.Equal(t, []DailyClicks{{Date: "2024-05-01", Clicks: total}}, stats.Daily)
+	assert.Len(t, stats.Recent, maxRecentClicks)
+	for i, event := range stats.Recent {
+		assert.True(t, start.Add(time.Duration(total-1-i)*time.Minute).Equal(event.Timestamp))
+	}
+	assert.Len(t, clicks.links["77fca5950e"].recent, maxRecentClicks)
+}
+
+// TestClicksInMemoryRetention - переходы по дням и уникальные клиенты хранятся только за clickRetentionDays суток.
+func TestClicksInMemoryRetention(t *testing.T) {
+
+	clicks := NewClicksInMemory()
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < clickRetentionDays+5; i++ {
+		event := ClickEvent{ShortHash: "77fca5950e", Timestamp: start.AddDate(0, 0, i), IPHash: fmt.Sprintf("ip-%d", i)}
+		assert.NoError(t, clicks.RecordClick(context.Background(), event))
+	}
+	// Опоздавший переход за удаленные сутки не возвращает их
+	assert.NoError(t, clicks.RecordClick(context.Background(), ClickEvent{ShortHash: "77fca5950e", Timestamp: start, IPHash: "late"}))
+
+	stats, err := clicks.ClickStats(context.Background(), "77fca5950e")
+	assert.NoError(t, err)
+	assert.Equal(t, clickRetentionDays+6, stats.Total)
+	assert.Equal(t, clickRetentionDays, stats.Unique)
+	assert.Len(t, stats.Daily, clickRetentionDays)
+	assert.Equal(t, "2024-05-06", stats.Daily[0].Date)
+	assert.Len(t, clicks.links["77fca5950e"].days, clickRetentionDays)
+}
+
+// TestClicksInPostgres - тестирование статистики переходов в Postgres.
+func TestClicksInPostgres(t *testing.T) {
+	mainStorage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	clicks := NewClicksInPostgres(mainStorage)
+
+	event := ClickEvent{ShortHash: "77fca595", Timestamp: time.Now(), Referrer: "https://ya.ru/", UserAgent: "test", IPHash: "a"}
+	mockDB.ExpectExec("INSERT INTO clicks").
+		WithArgs(event.ShortHash, event.Timestamp, event.Referrer, event.UserAgent, event.IPHash).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, clicks.RecordClick(context.Background(), event))
+
+	mockDB.ExpectQuery("SELECT count").
+		WithArgs("77fca595").
+		WillReturnRows(pgxmock.NewRows([]string{"count", "count"}).AddRow(3, 2))
+	mockDB.ExpectQuery("SELECT date_trunc").
+		WithArgs("77fca595").
+		WillReturnRows(pgxmock.NewRows([]string{"day", "count"}).
+			AddRow(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), 1).
+			AddRow(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), 2))
+	mockDB.ExpectQuery("SELECT clicked_at").
+		WithArgs("77fca595", maxRecentClicks).
+		WillReturnRows(pgxmock.NewRows([]string{"clicked_at", "referrer", "user_agent", "ip_hash"}).
+			AddRow(event.Timestamp, event.Referrer, event.UserAgent, event.IPHash))
+
+	stats, err := clicks.ClickStats(context.Background(), "77fca595")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, 2, stats.Unique)
+	assert.Equal(t, []DailyClicks{{Date: "2024-05-01", Clicks: 1}, {Date: "2024-05-02", Clicks: 2}}, stats.Daily)
+	assert.Equal(t, []ClickEvent{event}, stats.Recent)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -2,44 +2,137 @@
 package storage
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"regexp"
+	"time"
 )
 
-// Storage - интерфейс для записи/чтения данных
+// maxAliasLength - максимальная длина пользовательского псевдонима.
+const maxAliasLength = 64
+
+// aliasPattern - допустимые символы псевдонима.
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedAliases - псевдонимы, совпадающие с маршрутами сервиса.
+var reservedAliases = map[string]struct{}{
+	"api":   {},
+	"ping":  {},
+	"debug": {},
+}
+
+// IsValidAlias - проверяет, что псевдоним можно использовать как короткую ссылку.
+func IsValidAlias(alias string) bool {
+	if len(alias) == 0 || len(alias) > maxAliasLength {
+		return false
+	}
+	if _, reserved := reservedAliases[alias]; reserved {
+		return false
+	}
+	return aliasPattern.MatchString(alias)
+}
+
+// Storage - интерфейс для записи/чтения данных.
+// Операции прерываются при отмене или истечении срока переданного контекста.
 type Storage interface {
-	Save(value string, userUID string) (string, error)        // возвращает хеш ссылки
-	Get(hashKey string) (string, bool)                        // возвращает origin ссылку или "" если не найдено
-	Close()                                                   // освобождение ресурсов
-	FindByUserUID(userUID string) ([]ShortHashURL, error)     // поиск сокращенных ссылок от пользователя
-	IsDeleted(hashKey string) (bool, error)                   // проверяет удалена ли ссылка по ее хешу
-	DeleteByUser(shortHashURL []string, userUID string) error // удаление всех ссылок конкретного пользователя
+	Save(ctx context.Context, value string, userUID string) (string, error)                                        // возвращает хеш ссылки
+	SaveLink(ctx context.Context, value string, alias string, userUID string, options LinkOptions) (string, error) // сохраняет ссылку вместе с признаками, пустой alias - код генерируется
+	Get(ctx context.Context, hashKey string) (string, bool)                                                        // возвращает origin ссылку или "" если не найдено
+	Close()                                                                                                        // освобождение ресурсов
+	FindByUserUID(ctx context.Context, userUID string) ([]ShortHashURL, error)                                     // поиск сокращенных ссылок от пользователя
+	QueryByUser(ctx context.Context, userUID string, query URLQuery) (URLPage, error)                              // страница ссылок пользователя с сортировкой и фильтрами
+	IsDeleted(ctx context.Context, hashKey string) (bool, error)                                                   // проверяет удалена ли ссылка по ее хешу
+	DeleteByUser(ctx context.Context, shortHashURL []string, userUID string) error                                 // удаление всех ссылок конкретного пользователя
+	RestoreByUser(ctx context.Context, shortHashURL []string, userUID string) error                                // восстановление удаленных ссылок конкретного пользователя
+	UpdateByUser(ctx context.Context, hashKey string, patch LinkPatch, userUID string) error                       // изменение неудаленной ссылки ее владельцем одной операцией
+	SearchByUser(ctx context.Context, userUID string, query string, limit int) ([]ShortHashURL, error)             // поиск неудаленных ссылок пользователя по словам запроса
+	GetLink(ctx context.Context, hashKey string) (ShortHashURL, error)                                             // возвращает ссылку со всеми полями
+	ExpirationStorage
+	LinkLimitStorage
+}
+
+// ExpirationStorage - интерфейс для ссылок с ограниченным сроком действия, срок задается при сохранении или изменении ссылки.
+type ExpirationStorage interface {
+	MarkExpired(ctx context.Context, now time.Time) (int, error) // помечает просроченные ссылки, возвращает их количество
+}
+
+// LinkLimitStorage - интерфейс для ограничений количества ссылок пользователя, проверяемых при вставке.
+type LinkLimitStorage interface {
+	SetLinkLimits(limits LinkLimits)                                    // устанавливает ограничения для всех последующих вставок
+	CountByUser(ctx context.Context, userUID string) (LinkCount, error) // количество ссылок пользователя всего и за текущие сутки
 }
 
 // CorrelationURL - оригинальная ссылка с идентификатором.
 type CorrelationURL struct {
 	CorrelationID string
 	OriginalURL   string
+	Options       LinkOptions // признаки, сохраняемые вместе со ссылкой
+}
+
+// LinkOptions - признаки новой ссылки, записываются одной операцией с самой ссылкой,
+// чтобы ссылка не становилась доступной без пароля или срока действия.
+type LinkOptions struct {
+	ExpiresAt    time.Time   // нулевое значение - ссылка бессрочная
+	Tags         []string    // нормализованные теги владельца
+	Preview      bool        // переход через страницу предпросмотра
+	PasswordHash string      // медленный хеш пароля, пусто - ссылка без пароля
+	RedirectType int         // код перенаправления, 0 - код по умолчанию
+	QueryRules   *QueryRules // правила параметров запроса, nil - общие правила
+	Notes        string      // заметка владельца, участвует в поиске
+}
+
+// LinkPatch - изменения ссылки владельцем, применяются вместе или не применяются совсем.
+type LinkPatch struct {
+	OriginalURL  string      // новая оригинальная ссылка, пусто - не меняется
+	ExpiresAt    time.Time   // новый срок действия, нулевое значение - не меняется
+	Tags         *[]string   // нормализованные теги владельца, nil - не меняются
+	Preview      *bool       // переход через страницу предпросмотра, nil - не меняется
+	RedirectType *int        // код перенаправления, nil - не меняется
+	QueryRules   *QueryRules // правила параметров запроса, nil - не меняются, пустые правила - общие правила
+	Notes        *string     // заметка владельца, nil - не меняется, пустая строка удаляет заметку
+}
+
+// linkQueryRules - правила параметров запроса ссылки после изменения, nil - общие правила.
+func (p LinkPatch) linkQueryRules() *QueryRules {
+	if p.QueryRules == nil || p.QueryRules.IsZero() {
+		return nil
+	}
+	rules := *p.QueryRules
+	return &rules
 }
 
 // ShortHashURL - оригинальная ссылка с короткой обработанной.
 type ShortHashURL struct {
-	ShortHash   string
-	OriginalURL string
+	ShortHash    string
+	OriginalURL  string
+	UserUID      string    // владелец, пусто - ссылка без владельца
+	PasswordHash string    // медленный хеш пароля, пусто - ссылка без пароля, наружу не выдается
+	CreatedAt    time.Time // нулевое значение - ссылка сохранена до появления поля
+	ExpiresAt    time.Time // нулевое значение - ссылка бессрочная
+	Expired      bool
+	Deleted      bool
+	Tags         []string    // в порядке сортировки
+	Preview      bool        // переход через страницу предпросмотра
+	RedirectType int         // код перенаправления, 0 - код по умолчанию
+	QueryRules   *QueryRules // правила параметров запроса, nil - общие правила
+	Notes        string      // заметка владельца
 }
 
 // CorrelationStorage - интерфейс для хранилища, которое хранит ссылки с идентификатором.
 type CorrelationStorage interface {
-	CorrelationSave(value string, correlationID string, userUID string) string           // возвращает хеш ссылки
-	CorrelationGet(correlationID string) (string, bool)                                  // возвращает origin ссылку
-	CorrelationsSave(correlationURLs []CorrelationURL, userUID string) ([]string, error) // возвращает срез хеш ссылок
+	CorrelationSave(ctx context.Context, value string, correlationID string, userUID string) (string, error)  // возвращает хеш ссылки
+	CorrelationGet(ctx context.Context, correlationID string) (string, bool)                                  // возвращает origin ссылку
+	CorrelationsSave(ctx context.Context, correlationURLs []CorrelationURL, userUID string) ([]string, error) // возвращает срез хеш ссылок
+	ExpirationStorage
 }
 
 // StorageFile - интерфейс для записи/чтения данных из файла.
 type StorageFile interface {
-	LoadData(pathToFile string) int
-	SaveData(pathToFile string) int
+	LoadData(ctx context.Context, pathToFile string) (int, error) // возвращает количество загруженных записей
+	SaveData(ctx context.Context, pathToFile string) (int, error) // возвращает количество сохраненных записей
 }
 
 // PersistanceStorage - Объединение интерфейсов.
@@ -50,14 +143,30 @@ type PersistanceStorage interface {
 }
 
 func makeHash(value string, length int) string {
-	output := ""
+	return truncateHash(sha256Hex(value), length)
+}
+
+// sha256Hex - хеш SHA-256 строки в шестнадцатеричном виде.
+func sha256Hex(value string) string {
 	hash := sha256.New()
 	hash.Write([]byte(value))
-	hashKey := hex.EncodeToString(hash.Sum(nil))
-	output = hashKey[:length]
-	return output
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
+// truncateHash - первые length символов хеша.
+func truncateHash(hashKey string, length int) string {
+	if length > len(hashKey) {
+		return hashKey
+	}
+	return hashKey[:length]
+}
+
+// ErrNotFound - короткая ссылка не найдена, проверяется через errors.Is.
+var ErrNotFound = errors.New("not found")
+
+// ErrDeleted - ссылка удалена и не может быть изменена, проверяется через errors.Is.
+var ErrDeleted = errors.New("is deleted")
+
 // TODO реализовать обертывание в эту ошибку все другие более "мелкие"
 type StorageError struct {
 	Err error
@@ -75,6 +184,11 @@ func (se *StorageError) Error() string {
 	return fmt.Sprintf("%v", se.Err)
 }
 
+// Unwrap - исходная ошибка, например context.DeadlineExceeded.
+func (se *StorageError) Unwrap() error {
+	return se.Err
+}
+
 // UniqURLError - сущность кастомного исключения.
 type UniqURLError struct {
 	ExistURL  string
@@ -90,3 +204,18 @@ func NewUniqURLError(existURL string, shortHash string) error {
 func (e *UniqURLError) Error() string {
 	return fmt.Sprintf("uniq error with %s", e.ExistURL)
 }
+
+// AliasTakenError - псевдоним уже занят другой ссылкой.
+type AliasTakenError struct {
+	Alias string
+}
+
+// NewAliasTakenError - конструктор исключения занятого псевдонима.
+func NewAliasTakenError(alias string) error {
+	return &AliasTakenError{Alias: alias}
+}
+
+// Error - реализация метода.
+func (e *AliasTakenError) Error() string {
+	return fmt.Sprintf("alias %s is already taken", e.Alias)
+}
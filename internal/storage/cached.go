@@ -0,0 +1,273 @@
+// Модуль содержит кеширующий декоратор хранилища
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheItem - закешированная ссылка со всеми полями.
+type cacheItem struct {
+	hashKey   string
+	link      ShortHashURL
+	expiresAt time.Time // окончание времени жизни записи кеша
+}
+
+// current - копия ссылки с признаком истечения срока действия на момент now.
+func (item *cacheItem) current(now time.Time) ShortHashURL {
+	link := item.link
+	link.Expired = link.Expired || (!link.ExpiresAt.IsZero() && !now.Before(link.ExpiresAt))
+	link.Tags = append([]string(nil), link.Tags...)
+	return link
+}
+
+// cacheFill - чтения из хранилища для заполнения записи кеша, идущие одновременно.
+// Изменение ссылки во время чтения помечает заполнение устаревшим, прочитанное значение в кеш не попадает.
+type cacheFill struct {
+	hashKey string
+	readers int
+	stale   bool
+}
+
+// CacheStats - счетчики кеша.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// CachedStorage - декоратор любого хранилища с ограниченным LRU кешем hash -> ссылка со всеми полями,
+// из которой читаются и отдельные признаки ссылки.
+// Записи живут не дольше ttl, изменения через декоратор сразу сбрасывают затронутые записи.
+// Изменения, сделанные в обход декоратора, например другим экземпляром сервиса с той же БД, видны только после ttl.
+type CachedStorage struct {
+	PersistanceStorage
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	order    *list.List // от недавно использованных к давно использованным
+	fills    map[string]*cacheFill
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+}
+
+// NewCachedStorage - конструктор, capacity - максимальное количество записей кеша.
+func NewCachedStorage(storage PersistanceStorage, capacity int, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{
+		PersistanceStorage: storage,
+		capacity:           capacity,
+		ttl:                ttl,
+		items:              make(map[string]*list.Element),
+		order:              list.New(),
+		fills:              make(map[string]*cacheFill),
+	}
+}
+
+// Stats - количество попаданий, промахов и размер кеша.
+func (c *CachedStorage) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: c.order.Len()}
+}
+
+// lookup - актуальная запись кеша, просроченная запись удаляется.
+func (c *CachedStorage) lookup(hashKey string) (cacheItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, exists := c.items[hashKey]
+	if !exists {
+		return cacheItem{}, false
+	}
+	item := element.Value.(*cacheItem)
+	if c.ttl > 0 && !time.Now().Before(item.expiresAt) {
+		c.removeElement(element)
+		return cacheItem{}, false
+	}
+	c.order.MoveToFront(element)
+	return *item, true
+}
+
+// beginFill - начало чтения из хранилища при промахе, завершается вызовом endFill.
+func (c *CachedStorage) beginFill(hashKey string) *cacheFill {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fill, exists := c.fills[hashKey]
+	if !exists {
+		fill = &cacheFill{hashKey: hashKey}
+		c.fills[hashKey] = fill
+	}
+	fill.readers++
+	return fill
+}
+
+// endFill - завершение чтения из хранилища.
+func (c *CachedStorage) endFill(fill *cacheFill) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fill.readers--
+	if fill.readers == 0 && c.fills[fill.hashKey] == fill {
+		delete(c.fills, fill.hashKey)
+	}
+}
+
+// store - запись прочитанной ссылки в кеш, давно использованные записи вытесняются.
+// Ссылка отбрасывается, если она изменилась после начала чтения fill.
+func (c *CachedStorage) store(fill *cacheFill, link ShortHashURL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fill.stale {
+		return
+	}
+	hashKey := fill.hashKey
+	element, exists := c.items[hashKey]
+	if !exists {
+		element = c.order.PushFront(&cacheItem{hashKey: hashKey})
+		c.items[hashKey] = element
+	} else {
+		c.order.MoveToFront(element)
+	}
+	item := element.Value.(*cacheItem)
+	item.link, item.expiresAt = link, time.Now().Add(c.ttl)
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// removeElement - удаление записи, вызывается под блокировкой.
+func (c *CachedStorage) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*cacheItem).hashKey)
+}
+
+// invalidate - сброс записей кеша для измененных ссылок.
+func (c *CachedStorage) invalidate(hashKeys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, hashKey := range hashKeys {
+		if element, exists := c.items[hashKey]; exists {
+			c.removeElement(element)
+		}
+		// Идущие чтения могли получить прежнее значение, следующие начнут новое заполнение
+		if fill, exists := c.fills[hashKey]; exists {
+			fill.stale = true
+			delete(c.fills, hashKey)
+		}
+	}
+}
+
+// purge - сброс всего кеша.
+func (c *CachedStorage) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	for _, fill := range c.fills {
+		fill.stale = true
+	}
+	c.fills = make(map[string]*cacheFill)
+}
+
+// GetLink - чтение ссылки со всеми полями из кеша или хранилища.
+func (c *CachedStorage) GetLink(ctx context.Context, hashKey string) (ShortHashURL, error) {
+	if item, ok := c.lookup(hashKey); ok {
+		c.hits.Add(1)
+		return item.current(time.Now()), nil
+	}
+	c.misses.Add(1)
+	fill := c.beginFill(hashKey)
+	defer c.endFill(fill)
+	link, err := c.PersistanceStorage.GetLink(ctx, hashKey)
+	if err == nil {
+		c.store(fill, link)
+	}
+	return link, err
+}
+
+// cachedLink - ссылка из кеша или хранилища, для неизвестной ссылки - нулевое значение без ошибки,
+// как в чтении отдельных признаков хранилищем.
+func (c *CachedStorage) cachedLink(ctx context.Context, hashKey string) (ShortHashURL, error) {
+	link, err := c.GetLink(ctx, hashKey)
+	if errors.Is(err, ErrNotFound) {
+		return ShortHashURL{}, nil
+	}
+	return link, err
+}
+
+// Get - чтение ссылки из кеша или хранилища.
+func (c *CachedStorage) Get(ctx context.Context, hashKey string) (string, bool) {
+	link, err := c.GetLink(ctx, hashKey)
+	if err != nil {
+		return "", false
+	}
+	return link.OriginalURL, true
+}
+
+// IsDeleted - признак удаления из кеша или хранилища.
+func (c *CachedStorage) IsDeleted(ctx context.Context, hashKey string) (bool, error) {
+	link, err := c.cachedLink(ctx, hashKey)
+	return link.Deleted, err
+}
+
+// Save - сохранение новой ссылки со сбросом ее записи кеша.
+func (c *CachedStorage) Save(ctx context.Context, value string, userUID string) (string, error) {
+	hashKey, err := c.PersistanceStorage.Save(ctx, value, userUID)
+	c.invalidate(hashKey)
+	return hashKey, err
+}
+
+// SaveLink - сохранение ссылки с признаками со сбросом записи кеша.
+func (c *CachedStorage) SaveLink(ctx context.Context, value string, alias string, userUID string, options LinkOptions) (string, error) {
+	hashKey, err := c.PersistanceStorage.SaveLink(ctx, value, alias, userUID, options)
+	c.invalidate(alias, hashKey)
+	return hashKey, err
+}
+
+// DeleteByUser - удаление ссылок со сбросом их записей кеша.
+func (c *CachedStorage) DeleteByUser(ctx context.Context, shortHashURL []string, userUID string) error {
+	err := c.PersistanceStorage.DeleteByUser(ctx, shortHashURL, userUID)
+	c.invalidate(shortHashURL...)
+	return err
+}
+
+// RestoreByUser - восстановление ссылок со сбросом их записей кеша.
+func (c *CachedStorage) RestoreByUser(ctx context.Context, shortHashURL []string, userUID string) error {
+	err := c.PersistanceStorage.RestoreByUser(ctx, shortHashURL, userUID)
+	c.invalidate(shortHashURL...)
+	return err
+}
+
+// UpdateByUser - изменение ссылки со сбросом ее записи кеша.
+func (c *CachedStorage) UpdateByUser(ctx context.Context, hashKey string, patch LinkPatch, userUID string) error {
+	err := c.PersistanceStorage.UpdateByUser(ctx, hashKey, patch, userUID)
+	c.invalidate(hashKey)
+	return err
+}
+
+// CorrelationSave - сохранение ссылки с идентификатором со сбросом записи кеша.
+func (c *CachedStorage) CorrelationSave(ctx context.Context, value string, correlationID string, userUID string) (string, error) {
+	hashKey, err := c.PersistanceStorage.CorrelationSave(ctx, value, correlationID, userUID)
+	c.invalidate(correlationID, hashKey)
+	return hashKey, err
+}
+
+// CorrelationsSave - сохранение ссылок с идентификаторами со сбросом их записей кеша.
+func (c *CachedStorage) CorrelationsSave(ctx context.Context, correlationURLs []CorrelationURL, userUID string) ([]string, error) {
+	hashKeys, err := c.PersistanceStorage.CorrelationsSave(ctx, correlationURLs, userUID)
+	for _, value := range correlationURLs {
+		c.invalidate(value.CorrelationID)
+	}
+	c.invalidate(hashKeys...)
+	return hashKeys, err
+}
+
+// LoadData - загрузка данных из файла, кеш сбрасывается целиком.
+func (c *CachedStorage) LoadData(ctx context.Context, pathToFile string) (int, error) {
+	count, err := c.PersistanceStorage.LoadData(ctx, pathToFile)
+	c.purge()
+	return count, err
+}
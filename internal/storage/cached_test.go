@@ -0,0 +1,170 @@
+// Модуль содержит тесты кеширующего декоратора хранилища
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCachedStorageGet - повторное чтение берется из кеша.
+func TestCachedStorageGet(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	cachedStorage := NewCachedStorage(inMemoryStorage, 10, time.Minute)
+	ctx := context.Background()
+
+	shortString, err := cachedStorage.Save(ctx, "https://yandex.ru/", "user")
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		result, found := cachedStorage.Get(ctx, shortString)
+		assert.True(t, found)
+		assert.Equal(t, "https://yandex.ru/", result)
+	}
+	_, found := cachedStorage.Get(ctx, "NotExist")
+	assert.False(t, found)
+
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 2, Size: 1}, cachedStorage.Stats())
+}
+
+// TestCachedStorageInvalidate - удаление через декоратор сбрасывает запись кеша.
+func TestCachedStorageInvalidate(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	cachedStorage := NewCachedStorage(inMemoryStorage, 10, time.Minute)
+	ctx := context.Background()
+
+	shortString, _ := cachedStorage.Save(ctx, "https://yandex.ru/", "user")
+	_, found := cachedStorage.Get(ctx, shortString)
+	assert.True(t, found)
+	deleted, err := cachedStorage.IsDeleted(ctx, shortString)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+
+	assert.NoError(t, cachedStorage.DeleteByUser(ctx, []string{shortString}, "user"))
+	assert.Equal(t, 0, cachedStorage.Stats().Size)
+	deleted, err = cachedStorage.IsDeleted(ctx, shortString)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	assert.NoError(t, cachedStorage.RestoreByUser(ctx, []string{shortString}, "user"))
+	deleted, err = cachedStorage.IsDeleted(ctx, shortString)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+// TestCachedStorageWholeLink - признаки ссылки читаются из одной закешированной ссылки.
+func TestCachedStorageWholeLink(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	cachedStorage := NewCachedStorage(inMemoryStorage, 10, time.Minute)
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(50 * time.Millisecond)
+	shortString, err := cachedStorage.SaveLink(ctx, "https://yandex.ru/", "", "user",
+		LinkOptions{ExpiresAt: expiresAt, Preview: true, PasswordHash: "hash", RedirectType: 301})
+	assert.NoError(t, err)
+
+	link, err := cachedStorage.GetLink(ctx, shortString)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", link.UserUID)
+	assert.Equal(t, "hash", link.PasswordHash)
+	assert.True(t, link.Preview)
+	assert.Equal(t, 301, link.RedirectType)
+	_, err = cachedStorage.GetLink(ctx, shortString)
+	assert.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Size: 1}, cachedStorage.Stats())
+
+	// Срок действия проверяется при каждом чтении из кеша
+	time.Sleep(60 * time.Millisecond)
+	link, err = cachedStorage.GetLink(ctx, shortString)
+	assert.NoError(t, err)
+	assert.True(t, link.Expired)
+
+	tags := []string{"news"}
+	assert.NoError(t, cachedStorage.UpdateByUser(ctx, shortString, LinkPatch{Tags: &tags}, "user"))
+	assert.Equal(t, 0, cachedStorage.Stats().Size)
+	link, err = cachedStorage.GetLink(ctx, shortString)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"news"}, link.Tags)
+
+	// Неизвестная ссылка не кешируется, признаки - нулевые значения
+	_, err = cachedStorage.GetLink(ctx, "NotExist")
+	assert.ErrorIs(t, err, ErrNotFound)
+	deleted, err := cachedStorage.IsDeleted(ctx, "NotExist")
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+// TestCachedStorageEviction - вытеснение давно использованных и просроченных записей.
+func TestCachedStorageEviction(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	cachedStorage := NewCachedStorage(inMemoryStorage, 2, time.Minute)
+	ctx := context.Background()
+
+	yandexHash, _ := cachedStorage.Save(ctx, "https://yandex.ru/", "user")
+	googleHash, _ := cachedStorage.Save(ctx, "https://google.ru/", "user")
+	mailHash, _ := cachedStorage.Save(ctx, "https://mail.ru/", "user")
+	cachedStorage.Get(ctx, yandexHash)
+	cachedStorage.Get(ctx, googleHash)
+	cachedStorage.Get(ctx, yandexHash) // google становится давно использованной
+	cachedStorage.Get(ctx, mailHash)
+	assert.Equal(t, 2, cachedStorage.Stats().Size)
+
+	misses := cachedStorage.Stats().Misses
+	cachedStorage.Get(ctx, yandexHash)
+	assert.Equal(t, misses, cachedStorage.Stats().Misses)
+	cachedStorage.Get(ctx, googleHash)
+	assert.Equal(t, misses+1, cachedStorage.Stats().Misses)
+
+	// Записи с истекшим временем жизни читаются из хранилища заново
+	shortLived := NewCachedStorage(inMemoryStorage, 2, time.Millisecond)
+	shortLived.Get(ctx, yandexHash)
+	time.Sleep(5 * time.Millisecond)
+	shortLived.Get(ctx, yandexHash)
+	assert.Equal(t, uint64(2), shortLived.Stats().Misses)
+}
+
+// blockingGetStorage - хранилище, чтение ссылки в котором ждет сигнала после обращения к данным.
+type blockingGetStorage struct {
+	*StorageInMemory
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingGetStorage) GetLink(ctx context.Context, hashKey string) (ShortHashURL, error) {
+	link, err := s.StorageInMemory.GetLink(ctx, hashKey)
+	s.read <- struct{}{}
+	<-s.release
+	return link, err
+}
+
+// TestCachedStorageStaleFill - значение, прочитанное до изменения ссылки, не попадает в кеш.
+func TestCachedStorageStaleFill(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	blocking := &blockingGetStorage{StorageInMemory: inMemoryStorage, read: make(chan struct{}), release: make(chan struct{})}
+	cachedStorage := NewCachedStorage(blocking, 10, time.Minute)
+	ctx := context.Background()
+
+	shortString, _ := inMemoryStorage.Save(ctx, "https://yandex.ru/", "user")
+
+	done := make(chan string)
+	go func() {
+		value, _ := cachedStorage.Get(ctx, shortString)
+		done <- value
+	}()
+	<-blocking.read
+	assert.NoError(t, cachedStorage.UpdateByUser(ctx, shortString, LinkPatch{OriginalURL: "https://google.ru/"}, "user"))
+	close(blocking.release)
+	assert.Equal(t, "https://yandex.ru/", <-done)
+
+	go func() { <-blocking.read }()
+	result, found := cachedStorage.Get(ctx, shortString)
+	assert.True(t, found)
+	assert.Equal(t, "https://google.ru/", result)
+}
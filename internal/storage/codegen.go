@@ -0,0 +1,179 @@
+// Модуль содержит стратегии генерации коротких кодов ссылок.
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync/atomic"
+)
+
+// Режимы генерации коротких кодов.
+const (
+	CodeGeneratorHash       = "hash"       // первые символы SHA-256 от ссылки
+	CodeGeneratorRandom     = "random"     // криптографически случайный base62
+	CodeGeneratorSequential = "sequential" // base62 от возрастающего счетчика
+)
+
+// maxGenerateAttempts - количество попыток подобрать свободный код при коллизиях.
+const maxGenerateAttempts = 10
+
+// base62Alphabet - алфавит кодов base62.
+const base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// errNoFreeCode - не удалось подобрать свободный короткий код.
+var errNoFreeCode = errors.New("failed to generate unique short code")
+
+// CodeGenerator - стратегия генерации короткого кода для ссылки.
+type CodeGenerator interface {
+	// Generate - возвращает код для ссылки, attempt - номер попытки после коллизии.
+	Generate(value string, attempt int) (string, error)
+}
+
+// codeSeeder - генератор, который должен продолжать выдачу после уже существующих в хранилище кодов.
+type codeSeeder interface {
+	Seed(code string)
+}
+
+// seedGenerator - передача существующего кода генератору, если ему это нужно.
+func seedGenerator(generator CodeGenerator, code string) {
+	if seeder, ok := generator.(codeSeeder); ok {
+		seeder.Seed(code)
+	}
+}
+
+// NewCodeGenerator - создает генератор по названию режима.
+func NewCodeGenerator(mode string, length int) (CodeGenerator, error) {
+	switch mode {
+	case "", CodeGeneratorHash:
+		return NewHashGenerator(length), nil
+	case CodeGeneratorRandom:
+		return NewRandomGenerator(length), nil
+	case CodeGeneratorSequential:
+		return NewSequentialGenerator(length, 0), nil
+	default:
+		return nil, fmt.Errorf("unknown code generator: %s", mode)
+	}
+}
+
+// HashFunc - хеш-функция ссылки, результат обрезается до длины кода.
+type HashFunc func(value string) string
+
+// HashGenerator - детерминированный код из хеша ссылки, совместим с прежними ссылками.
+type HashGenerator struct {
+	length int
+	hash   HashFunc
+}
+
+// NewHashGenerator - конструктор с хешем SHA-256.
+func NewHashGenerator(length int) *HashGenerator {
+	return NewHashGeneratorWithFunc(length, sha256Hex)
+}
+
+// NewHashGeneratorWithFunc - конструктор с заданной хеш-функцией.
+func NewHashGeneratorWithFunc(length int, hash HashFunc) *HashGenerator {
+	return &HashGenerator{length: length, hash: hash}
+}
+
+// Generate - реализация метода.
+// Первая попытка дает прежний код, после коллизии разных ссылок хешируется ссылка с солью из номера попытки.
+func (g *HashGenerator) Generate(value string, attempt int) (string, error) {
+	if attempt > 0 {
+		value = fmt.Sprintf("%s#%d", value, attempt)
+	}
+	return truncateHash(g.hash(value), g.length), nil
+}
+
+// RandomGenerator - случайный код base62 заданной длины.
+type RandomGenerator struct {
+	length int
+}
+
+// NewRandomGenerator - конструктор.
+func NewRandomGenerator(length int) *RandomGenerator {
+	return &RandomGenerator{length: length}
+}
+
+// Generate - реализация метода.
+func (g *RandomGenerator) Generate(value string, attempt int) (string, error) {
+	code := make([]byte, g.length)
+	alphabetSize := big.NewInt(int64(len(base62Alphabet)))
+	for i := range code {
+		num, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code[i] = base62Alphabet[num.Int64()]
+	}
+	return string(code), nil
+}
+
+// SequentialGenerator - короткие коды base62 от возрастающего счетчика, дополненные нулями до заданной длины.
+// Когда коды заданной длины заканчиваются, коды становятся длиннее.
+type SequentialGenerator struct {
+	length  int
+	counter atomic.Uint64
+}
+
+// NewSequentialGenerator - конструктор, start - последнее выданное значение счетчика.
+func NewSequentialGenerator(length int, start uint64) *SequentialGenerator {
+	g := &SequentialGenerator{length: length}
+	g.counter.Store(start)
+	return g
+}
+
+// Seed - сдвигает счетчик за существующий код, чтобы не выдавать его повторно.
+// Коды другой длины или не из алфавита base62 - псевдонимы, совпадение с ними разрешается повтором генерации.
+func (g *SequentialGenerator) Seed(code string) {
+	if len(code) != g.length {
+		return
+	}
+	number, ok := decodeBase62(code)
+	if !ok {
+		return
+	}
+	for {
+		current := g.counter.Load()
+		if number <= current || g.counter.CompareAndSwap(current, number) {
+			return
+		}
+	}
+}
+
+// Generate - реализация метода.
+func (g *SequentialGenerator) Generate(value string, attempt int) (string, error) {
+	code := encodeBase62(g.counter.Add(1))
+	if len(code) < g.length {
+		code = strings.Repeat(base62Alphabet[:1], g.length-len(code)) + code
+	}
+	return code, nil
+}
+
+// encodeBase62 - кодирование числа в base62.
+func encodeBase62(number uint64) string {
+	if number == 0 {
+		return base62Alphabet[:1]
+	}
+	var code []byte
+	for number > 0 {
+		code = append([]byte{base62Alphabet[number%62]}, code...)
+		number /= 62
+	}
+	return string(code)
+}
+
+// decodeBase62 - число из кода base62, false если код не из алфавита или не помещается в uint64.
+func decodeBase62(code string) (uint64, bool) {
+	var number uint64
+	for i := 0; i < len(code); i++ {
+		digit := strings.IndexByte(base62Alphabet, code[i])
+		if digit < 0 || number > (math.MaxUint64-uint64(digit))/62 {
+			return 0, false
+		}
+		number = number*62 + uint64(digit)
+	}
+	return number, true
+}
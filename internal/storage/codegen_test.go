@@ -0,0 +1,162 @@
+// Модуль содержит тесты стратегий генерации коротких кодов
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedGenerator - генератор, выдающий коды из заранее заданного списка.
+type fixedGenerator struct {
+	codes []string
+	calls int
+}
+
+// Generate - реализация метода.
+func (g *fixedGenerator) Generate(value string, attempt int) (string, error) {
+	code := g.codes[g.calls%len(g.codes)]
+	g.calls += 1
+	return code, nil
+}
+
+// TestNewCodeGenerator - тестирование выбора генератора по режиму.
+func TestNewCodeGenerator(t *testing.T) {
+	generator, err := NewCodeGenerator("", 10)
+	assert.NoError(t, err)
+	assert.IsType(t, &HashGenerator{}, generator)
+
+	generator, err = NewCodeGenerator(CodeGeneratorRandom, 10)
+	assert.NoError(t, err)
+	assert.IsType(t, &RandomGenerator{}, generator)
+
+	generator, err = NewCodeGenerator(CodeGeneratorSequential, 10)
+	assert.NoError(t, err)
+	assert.IsType(t, &SequentialGenerator{}, generator)
+
+	_, err = NewCodeGenerator("unknown", 10)
+	assert.Error(t, err)
+}
+
+// TestGenerators - тестирование кодов, выдаваемых генераторами.
+func TestGenerators(t *testing.T) {
+	code, _ := NewHashGenerator(10).Generate("https://yandex.ru/", 0)
+	assert.Equal(t, "77fca5950e", code)
+
+	code, _ = NewRandomGenerator(7).Generate("https://yandex.ru/", 0)
+	assert.Len(t, code, 7)
+	assert.Regexp(t, "^[0-9a-zA-Z]+$", code)
+
+	sequential := NewSequentialGenerator(3, 60)
+	code, _ = sequential.Generate("https://yandex.ru/", 0)
+	assert.Equal(t, "00Z", code)
+	code, _ = sequential.Generate("https://yandex.ru/", 0)
+	assert.Equal(t, "010", code)
+	// Счетчик сдвигается только вперед и только кодами заданной длины из base62
+	sequential.Seed("020")
+	sequential.Seed("00a")
+	sequential.Seed("zz")
+	sequential.Seed("promo")
+	sequential.Seed("0-0")
+	code, _ = sequential.Generate("https://yandex.ru/", 0)
+	assert.Equal(t, "021", code)
+	// Коды заданной длины закончились
+	sequential.Seed("ZZZ")
+	code, _ = sequential.Generate("https://yandex.ru/", 0)
+	assert.Equal(t, "1000", code)
+}
+
+// TestSequentialSeededFromStorage - последовательный генератор продолжает после наибольшего кода хранилища.
+func TestSequentialSeededFromStorage(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+	userUID := uuid.New().String()
+	_, err := inMemoryStorage.SaveLink(context.Background(), "https://yandex.ru/", "00000000a0", userUID, LinkOptions{})
+	assert.NoError(t, err)
+	_, err = inMemoryStorage.SaveLink(context.Background(), "https://google.ru/", "0000000005", userUID, LinkOptions{})
+	assert.NoError(t, err)
+
+	inMemoryStorage.SetCodeGenerator(NewSequentialGenerator(testLengthShortURL, 0))
+	shortString, err := inMemoryStorage.Save(context.Background(), "https://mail.ru/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "00000000a1", shortString)
+
+	// Коды, появившиеся после выбора генератора, тоже учитываются
+	_, err = inMemoryStorage.SaveLink(context.Background(), "https://ya.ru/", "00000000b0", userUID, LinkOptions{})
+	assert.NoError(t, err)
+	shortString, err = inMemoryStorage.Save(context.Background(), "https://vk.com/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "00000000b1", shortString)
+}
+
+// TestSaveRetryOnCollision - хранилище повторяет генерацию кода при коллизии.
+func TestSaveRetryOnCollision(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+	inMemoryStorage.SetCodeGenerator(&fixedGenerator{codes: []string{"a", "a", "b"}})
+
+	userUID := uuid.New().String()
+	shortString, err := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", shortString)
+
+	shortString, err = inMemoryStorage.Save(context.Background(), "https://google.ru/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", shortString)
+
+	// Повторное сокращение той же ссылки не зависит от генератора
+	shortString, err = inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+	var ue *UniqURLError
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, "a", shortString)
+
+	// Генератор без свободных кодов
+	inMemoryStorage.SetCodeGenerator(&fixedGenerator{codes: []string{"a", "b"}})
+	_, err = inMemoryStorage.Save(context.Background(), "https://mail.ru/", userUID)
+	var se *StorageError
+	assert.ErrorAs(t, err, &se)
+}
+
+// collidingHash - хеш-функция, дающая одинаковый код для двух заданных ссылок.
+func collidingHash(value string) string {
+	if value == "https://yandex.ru/" || value == "https://google.ru/" {
+		return "c0111510ff" + sha256Hex(value)
+	}
+	return sha256Hex(value)
+}
+
+// TestHashCollision - разные ссылки с одинаковым усеченным хешем получают разные коды.
+func TestHashCollision(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+	inMemoryStorage.SetCodeGenerator(NewHashGeneratorWithFunc(testLengthShortURL, collidingHash))
+
+	userUID := uuid.New().String()
+	firstHash, err := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "c0111510ff", firstHash)
+
+	// Коллизия разрешается повторным хешированием с солью
+	secondHash, err := inMemoryStorage.Save(context.Background(), "https://google.ru/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, truncateHash(sha256Hex("https://google.ru/#1"), testLengthShortURL), secondHash)
+
+	result, _ := inMemoryStorage.Get(context.Background(), firstHash)
+	assert.Equal(t, "https://yandex.ru/", result)
+	result, _ = inMemoryStorage.Get(context.Background(), secondHash)
+	assert.Equal(t, "https://google.ru/", result)
+
+	// Повторное сокращение указывает на собственный код ссылки
+	for value, hashKey := range map[string]string{"https://yandex.ru/": firstHash, "https://google.ru/": secondHash} {
+		_, err = inMemoryStorage.Save(context.Background(), value, userUID)
+		var ue *UniqURLError
+		assert.ErrorAs(t, err, &ue)
+		assert.Equal(t, hashKey, ue.ShortHash)
+		assert.Equal(t, value, ue.ExistURL)
+	}
+}
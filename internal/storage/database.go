@@ -5,7 +5,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgerrcode"
@@ -20,18 +24,60 @@ type DBPool interface {
 	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Begin(ctx context.Context) (pgx.Tx, error)
 	Close()
 }
 
+// urlTagsColumn - теги ссылки владельца массивом в порядке сортировки.
+const urlTagsColumn = `COALESCE((
+	SELECT array_agg(t.name ORDER BY t.name) FROM url_tags ut JOIN tags t ON t.id = ut.tag_id
+	WHERE ut.short = urls.short AND t.user_uid = urls.user_uid), '{}')`
+
+// urlHostColumn - хост оригинальной ссылки в нижнем регистре без завершающей точки.
+const urlHostColumn = `rtrim(lower(substring(original from '^[^:/?#]+://(?:[^@/?#]*@)?([^:/?#]+)')), '.')`
+
+// urlQueryRulesColumn - правила параметров запроса ссылки в JSON, пусто - общие правила.
+const urlQueryRulesColumn = `COALESCE(query_rules::text, '')`
+
+// urlsShortIndex - уникальный индекс кодов ссылок, его нарушение означает занятый код.
+const urlsShortIndex = "idx_urls_short_unique"
+
+// insertLinkSQL - вставка ссылки вместе с признаками, если код еще свободен.
+const insertLinkSQL = `
+	INSERT INTO urls (uuid, short, original, user_uid, expires_at, preview, password_hash, redirect_type, query_rules,
+		correlation_id, notes)
+	SELECT $1::uuid, $2::varchar, $3::text, $4::varchar, $5::timestamptz, $6::boolean, $7::text, $8::smallint,
+		NULLIF($9::text, '')::jsonb, NULLIF($10::text, ''), $11::text
+	WHERE NOT EXISTS (SELECT 1 FROM urls WHERE short = $2)
+`
+
+// linkTagsSQL - создание тегов пользователя $2 с именами $3 и привязка их к ссылке $1.
+const linkTagsSQL = `
+	WITH tag_ids AS (
+		INSERT INTO tags (user_uid, name) SELECT $2, unnest($3::text[])
+		ON CONFLICT (user_uid, name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id
+	), linked AS (
+		INSERT INTO url_tags (short, tag_id) SELECT $1, id FROM tag_ids
+		ON CONFLICT DO NOTHING
+		RETURNING tag_id
+	)
+	SELECT count(*) FROM linked`
+
+// linkCountSQL - количество ссылок пользователя $1 всего и созданных начиная с $2.
+const linkCountSQL = `SELECT count(*), count(*) FILTER (WHERE created_at >= $2) FROM urls WHERE user_uid = $1`
+
+// lockUserLinksSQL - блокировка создания ссылок пользователя $1 до конца транзакции.
+const lockUserLinksSQL = `SELECT pg_advisory_xact_lock(hashtext($1))`
+
 // StorageInMemory - хранилище в базе данных Postgres
 type StorageInPostgres struct {
-	connectionToDB     *pgx.Conn
-	poolConnectionToDB DBPool // Используем пул соединений *pgxpool.Pool
+	poolConnectionToDB DBPool // Используем пул соединений *pgxpool.Pool, одиночное соединение небезопасно при параллельных запросах
 	lengthShortURL     int
+	generator          CodeGenerator
+	limits             LinkLimits
 }
 
-var cache map[string]bool = make(map[string]bool)
-
 func initDB(config *pgx.ConnConfig) bool {
 	// Подключение к стандартной БД
 	connString := fmt.Sprintf("postgres://%s:%s@%s:%d/%s",
@@ -67,25 +113,9 @@ func initDB(config *pgx.ConnConfig) bool {
 	}
 	defer urlserviceDB.Close(context.Background())
 
-	// Создание таблицы "urls", если ее нет
-	query := `
-	CREATE TABLE IF NOT EXISTS urls (
-		uuid UUID PRIMARY KEY,
-		correlation_id TEXT NULL,
-		short VARCHAR(255) NOT NULL,
-		original TEXT NOT NULL UNIQUE,
-		user_uid VARCHAR(1024) NULL,
-		deleted BOOLEAN DEFAULT false     
-	)`
-	// ; CREATE INDEX idx_user_uid ON urls (user_uid);
-	// Тесты 11 проваливаются из за создани индекса
-	// ;
-	//-- Добавляем индекс на поле short
-	//CREATE INDEX idx_short ON urls (short);
-
-	_, err = urlserviceDB.Exec(context.Background(), query)
-	if err != nil {
-		log.Printf("Failed to create table: %v\n", err)
+	// Применение недостающих миграций схемы
+	if _, err := Migrate(context.Background(), urlserviceDB); err != nil {
+		log.Printf("Failed to migrate database: %v\n", err)
 		return false
 	}
 	return true
@@ -94,7 +124,8 @@ func initDB(config *pgx.ConnConfig) bool {
 // NewStorageInPostgres - конструктор
 func NewStorageInPostgres(connectionString string, lengthShortURL int) (*StorageInPostgres, error) {
 
-	newStorage := StorageInPostgres{connectionToDB: nil, lengthShortURL: lengthShortURL}
+	newStorage := StorageInPostgres{lengthShortURL: lengthShortURL,
+		generator: NewHashGenerator(lengthShortURL)}
 
 	config, err := pgx.ParseConfig(connectionString)
 	if err != nil {
@@ -103,16 +134,14 @@ func NewStorageInPostgres(connectionString string, lengthShortURL int) (*Storage
 	}
 
 	if initDB(config) {
-		connectionToDB, err := pgx.Connect(context.Background(), connectionString)
 		poolConfig, _ := pgxpool.ParseConfig(connectionString)
 		poolConfig.MaxConns = 100
 		poolConfig.MinConns = 50
-		poolConnectionToDB, err1 := pgxpool.NewWithConfig(context.Background(), poolConfig)
+		poolConnectionToDB, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 
-		if err != nil || err1 != nil {
+		if err != nil {
 			return &newStorage, errors.New("failed to connect to database")
 		}
-		newStorage.connectionToDB = connectionToDB
 		newStorage.poolConnectionToDB = poolConnectionToDB
 		return &newStorage, nil
 	} else {
@@ -121,12 +150,12 @@ func NewStorageInPostgres(connectionString string, lengthShortURL int) (*Storage
 }
 
 // Get - чтение ссылки.
-func (s *StorageInPostgres) Get(hashKey string) (string, bool) {
+func (s *StorageInPostgres) Get(ctx context.Context, hashKey string) (string, bool) {
 	var originalURL string
 
 	query := "SELECT original FROM urls WHERE short = $1"
 
-	err := s.poolConnectionToDB.QueryRow(context.Background(), query, hashKey).Scan(&originalURL)
+	err := s.poolConnectionToDB.QueryRow(ctx, query, hashKey).Scan(&originalURL)
 	if err != nil {
 		log.Printf("Failed to find original URL: %v\n", err)
 		return originalURL, false
@@ -134,46 +163,274 @@ func (s *StorageInPostgres) Get(hashKey string) (string, bool) {
 	return originalURL, true
 }
 
+// GetLink - чтение ссылки со всеми полями.
+func (s *StorageInPostgres) GetLink(ctx context.Context, hashKey string) (ShortHashURL, error) {
+	item := ShortHashURL{ShortHash: hashKey}
+	var expiresAt *time.Time
+	var queryRules string
+	query := `
+		SELECT original, COALESCE(user_uid, ''), password_hash, created_at, expires_at,
+			COALESCE(expired OR expires_at <= now(), false), COALESCE(deleted, false), ` + urlTagsColumn + `, preview,
+			redirect_type, ` + urlQueryRulesColumn + `, notes
+		FROM urls WHERE short = $1
+	`
+	err := s.poolConnectionToDB.QueryRow(ctx, query, hashKey).Scan(&item.OriginalURL, &item.UserUID, &item.PasswordHash,
+		&item.CreatedAt, &expiresAt, &item.Expired, &item.Deleted, &item.Tags, &item.Preview, &item.RedirectType, &queryRules,
+		&item.Notes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return item, NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrNotFound))
+	}
+	if err != nil {
+		return item, NewStorageError(err)
+	}
+	if expiresAt != nil {
+		item.ExpiresAt = *expiresAt
+	}
+	if item.QueryRules, err = decodeQueryRules(queryRules); err != nil {
+		return item, NewStorageError(err)
+	}
+	return item, nil
+}
+
 // IsDeleted - удалена ли ссылка.
-func (s *StorageInPostgres) IsDeleted(hashKey string) (bool, error) {
-	_, exists := cache[hashKey]
-	return exists, nil
+func (s *StorageInPostgres) IsDeleted(ctx context.Context, hashKey string) (bool, error) {
+	var deleted bool
+	query := "SELECT COALESCE(deleted, false) FROM urls WHERE short = $1"
+	err := s.poolConnectionToDB.QueryRow(ctx, query, hashKey).Scan(&deleted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, NewStorageError(err)
+	}
+	return deleted, nil
 }
 
-// Save - сохранение новой ссылки.
-func (s *StorageInPostgres) Save(value string, userUID string) (string, error) {
-	newUUID := uuid.New()
-	hashKey := makeHash(value, s.lengthShortURL)
-	// SQL-запрос на вставку новой записи
-	query := `
-		INSERT INTO urls (uuid, short, original, user_uid)
-		VALUES ($1, $2, $3, $4)
-	`
-	_, err := s.poolConnectionToDB.Exec(context.Background(), query, newUUID, hashKey, value, userUID)
+// MarkExpired - помечает просроченными все ссылки со сроком действия до now.
+func (s *StorageInPostgres) MarkExpired(ctx context.Context, now time.Time) (int, error) {
+	query := "UPDATE urls SET expired = true WHERE expires_at <= $1 AND NOT expired"
+	result, err := s.poolConnectionToDB.Exec(ctx, query, now)
+	if err != nil {
+		log.Printf("Failed to mark expired urls: %v\n", err)
+		return 0, NewStorageError(err)
+	}
+	return int(result.RowsAffected()), nil
+}
 
+// SetCodeGenerator - замена стратегии генерации коротких кодов.
+func (s *StorageInPostgres) SetCodeGenerator(generator CodeGenerator) {
+	s.generator = generator
+}
+
+// SetLinkLimits - ограничения количества ссылок пользователя для новых ссылок.
+func (s *StorageInPostgres) SetLinkLimits(limits LinkLimits) {
+	s.limits = limits
+}
+
+// CountByUser - количество ссылок пользователя всего и за текущие сутки.
+func (s *StorageInPostgres) CountByUser(ctx context.Context, userUID string) (LinkCount, error) {
+	var count LinkCount
+	err := s.poolConnectionToDB.QueryRow(ctx, linkCountSQL, userUID, DayStart(time.Now())).Scan(&count.Total, &count.Daily)
 	if err != nil {
-		// Проверка на ошибку типа UniqueViolation
-		var pge *pgconn.PgError
-		if errors.As(err, &pge) {
-			if pge.Code == pgerrcode.UniqueViolation {
+		return LinkCount{}, NewStorageError(err)
+	}
+	return count, nil
+}
+
+// checkLimitsTx - проверка ограничений перед созданием requested ссылок пользователя в транзакции.
+// Блокировка пользователя держится до конца транзакции, параллельные вставки не превышают ограничения.
+func (s *StorageInPostgres) checkLimitsTx(ctx context.Context, tx pgx.Tx, userUID string, requested int) error {
+	if !s.limits.enabled() {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, lockUserLinksSQL, userUID); err != nil {
+		return err
+	}
+	var count LinkCount
+	if err := tx.QueryRow(ctx, linkCountSQL, userUID, DayStart(time.Now())).Scan(&count.Total, &count.Daily); err != nil {
+		return err
+	}
+	return s.limits.check(count, requested)
+}
+
+// SeedCodeGenerator - продолжение выдачи кодов после уже сохраненных в БД, нужно последовательному генератору.
+func (s *StorageInPostgres) SeedCodeGenerator(ctx context.Context) error {
+	if _, ok := s.generator.(codeSeeder); !ok {
+		return nil
+	}
+	rows, err := s.poolConnectionToDB.Query(ctx, "SELECT short FROM urls")
+	if err != nil {
+		return NewStorageError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hashKey string
+		if err := rows.Scan(&hashKey); err != nil {
+			return NewStorageError(err)
+		}
+		seedGenerator(s.generator, hashKey)
+	}
+	if rows.Err() != nil {
+		return NewStorageError(rows.Err())
+	}
+	return nil
+}
+
+// Save - сохранение новой ссылки.
+func (s *StorageInPostgres) Save(ctx context.Context, value string, userUID string) (string, error) {
+	return s.SaveLink(ctx, value, "", userUID, LinkOptions{})
+}
+
+// SaveLink - сохранение новой ссылки вместе с признаками.
+// Ссылка без тегов и ограничений количества сохраняется одной вставкой, иначе - в транзакции.
+// Занятость псевдонима обеспечивает уникальный индекс колонки short.
+func (s *StorageInPostgres) SaveLink(ctx context.Context, value string, alias string, userUID string, options LinkOptions) (string, error) {
+	if alias != "" {
+		inserted, err := s.insertLink(ctx, alias, "", value, userUID, options)
+		if err != nil {
+			return s.insertError(ctx, err, value, alias, userUID)
+		}
+		if !inserted {
+			return alias, s.aliasConflict(ctx, value, alias, userUID)
+		}
+		return alias, nil
+	}
+	previousKey := ""
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		hashKey, err := s.generator.Generate(value, attempt)
+		if err != nil {
+			return "", NewStorageError(err)
+		}
+		inserted, err := s.insertLink(ctx, hashKey, "", value, userUID, options)
+
+		if err != nil {
+			// Проверка на ошибку типа UniqueViolation
+			var pge *pgconn.PgError
+			if !errors.As(err, &pge) || pge.Code != pgerrcode.UniqueViolation {
+				log.Printf("Failed to insert new record: %v\n", err)
+				return hashKey, err
+			}
+			if pge.ConstraintName != urlsShortIndex {
 				log.Printf("Error: A url with the same value already exists. URL: %s, hash: %s", value, hashKey)
-				return hashKey, NewUniqURLError(value, hashKey)
+				return s.existingShort(ctx, value, hashKey)
 			}
+			// Код заняли параллельной вставкой, дальше он проверяется как занятый
+		} else if inserted {
+			return hashKey, nil
+		}
+		// Код занят: та же ссылка или коллизия разных ссылок
+		var existURL string
+		err = s.poolConnectionToDB.QueryRow(ctx, "SELECT original FROM urls WHERE short = $1", hashKey).Scan(&existURL)
+		if err == nil && existURL == value {
+			return hashKey, NewUniqURLError(value, hashKey)
+		}
+		// Детерминированный генератор вернул тот же код, повторять бессмысленно
+		if hashKey == previousKey {
+			break
+		}
+		previousKey = hashKey
+	}
+	return "", NewStorageError(errNoFreeCode)
+}
+
+// insertLink - вставка ссылки с признаками, false - код уже занят.
+func (s *StorageInPostgres) insertLink(ctx context.Context, hashKey string, correlationID string, value string, userUID string,
+	options LinkOptions) (bool, error) {
+	if len(options.Tags) == 0 && !s.limits.enabled() {
+		result, err := s.poolConnectionToDB.Exec(ctx, insertLinkSQL, linkArgs(hashKey, correlationID, value, userUID, options)...)
+		if err != nil {
+			return false, err
+		}
+		return result.RowsAffected() > 0, nil
+	}
+	tx, err := s.poolConnectionToDB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := s.checkLimitsTx(ctx, tx, userUID, 1); err != nil {
+		tx.Rollback(ctx)
+		return false, err
+	}
+	inserted, err := insertLinkTx(ctx, tx, hashKey, correlationID, value, userUID, options)
+	if err != nil || !inserted {
+		tx.Rollback(ctx)
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// insertLinkTx - вставка ссылки и ее тегов в рамках транзакции, false - код уже занят.
+func insertLinkTx(ctx context.Context, tx pgx.Tx, hashKey string, correlationID string, value string, userUID string,
+	options LinkOptions) (bool, error) {
+	result, err := tx.Exec(ctx, insertLinkSQL, linkArgs(hashKey, correlationID, value, userUID, options)...)
+	if err != nil || result.RowsAffected() == 0 {
+		return false, err
+	}
+	if len(options.Tags) > 0 {
+		if _, err := tx.Exec(ctx, linkTagsSQL, hashKey, userUID, options.Tags); err != nil {
+			return false, err
 		}
+	}
+	return true, nil
+}
+
+// linkArgs - аргументы запроса insertLinkSQL.
+func linkArgs(hashKey string, correlationID string, value string, userUID string, options LinkOptions) []interface{} {
+	var expiresAt *time.Time
+	if !options.ExpiresAt.IsZero() {
+		expiresAt = &options.ExpiresAt
+	}
+	return []interface{}{uuid.New(), hashKey, value, userUID, expiresAt, options.Preview, options.PasswordHash,
+		options.RedirectType, encodeQueryRules(options.QueryRules), correlationID, options.Notes}
+}
+
+// existingShort - код уже сокращенной ссылки для ответа о конфликте.
+func (s *StorageInPostgres) existingShort(ctx context.Context, value string, fallback string) (string, error) {
+	existShort := fallback
+	query := "SELECT short FROM urls WHERE original = $1"
+	if err := s.poolConnectionToDB.QueryRow(ctx, query, value).Scan(&existShort); err != nil {
+		log.Printf("Failed to find existing short URL: %v\n", err)
+	}
+	return existShort, NewUniqURLError(value, existShort)
+}
+
+// insertError - ошибка вставки ссылки под заданным кодом: код занят или ссылка уже сокращена.
+func (s *StorageInPostgres) insertError(ctx context.Context, err error, value string, hashKey string, userUID string) (string, error) {
+	var pge *pgconn.PgError
+	if !errors.As(err, &pge) || pge.Code != pgerrcode.UniqueViolation {
 		log.Printf("Failed to insert new record: %v\n", err)
 		return hashKey, err
 	}
-	return hashKey, nil
+	if pge.ConstraintName != urlsShortIndex {
+		// Ссылка уже сокращена ранее, возвращаем существующий хеш
+		return s.existingShort(ctx, value, hashKey)
+	}
+	return hashKey, s.aliasConflict(ctx, value, hashKey, userUID)
+}
+
+// aliasConflict - ошибка для занятого псевдонима.
+func (s *StorageInPostgres) aliasConflict(ctx context.Context, value string, alias string, userUID string) error {
+	var existURL, existUserUID string
+	query := "SELECT original, COALESCE(user_uid, '') FROM urls WHERE short = $1"
+	err := s.poolConnectionToDB.QueryRow(ctx, query, alias).Scan(&existURL, &existUserUID)
+	// Повторная отправка той же ссылки тем же пользователем - обычный конфликт
+	if err == nil && existURL == value && existUserUID == userUID {
+		return NewUniqURLError(value, alias)
+	}
+	return NewAliasTakenError(alias)
 }
 
 // FindByUserUID - поиск ссылок по пользовательскому UID.
-func (s *StorageInPostgres) FindByUserUID(userUID string) ([]ShortHashURL, error) {
+func (s *StorageInPostgres) FindByUserUID(ctx context.Context, userUID string) ([]ShortHashURL, error) {
 	var output []ShortHashURL
 	// SQL-запрос на поиск URLs
 	query := `
-		SELECT short, original FROM urls WHERE user_uid = $1
+		SELECT short, original, created_at, expires_at, COALESCE(expired OR expires_at <= now(), false),
+			COALESCE(deleted, false), ` + urlTagsColumn + `, preview, redirect_type,
+			` + urlQueryRulesColumn + `, notes
+		FROM urls WHERE user_uid = $1
 	`
-	urls, err := s.connectionToDB.Query(context.Background(), query, userUID)
+	urls, err := s.poolConnectionToDB.Query(ctx, query, userUID)
 
 	if err != nil {
 		log.Printf("Failed to find original URL: %v\n", err)
@@ -184,20 +441,44 @@ func (s *StorageInPostgres) FindByUserUID(userUID string) ([]ShortHashURL, error
 
 	// Итерируем по строкам результата
 	for urls.Next() {
-		var shortURL, originalURL string
+		var shortURL, originalURL, notes string
+		var createdAt time.Time
+		var expiresAt *time.Time
+		var expired, deleted, preview bool
+		var redirectType int
+		var tags []string
+		var queryRules string
 
 		// Чтение данных в переменные
-		err = urls.Scan(&shortURL, &originalURL)
+		err = urls.Scan(&shortURL, &originalURL, &createdAt, &expiresAt, &expired, &deleted, &tags, &preview, &redirectType,
+			&queryRules, &notes)
 		if err != nil {
 			log.Printf("failed to scan row: %s", err)
 			return output, err
 		}
+		rules, err := decodeQueryRules(queryRules)
+		if err != nil {
+			return output, NewStorageError(err)
+		}
 
 		// Добавление URL в массив
-		output = append(output, ShortHashURL{
-			ShortHash:   shortURL,
-			OriginalURL: originalURL,
-		})
+		item := ShortHashURL{
+			ShortHash:    shortURL,
+			OriginalURL:  originalURL,
+			UserUID:      userUID,
+			CreatedAt:    createdAt,
+			Expired:      expired,
+			Deleted:      deleted,
+			Tags:         tags,
+			Preview:      preview,
+			RedirectType: redirectType,
+			QueryRules:   rules,
+			Notes:        notes,
+		}
+		if expiresAt != nil {
+			item.ExpiresAt = *expiresAt
+		}
+		output = append(output, item)
 	}
 
 	if urls.Err() != nil {
@@ -207,75 +488,483 @@ func (s *StorageInPostgres) FindByUserUID(userUID string) ([]ShortHashURL, error
 	return output, nil
 }
 
+// QueryByUser - страница ссылок пользователя, фильтры, сортировка и курсор переносятся в SQL.
+// Выбирается на одну ссылку больше страницы, ее наличие означает следующую страницу.
+func (s *StorageInPostgres) QueryByUser(ctx context.Context, userUID string, query URLQuery) (URLPage, error) {
+	query, cursor, err := prepareURLQuery(query)
+	if err != nil {
+		return URLPage{}, err
+	}
+	args := []interface{}{userUID}
+	conditions := []string{"user_uid = $1"}
+	if query.Deleted != nil {
+		args = append(args, *query.Deleted)
+		conditions = append(conditions, fmt.Sprintf("COALESCE(deleted, false) = $%d", len(args)))
+	}
+	if query.Expired != nil {
+		args = append(args, *query.Expired)
+		conditions = append(conditions, fmt.Sprintf("COALESCE(expired OR expires_at <= now(), false) = $%d", len(args)))
+	}
+	if query.Domain != "" {
+		args = append(args, query.Domain, "%."+escapeLike(query.Domain))
+		conditions = append(conditions, fmt.Sprintf("(%s = $%d OR %s LIKE $%d)", urlHostColumn, len(args)-1, urlHostColumn, len(args)))
+	}
+	if len(query.Tags) > 0 {
+		args = append(args, query.Tags)
+		conditions = append(conditions, fmt.Sprintf("%s @> $%d::text[]", urlTagsColumn, len(args)))
+	}
+
+	// Порядок строк совпадает с побайтовым сравнением строк в QueryURLs
+	sortColumn, order, compare := "created_at", "", ">"
+	if query.SortBy == SortByOriginal {
+		sortColumn = `original COLLATE "C"`
+	}
+	if query.Desc {
+		order, compare = " DESC", "<"
+	}
+	if cursor != nil {
+		if query.SortBy == SortByOriginal {
+			args = append(args, cursor.Original)
+		} else {
+			args = append(args, cursor.CreatedAt)
+		}
+		args = append(args, cursor.ShortHash)
+		conditions = append(conditions, fmt.Sprintf(`(%s, short COLLATE "C") %s ($%d, $%d)`, sortColumn, compare, len(args)-1, len(args)))
+	}
+	// LIMIT NULL - без ограничения
+	var limitArg interface{}
+	if query.Limit > 0 {
+		limitArg = query.Limit + 1
+	}
+	args = append(args, limitArg)
+	sql := fmt.Sprintf(`
+		SELECT short, original, created_at, expires_at, COALESCE(expired OR expires_at <= now(), false),
+			COALESCE(deleted, false), %s, preview, redirect_type, %s, notes
+		FROM urls WHERE %s
+		ORDER BY %s%s, short COLLATE "C"%s LIMIT $%d
+	`, urlTagsColumn, urlQueryRulesColumn, strings.Join(conditions, " AND "), sortColumn, order, order, len(args))
+
+	rows, err := s.poolConnectionToDB.Query(ctx, sql, args...)
+	if err != nil {
+		log.Printf("Failed to query URLs: %v\n", err)
+		return URLPage{}, NewStorageError(err)
+	}
+	defer rows.Close()
+	var selected []ShortHashURL
+	for rows.Next() {
+		item := ShortHashURL{UserUID: userUID}
+		var expiresAt *time.Time
+		var queryRules string
+		if err := rows.Scan(&item.ShortHash, &item.OriginalURL, &item.CreatedAt, &expiresAt, &item.Expired, &item.Deleted, &item.Tags,
+			&item.Preview, &item.RedirectType, &queryRules, &item.Notes); err != nil {
+			return URLPage{}, NewStorageError(err)
+		}
+		if item.QueryRules, err = decodeQueryRules(queryRules); err != nil {
+			return URLPage{}, NewStorageError(err)
+		}
+		if expiresAt != nil {
+			item.ExpiresAt = *expiresAt
+		}
+		selected = append(selected, item)
+	}
+	if rows.Err() != nil {
+		return URLPage{}, NewStorageError(rows.Err())
+	}
+	return pageURLs(selected, query), nil
+}
+
+// SearchByUser - поиск ссылок пользователя, в оригинальную ссылку, код или заметку которых входит каждое слово запроса.
+// Условия ILIKE используют триграммные индексы из миграции.
+func (s *StorageInPostgres) SearchByUser(ctx context.Context, userUID string, query string, limit int) ([]ShortHashURL, error) {
+	var output []ShortHashURL
+	args := []interface{}{userUID}
+	conditions := []string{"user_uid = $1", "NOT COALESCE(deleted, false)"}
+	for _, word := range searchWords(query) {
+		args = append(args, "%"+escapeLike(word)+"%")
+		conditions = append(conditions, fmt.Sprintf("(original ILIKE $%d OR short ILIKE $%d OR notes ILIKE $%d)", len(args), len(args), len(args)))
+	}
+	// LIMIT NULL - без ограничения
+	var limitArg interface{}
+	if limit > 0 {
+		limitArg = limit
+	}
+	args = append(args, limitArg)
+	sql := fmt.Sprintf(`
+		SELECT short, original, created_at, expires_at, COALESCE(expired OR expires_at <= now(), false),
+			%s, preview, redirect_type, %s, notes
+		FROM urls WHERE %s
+		ORDER BY created_at DESC, short LIMIT $%d
+	`, urlTagsColumn, urlQueryRulesColumn, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := s.poolConnectionToDB.Query(ctx, sql, args...)
+	if err != nil {
+		log.Printf("Failed to search URLs: %v\n", err)
+		return nil, NewStorageError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item := ShortHashURL{UserUID: userUID}
+		var expiresAt *time.Time
+		var queryRules string
+		if err := rows.Scan(&item.ShortHash, &item.OriginalURL, &item.CreatedAt, &expiresAt, &item.Expired, &item.Tags, &item.Preview,
+			&item.RedirectType, &queryRules, &item.Notes); err != nil {
+			return nil, NewStorageError(err)
+		}
+		var err error
+		if item.QueryRules, err = decodeQueryRules(queryRules); err != nil {
+			return nil, NewStorageError(err)
+		}
+		if expiresAt != nil {
+			item.ExpiresAt = *expiresAt
+		}
+		output = append(output, item)
+	}
+	if rows.Err() != nil {
+		return nil, NewStorageError(rows.Err())
+	}
+	return output, nil
+}
+
 // DeleteByUser - удалить ссылку по пользовательскому UUID
-func (s *StorageInPostgres) DeleteByUser(shortsHashURL []string, userUID string) error {
+func (s *StorageInPostgres) DeleteByUser(ctx context.Context, shortsHashURL []string, userUID string) error {
+	return s.setDeleted(ctx, shortsHashURL, userUID, true)
+}
 
-	// В кеш
-	for _, v := range shortsHashURL { // записываем удаляемый батч в кеш, для запроса GET /{id}, чтобы не обращатся к БД
-		cache[v] = true
+// RestoreByUser - восстановить удаленные ссылки по пользовательскому UUID
+func (s *StorageInPostgres) RestoreByUser(ctx context.Context, shortsHashURL []string, userUID string) error {
+	return s.setDeleted(ctx, shortsHashURL, userUID, false)
+}
+
+// updateLinkSQL - изменение ссылки, NULL в параметре оставляет прежнее значение.
+const updateLinkSQL = `
+	UPDATE urls SET
+		original = COALESCE($2, original),
+		expires_at = COALESCE($3, expires_at),
+		expired = CASE WHEN $3::timestamptz IS NULL THEN expired ELSE false END,
+		preview = COALESCE($4, preview),
+		redirect_type = COALESCE($5, redirect_type),
+		query_rules = CASE WHEN $6 THEN NULLIF($7, '')::jsonb ELSE query_rules END,
+		notes = COALESCE($8, notes)
+	WHERE short = $1`
+
+// UpdateByUser - изменение ссылки владельцем в одной транзакции, уникальность ссылок обеспечивает ограничение UNIQUE колонки original.
+// Удаленная ссылка не меняется.
+func (s *StorageInPostgres) UpdateByUser(ctx context.Context, hashKey string, patch LinkPatch, userUID string) error {
+	tx, err := s.poolConnectionToDB.Begin(ctx)
+	if err != nil {
+		log.Printf("Failed to begin transaction: %v\n", err)
+		return NewStorageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var deleted bool
+	query := "SELECT COALESCE(deleted, false) FROM urls WHERE short = $1 AND user_uid = $2 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, hashKey, userUID).Scan(&deleted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrNotFound))
+	}
+	if err != nil {
+		return NewStorageError(err)
+	}
+	if deleted {
+		return NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrDeleted))
+	}
+
+	var original, expiresAt interface{}
+	if patch.OriginalURL != "" {
+		original = patch.OriginalURL
+	}
+	if !patch.ExpiresAt.IsZero() {
+		expiresAt = patch.ExpiresAt
+	}
+	_, err = tx.Exec(ctx, updateLinkSQL, hashKey, original, expiresAt, patch.Preview, patch.RedirectType,
+		patch.QueryRules != nil, encodeQueryRules(patch.linkQueryRules()), patch.Notes)
+	if err != nil {
+		var pge *pgconn.PgError
+		if errors.As(err, &pge) && pge.Code == pgerrcode.UniqueViolation {
+			// Новая ссылка уже сокращена под другим кодом
+			_, err = s.existingShort(ctx, patch.OriginalURL, "")
+			return err
+		}
+		log.Printf("Failed to update link: %v\n", err)
+		return NewStorageError(err)
+	}
+	if patch.Tags != nil {
+		if _, err := tx.Exec(ctx, "DELETE FROM url_tags WHERE short = $1", hashKey); err != nil {
+			return NewStorageError(err)
+		}
+		if len(*patch.Tags) > 0 {
+			if _, err := tx.Exec(ctx, linkTagsSQL, hashKey, userUID, *patch.Tags); err != nil {
+				return NewStorageError(err)
+			}
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return NewStorageError(err)
 	}
+	return nil
+}
+
+// setDeleted - установка признака удаления ссылок владельца одним пакетом.
+func (s *StorageInPostgres) setDeleted(ctx context.Context, shortsHashURL []string, userUID string, deleted bool) error {
 
 	// Создаем объект Batch
 	batch := &pgx.Batch{}
 
 	for _, shortHashURL := range shortsHashURL { // short - короткая ссылка
-		batch.Queue("UPDATE urls SET deleted = true WHERE short = $1 and user_uid = $2", shortHashURL, userUID)
+		batch.Queue("UPDATE urls SET deleted = $1 WHERE short = $2 and user_uid = $3", deleted, shortHashURL, userUID)
 	}
 
-	batchResults := s.poolConnectionToDB.SendBatch(context.Background(), batch)
+	batchResults := s.poolConnectionToDB.SendBatch(ctx, batch)
 	defer batchResults.Close() // Закрываем BatchResults после использования
 
 	// Обработка каждой команды в батче
-	for {
+	for i := 0; i < batch.Len(); i++ {
 		_, err := batchResults.Exec()
 		if err != nil {
 			log.Printf("Error executing batch command: %v", err)
 			return err
 		}
 	}
+	return nil
 }
 
+// dumpBatchSize - количество записей в одном пакете при загрузке дампа.
+const dumpBatchSize = 500
+
 // LoadData загрузка данных из файла
-func (s *StorageInPostgres) LoadData(pathToFile string) int {
-	// TODO реализовать загрузку БД из дампа
-	return 0
+// Записи читаются потоком и записываются пакетами, из нескольких версий одной ссылки остается последняя.
+func (s *StorageInPostgres) LoadData(ctx context.Context, pathToFile string) (int, error) {
+	count := 0
+	consumer, err := NewConsumer(pathToFile)
+	if err != nil {
+		return count, NewStorageError(err)
+	}
+	defer consumer.Close()
+
+	batch := &dumpBatch{batch: &pgx.Batch{}}
+	for {
+		shortURL, err := consumer.ReadShortURL()
+		if err != nil {
+			if err != io.EOF {
+				return count, NewStorageError(err)
+			}
+			break
+		}
+		batch.queue(shortURL)
+		if batch.batch.Len() >= dumpBatchSize {
+			loaded, err := s.sendDumpBatch(ctx, batch)
+			count += loaded
+			if err != nil {
+				return count, err
+			}
+			batch = &dumpBatch{batch: &pgx.Batch{}}
+		}
+	}
+	if batch.batch.Len() > 0 {
+		loaded, err := s.sendDumpBatch(ctx, batch)
+		count += loaded
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
 }
 
-// SaveData сохранение данных в файл
-func (s *StorageInPostgres) SaveData(pathToFile string) int {
-	// TODO реализовать сохранение БД в дамп
-	return 0
+// upsertDumpSQL - запись ссылки из дампа, более поздняя версия заменяет ссылку того же владельца.
+// Ссылка, уже сокращенная под другим кодом, пропускается, записанная ссылка возвращает свой код.
+const upsertDumpSQL = `
+	INSERT INTO urls (uuid, correlation_id, short, original, user_uid, deleted, expires_at, expired, created_at, preview,
+		password_hash, redirect_type, query_rules, notes)
+	SELECT $1::uuid, $2::text, $3::varchar, $4::text, $5::varchar, $6::boolean, $7::timestamptz, $8::boolean,
+		COALESCE($9::timestamptz, now()), $10::boolean, $11::text, $12::smallint, NULLIF($13::text, '')::jsonb, $14::text
+	WHERE NOT EXISTS (SELECT 1 FROM urls WHERE original = $4 AND short <> $3)
+	ON CONFLICT (short) DO UPDATE SET original = EXCLUDED.original, deleted = EXCLUDED.deleted,
+		expires_at = EXCLUDED.expires_at, expired = EXCLUDED.expired, preview = EXCLUDED.preview,
+		password_hash = EXCLUDED.password_hash, redirect_type = EXCLUDED.redirect_type, query_rules = EXCLUDED.query_rules,
+		notes = EXCLUDED.notes
+	WHERE urls.user_uid IS NOT DISTINCT FROM EXCLUDED.user_uid
+	RETURNING short`
+
+// dumpBatch - пакет записей дампа, теги привязываются отдельным пакетом только к записанным ссылкам.
+type dumpBatch struct {
+	batch *pgx.Batch
+	links []*dumpLink // по элементу на запрос пакета, nil - удаление ссылки
 }
 
-// Close - освобождение ресурсов
-func (s *StorageInPostgres) Close() {
-	s.connectionToDB.Close(context.Background())
+// dumpLink - ссылка из дампа с тегами владельца.
+type dumpLink struct {
+	short   string
+	userUID string
+	tags    []string
 }
 
-// CorrelationSave - сохранение данных (ссылка и идентификатор)
-func (s *StorageInPostgres) CorrelationSave(value string, correlationID string, userUID string) string {
-	// SQL-запрос на вставку новой записи
+// queue - добавление записи дампа в пакет, удаление из журнала хранилища в памяти помечает ссылку удаленной.
+func (b *dumpBatch) queue(shortURL *ShortURL) {
+	if shortURL.Operation == OperationDelete {
+		b.batch.Queue("UPDATE urls SET deleted = true WHERE short = $1", shortURL.ShortURL)
+		b.links = append(b.links, nil)
+		return
+	}
+	// Дамп хранилища в памяти содержит вместо UUID короткую ссылку
+	recordUUID, err := uuid.Parse(shortURL.UUID)
+	if err != nil {
+		recordUUID = uuid.New()
+	}
+	var correlationID *string
+	if shortURL.CorrelationID != "" {
+		correlationID = &shortURL.CorrelationID
+	}
+	record := recordFromShortURL(shortURL)
+	b.batch.Queue(upsertDumpSQL,
+		recordUUID, correlationID, shortURL.ShortURL, record.OriginalURL, record.UserUID,
+		shortURL.Deleted, shortURL.ExpiresAt, shortURL.Expired, shortURL.CreatedAt, shortURL.Preview, shortURL.PasswordHash,
+		shortURL.RedirectType, encodeQueryRules(shortURL.QueryRules), shortURL.Notes)
+	b.links = append(b.links, &dumpLink{short: shortURL.ShortURL, userUID: record.UserUID, tags: shortURL.Tags})
+}
+
+// sendDumpBatch - выполнение пакета загрузки, возвращает количество записанных ссылок.
+func (s *StorageInPostgres) sendDumpBatch(ctx context.Context, batch *dumpBatch) (int, error) {
+	var written []*dumpLink
+	batchResults := s.poolConnectionToDB.SendBatch(ctx, batch.batch)
+	for _, link := range batch.links {
+		if link == nil {
+			if _, err := batchResults.Exec(); err != nil {
+				batchResults.Close()
+				log.Printf("Failed to load dump record: %v\n", err)
+				return len(written), NewStorageError(err)
+			}
+			continue
+		}
+		var short string
+		err := batchResults.QueryRow().Scan(&short)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			batchResults.Close()
+			log.Printf("Failed to load dump record: %v\n", err)
+			return len(written), NewStorageError(err)
+		}
+		written = append(written, link)
+	}
+	if err := batchResults.Close(); err != nil {
+		return len(written), NewStorageError(err)
+	}
+	return len(written), s.replaceDumpTags(ctx, written)
+}
+
+// replaceDumpTags - замена тегов записанных ссылок в порядке записей дампа.
+func (s *StorageInPostgres) replaceDumpTags(ctx context.Context, links []*dumpLink) error {
+	batch := &pgx.Batch{}
+	for _, link := range links {
+		batch.Queue("DELETE FROM url_tags WHERE short = $1", link.short)
+		if len(link.tags) > 0 {
+			batch.Queue(linkTagsSQL, link.short, link.userUID, link.tags)
+		}
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	batchResults := s.poolConnectionToDB.SendBatch(ctx, batch)
+	defer batchResults.Close()
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := batchResults.Exec(); err != nil {
+			log.Printf("Failed to load dump tags: %v\n", err)
+			return NewStorageError(err)
+		}
+	}
+	return nil
+}
+
+// SaveData сохранение данных в файл
+// Таблица читается потоком, снимок пишется во временный файл и атомарно заменяет прежний.
+func (s *StorageInPostgres) SaveData(ctx context.Context, pathToFile string) (int, error) {
+	count := 0
 	query := `
-		INSERT INTO urls (uuid, short, original, user_uid)
-		VALUES ($1, $2, $3, $4)
+		SELECT uuid, COALESCE(correlation_id, ''), short, original, COALESCE(user_uid, ''),
+			COALESCE(deleted, false), expires_at, COALESCE(expired, false), created_at, ` + urlTagsColumn + `, preview,
+			password_hash, redirect_type, ` + urlQueryRulesColumn + `, notes
+		FROM urls
 	`
-	_, err := s.connectionToDB.Exec(context.Background(), query, correlationID, correlationID, value, userUID)
+	rows, err := s.poolConnectionToDB.Query(ctx, query)
+	if err != nil {
+		return count, NewStorageError(err)
+	}
+	defer rows.Close()
 
+	tmpPath := pathToFile + ".tmp"
+	producer, err := NewProducer(tmpPath)
 	if err != nil {
-		log.Printf("Failed to insert new record: %v\n", err)
+		return count, NewStorageError(err)
+	}
+	defer producer.Close()
+
+	for rows.Next() {
+		var recordUUID uuid.UUID
+		shortURL := ShortURL{}
+		var queryRules string
+		err = rows.Scan(&recordUUID, &shortURL.CorrelationID, &shortURL.ShortURL, &shortURL.OriginalURL,
+			&shortURL.UserUID, &shortURL.Deleted, &shortURL.ExpiresAt, &shortURL.Expired, &shortURL.CreatedAt, &shortURL.Tags,
+			&shortURL.Preview, &shortURL.PasswordHash, &shortURL.RedirectType, &queryRules, &shortURL.Notes)
+		if err != nil {
+			return count, NewStorageError(err)
+		}
+		if shortURL.QueryRules, err = decodeQueryRules(queryRules); err != nil {
+			return count, NewStorageError(err)
+		}
+		shortURL.UUID = recordUUID.String()
+		if err := producer.WriteShortURL(&shortURL); err != nil {
+			return count, NewStorageError(err)
+		}
+		count += 1
+	}
+	if rows.Err() != nil {
+		return count, NewStorageError(rows.Err())
+	}
+	if err := producer.Sync(); err != nil {
+		return count, NewStorageError(err)
+	}
+	if err := producer.Close(); err != nil {
+		return count, NewStorageError(err)
+	}
+	if err := os.Rename(tmpPath, pathToFile); err != nil {
+		return count, NewStorageError(err)
+	}
+	if err := syncDir(pathToFile); err != nil {
+		return count, NewStorageError(err)
 	}
+	return count, nil
+}
 
-	return correlationID
+// Close - освобождение ресурсов
+func (s *StorageInPostgres) Close() {
+	if s.poolConnectionToDB != nil {
+		s.poolConnectionToDB.Close()
+	}
+}
+
+// CorrelationSave - сохранение данных (ссылка и идентификатор)
+// Идентификатор становится кодом ссылки, занятый код не перезаписывается.
+func (s *StorageInPostgres) CorrelationSave(ctx context.Context, value string, correlationID string, userUID string) (string, error) {
+	inserted, err := s.insertLink(ctx, correlationID, correlationID, value, userUID, LinkOptions{})
+	if err != nil {
+		return s.insertError(ctx, err, value, correlationID, userUID)
+	}
+	if !inserted {
+		return correlationID, s.aliasConflict(ctx, value, correlationID, userUID)
+	}
+	return correlationID, nil
 }
 
 // CorrelationGet - чтение данных (ссылка и идентификатор)
-func (s *StorageInPostgres) CorrelationGet(correlationID string) (string, bool) {
+func (s *StorageInPostgres) CorrelationGet(ctx context.Context, correlationID string) (string, bool) {
 	var originalURL string
 
 	query := `
 		SELECT original FROM urls WHERE short = $1
 	`
-	err := s.connectionToDB.QueryRow(context.Background(), query, correlationID).Scan(&originalURL)
+	err := s.poolConnectionToDB.QueryRow(ctx, query, correlationID).Scan(&originalURL)
 	if err != nil {
 		log.Printf("Failed to find original URL: %v\n", err)
 		return originalURL, false
@@ -283,51 +972,61 @@ func (s *StorageInPostgres) CorrelationGet(correlationID string) (string, bool)
 	return originalURL, true
 }
 
-// CorrelationSave - сохранение данных (ссылок и идентификатор)
-func (s *StorageInPostgres) CorrelationsSave(correlationURLs []CorrelationURL, userUID string) ([]string, error) {
-
-	var output []string
-
-	// Подготовка SQL-запроса для вставки данных
-	query := `INSERT INTO urls (uuid, correlation_id, short, original, user_uid) VALUES ($1, $2, $3, $4, $5)`
+// CorrelationsSave - сохранение данных (ссылок и идентификатор)
+// Пакет сохраняется в одной транзакции: при любой ошибке не сохраняется ничего и возвращается ошибка.
+func (s *StorageInPostgres) CorrelationsSave(ctx context.Context, correlationURLs []CorrelationURL, userUID string) ([]string, error) {
 
 	// Начало транзакции
-	tx, err := s.connectionToDB.Begin(context.Background())
+	tx, err := s.poolConnectionToDB.Begin(ctx)
 	if err != nil {
 		log.Printf("Failed to begin transaction: %v\n", err)
-		return output, err
+		return nil, NewStorageError(err)
+	}
+	if err := s.checkLimitsTx(ctx, tx, userUID, len(correlationURLs)); err != nil {
+		tx.Rollback(ctx)
+		var le *LinkLimitError
+		if errors.As(err, &le) {
+			return nil, err
+		}
+		return nil, NewStorageError(err)
 	}
 
+	var output []string
 	for _, item := range correlationURLs {
 
-		newUUID := uuid.New()
-
-		// Генерация UUID (например, с использованием pgx или других методов)
 		shortURL := item.CorrelationID
 		originalURL := item.OriginalURL
-		output = append(output, shortURL)
 
-		// Выполнение вставки в рамках транзакции
-		_, err = tx.Exec(context.Background(), query, newUUID, item.CorrelationID, shortURL, originalURL, userUID)
+		// Выполнение вставки ссылки с признаками в рамках транзакции
+		inserted, err := insertLinkTx(ctx, tx, shortURL, item.CorrelationID, originalURL, userUID, item.Options)
+		if err == nil && !inserted {
+			tx.Rollback(ctx)
+			return nil, NewAliasTakenError(shortURL)
+		}
 		if err != nil {
-			tx.Rollback(context.Background())
+			tx.Rollback(ctx)
 			log.Printf("Failed to insert data: %v\n", err)
 			// Проверка на ошибку типа UniqueViolation
 			var pge *pgconn.PgError
 			if errors.As(err, &pge) {
 				if pge.Code == pgerrcode.UniqueViolation {
-					log.Println("Error: A url with the same value already exists.")
-					return output, NewUniqURLError(originalURL, shortURL)
+					if pge.ConstraintName == urlsShortIndex {
+						return nil, NewAliasTakenError(shortURL)
+					}
+					// Ссылка уже сокращена ранее, в ответе код существующей ссылки, а не откаченного пакета
+					_, err := s.existingShort(ctx, originalURL, "")
+					return nil, err
 				}
 			}
-			return output, nil
+			return nil, NewStorageError(err)
 		}
+		output = append(output, shortURL)
 	}
 
 	// Зафиксировать транзакцию
-	err = tx.Commit(context.Background())
-	if err != nil {
+	if err := tx.Commit(ctx); err != nil {
 		log.Printf("Failed to commit transaction: %v\n", err)
+		return nil, NewStorageError(err)
 	}
 
 	return output, nil
@@ -1,11 +1,16 @@
 package storage
 
 import (
-	//"context"
+	"context"
 	"fmt"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/pashagolub/pgxmock/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,6 +24,7 @@ func setupMockDB(t *testing.T) (*StorageInPostgres, pgxmock.PgxPoolIface, func()
 	storage := &StorageInPostgres{
 		poolConnectionToDB: mockDB, // Используем пул подключений
 		lengthShortURL:     8,      // задайте любое значение по умолчанию
+		generator:          NewHashGenerator(8),
 	}
 
 	// Возвращаем функцию для закрытия мок-соединения
@@ -27,6 +33,11 @@ func setupMockDB(t *testing.T) (*StorageInPostgres, pgxmock.PgxPoolIface, func()
 	}
 }
 
+// insertArgs - аргументы вставки ссылки без признаков.
+func insertArgs(hashKey string, value string, userUID interface{}) []interface{} {
+	return []interface{}{pgxmock.AnyArg(), hashKey, value, userUID, (*time.Time)(nil), false, "", 0, "", "", ""}
+}
+
 // Пример теста для метода Save
 func TestStorageInPostgresSave(t *testing.T) {
 	storage, mockDB, cleanup := setupMockDB(t)
@@ -36,15 +47,187 @@ func TestStorageInPostgresSave(t *testing.T) {
 	targetHash := "77fca595"
 	userUID := uuid.New().String()
 
-	mockDB.ExpectExec(".*").
-		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(insertArgs(targetHash, originalURL, userUID)...).
 		WillReturnResult(pgxmock.NewResult("EXECUTE", 1))
 
-	resultHash, err := storage.Save(originalURL, userUID)
+	resultHash, err := storage.Save(context.Background(), originalURL, userUID)
 	assert.NoError(t, err, fmt.Sprintf("error: %s", err))
 	assert.Equal(t, targetHash, resultHash)
 }
 
+// Пример теста для метода Save при коллизии кода
+func TestStorageInPostgresSaveRetry(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	storage.SetCodeGenerator(&fixedGenerator{codes: []string{"a", "b"}})
+
+	userUID := uuid.New().String()
+
+	// Код "a" занят другой ссылкой, вставка не выполнена
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(insertArgs("a", "https://yandex.ru/", userUID)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	mockDB.ExpectQuery("SELECT original FROM urls WHERE short").
+		WithArgs("a").
+		WillReturnRows(pgxmock.NewRows([]string{"original"}).AddRow("https://google.ru/"))
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(insertArgs("b", "https://yandex.ru/", userUID)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	resultHash, err := storage.Save(context.Background(), "https://yandex.ru/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", resultHash)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста для продолжения последовательных кодов после сохраненных в БД
+func TestStorageInPostgresSeedCodeGenerator(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	generator := NewSequentialGenerator(3, 0)
+	storage.SetCodeGenerator(generator)
+
+	mockDB.ExpectQuery("SELECT short FROM urls").
+		WillReturnRows(pgxmock.NewRows([]string{"short"}).AddRow("00z").AddRow("010").AddRow("promo"))
+
+	assert.NoError(t, storage.SeedCodeGenerator(context.Background()))
+	code, _ := generator.Generate("https://yandex.ru/", 0)
+	assert.Equal(t, "011", code)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста для метода Save при коллизии усеченного хеша
+func TestStorageInPostgresHashCollision(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	storage.SetCodeGenerator(NewHashGeneratorWithFunc(10, collidingHash))
+
+	userUID := uuid.New().String()
+	saltedHash := truncateHash(sha256Hex("https://google.ru/#1"), 10)
+
+	// Код занят ссылкой https://yandex.ru/
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(insertArgs("c0111510ff", "https://google.ru/", userUID)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	mockDB.ExpectQuery("SELECT original FROM urls WHERE short").
+		WithArgs("c0111510ff").
+		WillReturnRows(pgxmock.NewRows([]string{"original"}).AddRow("https://yandex.ru/"))
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(insertArgs(saltedHash, "https://google.ru/", userUID)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	resultHash, err := storage.Save(context.Background(), "https://google.ru/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, saltedHash, resultHash)
+
+	// Та же ссылка - конфликт без повторных попыток
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(insertArgs("c0111510ff", "https://yandex.ru/", userUID)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	mockDB.ExpectQuery("SELECT original FROM urls WHERE short").
+		WithArgs("c0111510ff").
+		WillReturnRows(pgxmock.NewRows([]string{"original"}).AddRow("https://yandex.ru/"))
+
+	resultHash, err = storage.Save(context.Background(), "https://yandex.ru/", userUID)
+	var ue *UniqURLError
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, "c0111510ff", resultHash)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста для метода SaveLink с псевдонимом
+func TestStorageInPostgresSaveLinkWithAlias(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	userUID := uuid.New().String()
+
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(insertArgs("spring-sale", "https://yandex.ru/", userUID)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	resultHash, err := storage.SaveLink(context.Background(), "https://yandex.ru/", "spring-sale", userUID, LinkOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "spring-sale", resultHash)
+
+	// Псевдоним уже занят другим пользователем, вставку отклоняет уникальный индекс
+	shortTaken := &pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: urlsShortIndex}
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(insertArgs("spring-sale", "https://google.ru/", pgxmock.AnyArg())...).
+		WillReturnError(shortTaken)
+	mockDB.ExpectQuery("SELECT original, COALESCE\\(user_uid, ''\\) FROM urls WHERE short").
+		WithArgs("spring-sale").
+		WillReturnRows(pgxmock.NewRows([]string{"original", "user_uid"}).AddRow("https://yandex.ru/", userUID))
+
+	_, err = storage.SaveLink(context.Background(), "https://google.ru/", "spring-sale", uuid.New().String(), LinkOptions{})
+	var ae *AliasTakenError
+	assert.ErrorAs(t, err, &ae)
+
+	// Пакетное сохранение под тем же кодом тоже отклоняется
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(append(insertArgs("spring-sale", "https://google.ru/", pgxmock.AnyArg())[:9], "spring-sale", "")...).
+		WillReturnError(shortTaken)
+	mockDB.ExpectQuery("SELECT original, COALESCE\\(user_uid, ''\\) FROM urls WHERE short").
+		WithArgs("spring-sale").
+		WillReturnRows(pgxmock.NewRows([]string{"original", "user_uid"}).AddRow("https://yandex.ru/", userUID))
+
+	_, err = storage.CorrelationSave(context.Background(), "https://google.ru/", "spring-sale", uuid.New().String())
+	assert.ErrorAs(t, err, &ae)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста для метода SaveLink: ссылка, пароль и теги сохраняются одной транзакцией
+func TestStorageInPostgresSaveLink(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	userUID := uuid.New().String()
+	options := LinkOptions{Tags: []string{"work"}, PasswordHash: "hash", RedirectType: 308}
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(pgxmock.AnyArg(), "secret", "https://yandex.ru/", userUID, (*time.Time)(nil), false, "hash", 308, "", "", "").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockDB.ExpectExec("INSERT INTO tags").
+		WithArgs("secret", userUID, []string{"work"}).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectCommit()
+
+	resultHash, err := storage.SaveLink(context.Background(), "https://yandex.ru/", "secret", userUID, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", resultHash)
+
+	// Ошибка тегов откатывает и саму ссылку
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO urls").
+		WithArgs(pgxmock.AnyArg(), "secret-2", "https://google.ru/", userUID, (*time.Time)(nil), false, "hash", 308, "", "", "").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockDB.ExpectExec("INSERT INTO tags").
+		WithArgs("secret-2", userUID, []string{"work"}).
+		WillReturnError(fmt.Errorf("connection reset"))
+	mockDB.ExpectRollback()
+
+	_, err = storage.SaveLink(context.Background(), "https://google.ru/", "secret-2", userUID, options)
+	assert.Error(t, err)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста для фоновой пометки просроченных ссылок
+func TestStorageInPostgresExpiration(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	expiresAt := time.Now().Add(time.Hour)
+	mockDB.ExpectExec("UPDATE urls SET expired = true").
+		WithArgs(expiresAt).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 3))
+	marked, err := storage.MarkExpired(context.Background(), expiresAt)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, marked)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
 // Пример теста для метода Get
 func TestStorageInPostgresGet(t *testing.T) {
 	storage, mockDB, cleanup := setupMockDB(t)
@@ -60,29 +243,136 @@ func TestStorageInPostgresGet(t *testing.T) {
 		WithArgs(targetHash).
 		WillReturnRows(pgxmock.NewRows([]string{"original"}).AddRow(originalURL))
 
-	result, _ := storage.Get(targetHash)
+	result, _ := storage.Get(context.Background(), targetHash)
 	//assert.True(t, found)
 	assert.Equal(t, "", result) // TODO разобратся почему не возвращается original из метода scan
 }
 
-// Пример теста для метода FindByUserUID реализовать мок для простого соеденения
-func DtestStorageInPostgresFindByUserUID(t *testing.T) {
+// Удаление помечает ссылки в БД, признак читается из БД
+func TestStorageInPostgresDelete(t *testing.T) {
 	storage, mockDB, cleanup := setupMockDB(t)
 	defer cleanup()
 	userUID := uuid.New().String()
 
-	rows := pgxmock.NewRows([]string{"short", "original"}).
-		AddRow("hash1", "https://example1.com").
-		AddRow("hash2", "https://example2.com")
+	batch := mockDB.ExpectBatch()
+	batch.ExpectExec("UPDATE urls SET deleted").WithArgs(true, "hash1", userUID).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	batch.ExpectExec("UPDATE urls SET deleted").WithArgs(true, "hash2", userUID).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	mockDB.ExpectQuery("SELECT COALESCE\\(deleted, false\\) FROM urls").WithArgs("hash1").
+		WillReturnRows(pgxmock.NewRows([]string{"deleted"}).AddRow(true))
+	mockDB.ExpectQuery("SELECT COALESCE\\(deleted, false\\) FROM urls").WithArgs("hash2").
+		WillReturnError(pgx.ErrNoRows)
 
-	mockDB.ExpectQuery("SELECT short, original FROM urls WHERE user_uid = $1").
+	assert.NoError(t, storage.DeleteByUser(context.Background(), []string{"hash1", "hash2"}, userUID))
+	deleted, err := storage.IsDeleted(context.Background(), "hash1")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = storage.IsDeleted(context.Background(), "hash2")
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+
+	batch = mockDB.ExpectBatch()
+	batch.ExpectExec("UPDATE urls SET deleted").WithArgs(false, "hash1", userUID).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	assert.NoError(t, storage.RestoreByUser(context.Background(), []string{"hash1"}, userUID))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста для метода UpdateByUser
+func TestStorageInPostgresUpdate(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	userUID := uuid.New().String()
+	preview := true
+	tags := []string{"work"}
+	selectDeleted := `SELECT COALESCE\(deleted, false\) FROM urls WHERE short = \$1 AND user_uid = \$2 FOR UPDATE`
+
+	// Ссылка и признаки меняются в одной транзакции
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(selectDeleted).WithArgs("hash1", userUID).
+		WillReturnRows(pgxmock.NewRows([]string{"deleted"}).AddRow(false))
+	mockDB.ExpectExec("UPDATE urls SET").
+		WithArgs("hash1", "https://ya.ru/", nil, &preview, (*int)(nil), false, "", (*string)(nil)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mockDB.ExpectExec("DELETE FROM url_tags").WithArgs("hash1").
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mockDB.ExpectExec("INSERT INTO tags").WithArgs("hash1", userUID, tags).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectCommit()
+	assert.NoError(t, storage.UpdateByUser(context.Background(), "hash1",
+		LinkPatch{OriginalURL: "https://ya.ru/", Preview: &preview, Tags: &tags}, userUID))
+
+	// Чужая или несуществующая ссылка
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(selectDeleted).WithArgs("hash2", userUID).WillReturnError(pgx.ErrNoRows)
+	mockDB.ExpectRollback()
+	err := storage.UpdateByUser(context.Background(), "hash2", LinkPatch{OriginalURL: "https://ya.ru/"}, userUID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Удаленная ссылка не меняется
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(selectDeleted).WithArgs("hash1", userUID).
+		WillReturnRows(pgxmock.NewRows([]string{"deleted"}).AddRow(true))
+	mockDB.ExpectRollback()
+	err = storage.UpdateByUser(context.Background(), "hash1", LinkPatch{OriginalURL: "https://ya.ru/"}, userUID)
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	// Ссылка уже сокращена под другим кодом, теги не меняются
+	mockDB.ExpectBegin()
+	mockDB.ExpectQuery(selectDeleted).WithArgs("hash1", userUID).
+		WillReturnRows(pgxmock.NewRows([]string{"deleted"}).AddRow(false))
+	mockDB.ExpectExec("UPDATE urls SET").
+		WithArgs("hash1", "https://google.ru/", nil, (*bool)(nil), (*int)(nil), false, "", (*string)(nil)).
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	mockDB.ExpectQuery("SELECT short FROM urls WHERE original").WithArgs("https://google.ru/").
+		WillReturnRows(pgxmock.NewRows([]string{"short"}).AddRow("hash3"))
+	mockDB.ExpectRollback()
+	err = storage.UpdateByUser(context.Background(), "hash1", LinkPatch{OriginalURL: "https://google.ru/", Tags: &tags}, userUID)
+	var ue *UniqURLError
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, "hash3", ue.ShortHash)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста для метода FindByUserUID, запрос выполняется через пул соединений
+func TestStorageInPostgresFindByUserUID(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	userUID := uuid.New().String()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rows := pgxmock.NewRows([]string{"short", "original", "created_at", "expires_at", "expired", "deleted", "tags", "preview", "redirect_type", "query_rules", "notes"}).
+		AddRow("hash1", "https://example1.com", createdAt, (*time.Time)(nil), false, false, []string{"work"}, false, 0, "", "").
+		AddRow("hash2", "https://example2.com", createdAt, (*time.Time)(nil), false, true, []string{}, false, 0, "", "")
+
+	mockDB.ExpectQuery("SELECT short, original, created_at.* FROM urls WHERE user_uid = \\$1").
 		WithArgs(userUID).
 		WillReturnRows(rows)
 
-	result, err := storage.FindByUserUID(userUID)
+	result, err := storage.FindByUserUID(context.Background(), userUID)
 	assert.NoError(t, err)
 	assert.Len(t, result, 2)
 	assert.Equal(t, "hash1", result[0].ShortHash)
+	assert.Equal(t, []string{"work"}, result[0].Tags)
+	assert.True(t, result[1].Deleted)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста для метода CorrelationGet, запрос выполняется через пул соединений
+func TestStorageInPostgresCorrelationGet(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	mockDB.ExpectQuery("SELECT original FROM urls WHERE short").WithArgs("a1").
+		WillReturnRows(pgxmock.NewRows([]string{"original"}).AddRow("https://a1.ru/"))
+	originalURL, found := storage.CorrelationGet(context.Background(), "a1")
+	assert.True(t, found)
+	assert.Equal(t, "https://a1.ru/", originalURL)
+
+	mockDB.ExpectQuery("SELECT original FROM urls WHERE short").WithArgs("unknown").WillReturnError(pgx.ErrNoRows)
+	_, found = storage.CorrelationGet(context.Background(), "unknown")
+	assert.False(t, found)
 	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
 
@@ -96,3 +386,154 @@ func TestStorageInPostgresInit(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+// Пример теста для выгрузки и загрузки дампа
+func TestStorageInPostgresDump(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	pathToFile := filepath.Join(t.TempDir(), "dump.db")
+	recordUUID := uuid.New()
+	userUID := uuid.New().String()
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockDB.ExpectQuery("SELECT uuid").
+		WillReturnRows(pgxmock.NewRows([]string{"uuid", "correlation_id", "short", "original", "user_uid", "deleted", "expires_at", "expired", "created_at", "tags", "preview", "password_hash", "redirect_type", "query_rules", "notes"}).
+			AddRow(recordUUID, "", "77fca595", "https://yandex.ru/", userUID, true, &expiresAt, false, &createdAt, []string{"work"}, true, "$2a$10$hash", 308, `{"params":{"utm_source":"dump"}}`, "pricing page").
+			AddRow(uuid.New(), "batch-1", "batch-1", "https://google.ru/", userUID, false, (*time.Time)(nil), false, &createdAt, []string{}, false, "", 0, "", ""))
+
+	saved, err := storage.SaveData(context.Background(), pathToFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, saved)
+
+	consumer, err := NewConsumer(pathToFile)
+	assert.NoError(t, err)
+	first, err := consumer.ReadShortURL()
+	assert.NoError(t, err)
+	assert.Equal(t, recordUUID.String(), first.UUID)
+	assert.True(t, first.Deleted)
+	assert.Equal(t, userUID, first.UserUID)
+	assert.True(t, expiresAt.Equal(*first.ExpiresAt))
+	assert.True(t, createdAt.Equal(*first.CreatedAt))
+	assert.Equal(t, []string{"work"}, first.Tags)
+	assert.True(t, first.Preview)
+	assert.Equal(t, "$2a$10$hash", first.PasswordHash)
+	assert.Equal(t, 308, first.RedirectType)
+	assert.Equal(t, &QueryRules{Params: map[string]string{"utm_source": "dump"}}, first.QueryRules)
+	assert.Equal(t, "pricing page", first.Notes)
+	consumer.Close()
+
+	// Удаление из журнала хранилища в памяти
+	producer, err := NewAppendProducer(pathToFile)
+	assert.NoError(t, err)
+	assert.NoError(t, producer.WriteShortURL(&ShortURL{UUID: "batch-1", ShortURL: "batch-1", Operation: OperationDelete}))
+	producer.Close()
+
+	// Загрузка: ссылка, уже сокращенная под другим кодом, пропускается, удаление помечает ссылку удаленной
+	batch := mockDB.ExpectBatch()
+	batch.ExpectQuery("INSERT INTO urls .* ON CONFLICT \\(short\\) DO UPDATE").
+		WithArgs(recordUUID, pgxmock.AnyArg(), "77fca595", "https://yandex.ru/", userUID, true, pgxmock.AnyArg(), false, pgxmock.AnyArg(), true, "$2a$10$hash", 308, `{"params":{"utm_source":"dump"}}`, "pricing page").
+		WillReturnRows(pgxmock.NewRows([]string{"short"}).AddRow("77fca595"))
+	batch.ExpectQuery("INSERT INTO urls").
+		WithArgs(pgxmock.AnyArg(), pgxmock.AnyArg(), "batch-1", "https://google.ru/", userUID, false, pgxmock.AnyArg(), false, pgxmock.AnyArg(), false, "", 0, "", "").
+		WillReturnRows(pgxmock.NewRows([]string{"short"}))
+	batch.ExpectExec("UPDATE urls SET deleted = true").WithArgs("batch-1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	// Теги заменяются только у записанной ссылки
+	tags := mockDB.ExpectBatch()
+	tags.ExpectExec("DELETE FROM url_tags").WithArgs("77fca595").
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	tags.ExpectExec("WITH tag_ids AS").WithArgs("77fca595", userUID, []string{"work"}).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+
+	loaded, err := storage.LoadData(context.Background(), pathToFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста ограничений количества ссылок: проверка и вставка в одной транзакции под блокировкой пользователя
+func TestStorageInPostgresLinkLimits(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	userUID := uuid.New().String()
+	storage.SetLinkLimits(LinkLimits{Total: 10, Daily: 2})
+
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("pg_advisory_xact_lock").WithArgs(userUID).WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectQuery("SELECT count").WithArgs(userUID, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"total", "daily"}).AddRow(1, 1))
+	mockDB.ExpectExec("INSERT INTO urls").WithArgs(insertArgs("alias", "https://yandex.ru/", userUID)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockDB.ExpectCommit()
+
+	resultHash, err := storage.SaveLink(context.Background(), "https://yandex.ru/", "alias", userUID, LinkOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "alias", resultHash)
+
+	// Пакет сверх суточного ограничения не вставляется
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("pg_advisory_xact_lock").WithArgs(userUID).WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectQuery("SELECT count").WithArgs(userUID, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"total", "daily"}).AddRow(2, 2))
+	mockDB.ExpectRollback()
+
+	output, err := storage.CorrelationsSave(context.Background(), []CorrelationURL{{CorrelationID: "a1", OriginalURL: "https://a1.ru/"}}, userUID)
+	var le *LinkLimitError
+	assert.ErrorAs(t, err, &le)
+	assert.True(t, le.Daily)
+	assert.Nil(t, output)
+
+	mockDB.ExpectQuery("SELECT count").WithArgs(userUID, pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"total", "daily"}).AddRow(2, 1))
+	count, err := storage.CountByUser(context.Background(), userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, LinkCount{Total: 2, Daily: 1}, count)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+// Пример теста пакетного сохранения: при откате транзакции возвращается ошибка и ни одной ссылки
+func TestStorageInPostgresCorrelationsSaveRollback(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	userUID := uuid.New().String()
+	// Идентификатор запроса сохраняется последним аргументом
+	correlationArgs := func(correlationID string, value string) []interface{} {
+		args := insertArgs(correlationID, value, userUID)
+		args[9] = correlationID
+		return args
+	}
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO urls").WithArgs(correlationArgs("a1", "https://a1.ru/")...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockDB.ExpectExec("INSERT INTO urls").WithArgs(correlationArgs("a2", "https://a2.ru/")...).
+		WillReturnError(fmt.Errorf("connection reset"))
+	mockDB.ExpectRollback()
+
+	output, err := storage.CorrelationsSave(context.Background(), []CorrelationURL{
+		{CorrelationID: "a1", OriginalURL: "https://a1.ru/"},
+		{CorrelationID: "a2", OriginalURL: "https://a2.ru/"},
+	}, userUID)
+	assert.Error(t, err)
+	assert.Nil(t, output)
+
+	// Уже сокращенная ссылка: в ошибке код существующей ссылки, а не идентификатор откаченного пакета
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("INSERT INTO urls").WithArgs(correlationArgs("a3", "https://a1.ru/")...).
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation, ConstraintName: "urls_original_key"})
+	mockDB.ExpectRollback()
+	mockDB.ExpectQuery("SELECT short FROM urls WHERE original").WithArgs("https://a1.ru/").
+		WillReturnRows(pgxmock.NewRows([]string{"short"}).AddRow("77fca595"))
+
+	output, err = storage.CorrelationsSave(context.Background(), []CorrelationURL{
+		{CorrelationID: "a3", OriginalURL: "https://a1.ru/"},
+	}, userUID)
+	var ue *UniqURLError
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, "77fca595", ue.ShortHash)
+	assert.Nil(t, output)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -2,156 +2,715 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"os"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 )
 
+// memoryRecord - запись хранилища в памяти.
+type memoryRecord struct {
+	OriginalURL  string
+	UserUID      string
+	CreatedAt    time.Time   // нулевое значение - ссылка сохранена до появления поля
+	ExpiresAt    time.Time   // нулевое значение - ссылка бессрочная
+	Expired      bool        // помечена фоновой очисткой как просроченная
+	Deleted      bool        // удалена владельцем, может быть восстановлена
+	Tags         []string    // теги владельца в порядке сортировки
+	Preview      bool        // переход через страницу предпросмотра
+	PasswordHash string      // медленный хеш пароля, пусто - ссылка без пароля
+	RedirectType int         // код перенаправления, 0 - код по умолчанию
+	QueryRules   *QueryRules // правила параметров запроса, nil - общие правила
+	Notes        string      // заметка владельца
+}
+
+// isExpired - истек ли срок действия записи на момент now.
+func (r *memoryRecord) isExpired(now time.Time) bool {
+	return r.Expired || (!r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt))
+}
+
+// toShortURL - преобразование записи в формат файла хранилища.
+func (r *memoryRecord) toShortURL(hashKey string) ShortURL {
+	shortURL := ShortURL{
+		UUID: hashKey, OriginalURL: r.OriginalURL, ShortURL: hashKey,
+		UserUID: r.UserUID, Expired: r.Expired, Deleted: r.Deleted, Tags: r.Tags, Preview: r.Preview,
+		PasswordHash: r.PasswordHash, RedirectType: r.RedirectType, QueryRules: r.QueryRules, Notes: r.Notes,
+	}
+	if !r.CreatedAt.IsZero() {
+		createdAt := r.CreatedAt
+		shortURL.CreatedAt = &createdAt
+	}
+	if !r.ExpiresAt.IsZero() {
+		expiresAt := r.ExpiresAt
+		shortURL.ExpiresAt = &expiresAt
+	}
+	return shortURL
+}
+
+// toShortHashURL - ссылка с короткой для выдачи из хранилища.
+func (r *memoryRecord) toShortHashURL(hashKey string, now time.Time) ShortHashURL {
+	return ShortHashURL{
+		ShortHash:    hashKey,
+		OriginalURL:  r.OriginalURL,
+		UserUID:      r.UserUID,
+		PasswordHash: r.PasswordHash,
+		CreatedAt:    r.CreatedAt,
+		ExpiresAt:    r.ExpiresAt,
+		Expired:      r.isExpired(now),
+		Deleted:      r.Deleted,
+		Tags:         r.Tags,
+		Preview:      r.Preview,
+		RedirectType: r.RedirectType,
+		QueryRules:   r.QueryRules,
+		Notes:        r.Notes,
+	}
+}
+
+// recordFromShortURL - преобразование записи из формата файла хранилища.
+func recordFromShortURL(shortURL *ShortURL) *memoryRecord {
+	record := &memoryRecord{
+		OriginalURL:  shortURL.OriginalURL,
+		UserUID:      shortURL.UserUID,
+		Expired:      shortURL.Expired,
+		Deleted:      shortURL.Deleted,
+		Tags:         shortURL.Tags,
+		Preview:      shortURL.Preview,
+		PasswordHash: shortURL.PasswordHash,
+		RedirectType: shortURL.RedirectType,
+		QueryRules:   shortURL.QueryRules,
+		Notes:        shortURL.Notes,
+	}
+	// Старый формат файла хранил пользователя в строке ссылки: originURL | userUUID
+	if record.UserUID == "" {
+		if idx := strings.LastIndex(record.OriginalURL, "|"); idx >= 0 {
+			record.OriginalURL, record.UserUID = record.OriginalURL[:idx], record.OriginalURL[idx+1:]
+		}
+	}
+	if shortURL.CreatedAt != nil {
+		record.CreatedAt = *shortURL.CreatedAt
+	}
+	if shortURL.ExpiresAt != nil {
+		record.ExpiresAt = *shortURL.ExpiresAt
+	}
+	return record
+}
+
 // StorageInMemory - хранилище в памяти ПК.
 type StorageInMemory struct {
 	mu             sync.Mutex // синхронизация доступа к хранилищу
-	data           map[string]string
+	data           map[string]*memoryRecord
+	originals      map[string]string                         // originURL -> hash, обратный индекс для проверки уникальности
+	users          map[string]map[string]struct{}            // userUID -> hash, индекс для поиска ссылок пользователя
+	trigrams       map[string]map[string]map[string]struct{} // userUID -> триграмма ссылки, кода или заметки -> hash, обратный индекс для поиска
 	lengthShortURL int
+	generator      CodeGenerator
+	limits         LinkLimits
+	journal        *Producer // журнал изменений, nil если журналирование выключено
+	journalPath    string
+	journalOps     int // количество записей в журнале после последнего снимка
 }
 
 // NewStorageInMemory - конструктор.
 func NewStorageInMemory(lengthShortURL int) (*StorageInMemory, error) {
-	return &StorageInMemory{data: make(map[string]string), lengthShortURL: lengthShortURL}, nil
+	return &StorageInMemory{
+		data:           make(map[string]*memoryRecord),
+		originals:      make(map[string]string),
+		users:          make(map[string]map[string]struct{}),
+		trigrams:       make(map[string]map[string]map[string]struct{}),
+		lengthShortURL: lengthShortURL,
+		generator:      NewHashGenerator(lengthShortURL),
+	}, nil
+}
+
+// SetCodeGenerator - замена стратегии генерации коротких кодов.
+// Генератор продолжает выдачу после уже загруженных кодов.
+func (s *StorageInMemory) SetCodeGenerator(generator CodeGenerator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generator = generator
+	for hashKey := range s.data {
+		seedGenerator(generator, hashKey)
+	}
+}
+
+// SetLinkLimits - ограничения количества ссылок пользователя для новых ссылок.
+func (s *StorageInMemory) SetLinkLimits(limits LinkLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+}
+
+// CountByUser - количество ссылок пользователя всего и за текущие сутки.
+func (s *StorageInMemory) CountByUser(ctx context.Context, userUID string) (LinkCount, error) {
+	if err := ctx.Err(); err != nil {
+		return LinkCount{}, NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.countByUser(userUID, time.Now()), nil
+}
+
+// countByUser - подсчет ссылок пользователя через индекс пользователей, вызывается под блокировкой.
+func (s *StorageInMemory) countByUser(userUID string, now time.Time) LinkCount {
+	count := LinkCount{Total: len(s.users[userUID])}
+	dayStart := DayStart(now)
+	for hashKey := range s.users[userUID] {
+		if !s.data[hashKey].CreatedAt.Before(dayStart) {
+			count.Daily++
+		}
+	}
+	return count
+}
+
+// checkLimits - проверка ограничений перед созданием requested ссылок пользователя, вызывается под блокировкой.
+func (s *StorageInMemory) checkLimits(userUID string, requested int) error {
+	if !s.limits.enabled() {
+		return nil
+	}
+	return s.limits.check(s.countByUser(userUID, time.Now()), requested)
 }
 
 // Save - сохранение новой ссылки.
-func (s *StorageInMemory) Save(value string, userUID string) (string, error) {
+func (s *StorageInMemory) Save(ctx context.Context, value string, userUID string) (string, error) {
+	return s.SaveLink(ctx, value, "", userUID, LinkOptions{})
+}
+
+// SaveLink - сохранение новой ссылки вместе с признаками одной записью.
+func (s *StorageInMemory) SaveLink(ctx context.Context, value string, alias string, userUID string, options LinkOptions) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", NewStorageError(err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	hashKey := makeHash(value, s.lengthShortURL)
-	// Проверка наличии ключа в map
-	_, exists := s.data[hashKey]
-	if exists {
-		return hashKey, NewUniqURLError(value, hashKey)
+	hashKey := alias
+	if alias != "" {
+		if existKey, err := s.checkAlias(value, alias, userUID); err != nil {
+			return existKey, err
+		}
 	} else {
-		s.data[hashKey] = fmt.Sprintf("%s|%s", value, userUID) // hash -> originURL | userUUID
-		return hashKey, nil
+		var err error
+		if hashKey, err = s.freeCode(value); err != nil {
+			return hashKey, err
+		}
 	}
+	if err := s.checkLimits(userUID, 1); err != nil {
+		return "", err
+	}
+	if err := s.put(hashKey, newMemoryRecord(value, userUID, options)); err != nil {
+		return "", NewStorageError(err)
+	}
+	return hashKey, nil
+}
+
+// freeCode - свободный код для новой ссылки, вызывается под блокировкой.
+func (s *StorageInMemory) freeCode(value string) (string, error) {
+	// Ссылка уже сокращена ранее
+	if existKey, exists := s.originals[value]; exists {
+		return existKey, NewUniqURLError(value, existKey)
+	}
+	previousKey := ""
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		hashKey, err := s.generator.Generate(value, attempt)
+		if err != nil {
+			return "", NewStorageError(err)
+		}
+		// Проверка наличии ключа в map
+		existing, exists := s.data[hashKey]
+		if !exists {
+			return hashKey, nil
+		}
+		// Код занят той же ссылкой - это не коллизия
+		if existing.OriginalURL == value {
+			return hashKey, NewUniqURLError(value, hashKey)
+		}
+		// Код занят другой ссылкой - коллизия, пробуем следующий код
+		// Детерминированный генератор вернул тот же код, повторять бессмысленно
+		if hashKey == previousKey {
+			break
+		}
+		previousKey = hashKey
+	}
+	return "", NewStorageError(errNoFreeCode)
+}
+
+// newMemoryRecord - запись новой ссылки с признаками.
+func newMemoryRecord(value string, userUID string, options LinkOptions) *memoryRecord {
+	return &memoryRecord{
+		OriginalURL:  value,
+		UserUID:      userUID,
+		CreatedAt:    time.Now().UTC(),
+		ExpiresAt:    options.ExpiresAt,
+		Tags:         options.Tags,
+		Preview:      options.Preview,
+		PasswordHash: options.PasswordHash,
+		RedirectType: options.RedirectType,
+		QueryRules:   options.QueryRules,
+		Notes:        options.Notes,
+	}
+}
+
+// put - запись ссылки в журнал и хранилище, вызывается под блокировкой.
+// Ссылка, не записанная в журнал, в хранилище не попадает.
+func (s *StorageInMemory) put(hashKey string, record *memoryRecord) error {
+	if err := s.writeJournal(hashKey, record); err != nil {
+		return err
+	}
+	s.apply(hashKey, record)
+	return nil
+}
+
+// apply - запись ссылки с обновлением индексов, вызывается под блокировкой.
+func (s *StorageInMemory) apply(hashKey string, record *memoryRecord) {
+	s.unapply(hashKey)
+	s.data[hashKey] = record
+	seedGenerator(s.generator, hashKey)
+	s.originals[record.OriginalURL] = hashKey
+	if s.users[record.UserUID] == nil {
+		s.users[record.UserUID] = make(map[string]struct{})
+	}
+	s.users[record.UserUID][hashKey] = struct{}{}
+	if s.trigrams[record.UserUID] == nil {
+		s.trigrams[record.UserUID] = make(map[string]map[string]struct{})
+	}
+	userTrigrams := s.trigrams[record.UserUID]
+	for _, trigram := range recordTrigrams(hashKey, record) {
+		if userTrigrams[trigram] == nil {
+			userTrigrams[trigram] = make(map[string]struct{})
+		}
+		userTrigrams[trigram][hashKey] = struct{}{}
+	}
+}
+
+// unapply - удаление ссылки с обновлением индексов, вызывается под блокировкой.
+func (s *StorageInMemory) unapply(hashKey string) {
+	record, exists := s.data[hashKey]
+	if !exists {
+		return
+	}
+	if s.originals[record.OriginalURL] == hashKey {
+		delete(s.originals, record.OriginalURL)
+	}
+	delete(s.users[record.UserUID], hashKey)
+	if len(s.users[record.UserUID]) == 0 {
+		delete(s.users, record.UserUID)
+	}
+	userTrigrams := s.trigrams[record.UserUID]
+	for _, trigram := range recordTrigrams(hashKey, record) {
+		delete(userTrigrams[trigram], hashKey)
+		if len(userTrigrams[trigram]) == 0 {
+			delete(userTrigrams, trigram)
+		}
+	}
+	if len(userTrigrams) == 0 {
+		delete(s.trigrams, record.UserUID)
+	}
+	delete(s.data, hashKey)
+}
+
+// recordTrigrams - ключи обратного индекса для ссылки: триграммы оригинальной ссылки, короткого кода и заметки.
+// Повторы триграмм разных полей не мешают, индекс хранит множества.
+func recordTrigrams(hashKey string, record *memoryRecord) []string {
+	trigrams := append(searchTrigrams(record.OriginalURL), searchTrigrams(hashKey)...)
+	return append(trigrams, searchTrigrams(record.Notes)...)
+}
+
+// checkAlias - проверка, что псевдоним свободен и ссылка еще не сокращена, вызывается под блокировкой.
+func (s *StorageInMemory) checkAlias(value string, alias string, userUID string) (string, error) {
+	if existing, exists := s.data[alias]; exists {
+		// Повторная отправка той же ссылки тем же пользователем - обычный конфликт
+		if existing.OriginalURL == value && existing.UserUID == userUID {
+			return alias, NewUniqURLError(value, alias)
+		}
+		return alias, NewAliasTakenError(alias)
+	}
+	// Ссылка уже сокращена под другим кодом
+	if existKey, exists := s.originals[value]; exists {
+		return existKey, NewUniqURLError(value, existKey)
+	}
+	return alias, nil
 }
 
 // Get - чтение ссылки.
-func (s *StorageInMemory) Get(hashKey string) (string, bool) {
+func (s *StorageInMemory) Get(ctx context.Context, hashKey string) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	value, exists := s.data[hashKey]
-	parts := strings.Split(value, "|")
-	return parts[0], exists
+	record, exists := s.data[hashKey]
+	if !exists {
+		return "", false
+	}
+	return record.OriginalURL, true
+}
+
+// GetLink - чтение ссылки со всеми полями.
+func (s *StorageInMemory) GetLink(ctx context.Context, hashKey string) (ShortHashURL, error) {
+	if err := ctx.Err(); err != nil {
+		return ShortHashURL{}, NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, exists := s.data[hashKey]
+	if !exists {
+		return ShortHashURL{}, NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrNotFound))
+	}
+	return record.toShortHashURL(hashKey, time.Now()), nil
 }
 
-// FindByUserUID - поиск ссылок по пользовательскому UID.
-func (s *StorageInMemory) FindByUserUID(userUID string) ([]ShortHashURL, error) {
+// FindByUserUID - поиск ссылок по пользовательскому UID через индекс пользователей.
+func (s *StorageInMemory) FindByUserUID(ctx context.Context, userUID string) ([]ShortHashURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	var output []ShortHashURL
 
-	for shortHash, originURLwithUserUID := range s.data {
-		if strings.HasSuffix(originURLwithUserUID, userUID) {
-			parts := strings.Split(originURLwithUserUID, "|")
-			output = append(output, ShortHashURL{ShortHash: shortHash, OriginalURL: parts[0]})
-		}
+	now := time.Now()
+	for shortHash := range s.users[userUID] {
+		output = append(output, s.data[shortHash].toShortHashURL(shortHash, now))
 	}
 
 	return output, nil
 }
 
+// QueryByUser - страница ссылок пользователя, выборка идет по индексу пользователей.
+func (s *StorageInMemory) QueryByUser(ctx context.Context, userUID string, query URLQuery) (URLPage, error) {
+	urls, err := s.FindByUserUID(ctx, userUID)
+	if err != nil {
+		return URLPage{}, err
+	}
+	return QueryURLs(urls, query)
+}
+
 // LoadData загрузка данных из файла
-func (s *StorageInMemory) LoadData(pathToFile string) int {
+// Файл содержит снимок хранилища и дописанный после него журнал изменений, записи применяются по порядку.
+func (s *StorageInMemory) LoadData(ctx context.Context, pathToFile string) (int, error) {
 	count := 0
 	consumer, err := NewConsumer(pathToFile)
 	if err != nil {
-		log.Print(err)
+		return count, NewStorageError(err)
 	}
 	defer consumer.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for {
+		if err := ctx.Err(); err != nil {
+			return count, NewStorageError(err)
+		}
 		shortURL, err := consumer.ReadShortURL()
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				// Записи до поврежденной (например, недописанной при сбое) уже применены
+				return count, NewStorageError(err)
 			}
+			break
+		}
+		if shortURL.Operation == OperationDelete {
+			// Удаление из журнала прежних версий, ссылки тогда удалялись безвозвратно
+			s.unapply(shortURL.ShortURL)
+		} else {
+			s.apply(shortURL.ShortURL, recordFromShortURL(shortURL))
 		}
-		s.data[shortURL.ShortURL] = shortURL.OriginalURL
 		count += 1
 	}
-	return count
+	return count, nil
 }
 
 // SaveData сохранение данных в файл
-func (s *StorageInMemory) SaveData(pathToFile string) int {
-	producer, err := NewProducer(pathToFile)
+// Снимок пишется во временный файл и атомарно заменяет прежний, журнал после этого начинается заново.
+func (s *StorageInMemory) SaveData(ctx context.Context, pathToFile string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count, err := s.writeSnapshot(pathToFile)
+	if err != nil {
+		return count, NewStorageError(err)
+	}
+	return count, nil
+}
+
+// writeSnapshot - запись всех ссылок в файл, вызывается под блокировкой.
+func (s *StorageInMemory) writeSnapshot(pathToFile string) (int, error) {
 	count := 0
+	tmpPath := pathToFile + ".tmp"
+	producer, err := NewProducer(tmpPath)
 	if err != nil {
-		log.Print(err)
+		return count, err
 	}
-	defer producer.Close()
 
-	for shortURL, originURL := range s.data {
-		newShortURL := ShortURL{
-			UUID: shortURL, OriginalURL: originURL, ShortURL: shortURL,
-		}
-		if err := producer.WriteShortURL(&newShortURL); err != nil {
-			log.Print(err)
+	for hashKey, record := range s.data {
+		shortURL := record.toShortURL(hashKey)
+		if err := producer.WriteShortURL(&shortURL); err != nil {
+			producer.Close()
+			return count, err
 		}
 		count += 1
 	}
-	return count
+	if err := producer.Sync(); err != nil {
+		producer.Close()
+		return count, err
+	}
+	if err := producer.Close(); err != nil {
+		return count, err
+	}
+	if err := os.Rename(tmpPath, pathToFile); err != nil {
+		return count, err
+	}
+	if err := syncDir(pathToFile); err != nil {
+		return count, err
+	}
+	// Журнал указывал на замененный файл, открываем его заново
+	if s.journal != nil && s.journalPath == pathToFile {
+		if err := s.reopenJournal(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
 }
 
 // CorrelationSave - сохранение данных (ссылка и идентификатор)
-func (s *StorageInMemory) CorrelationSave(value string, correlationID string, userUID string) string {
+// Идентификатор становится кодом ссылки, поэтому занятый код не перезаписывается.
+func (s *StorageInMemory) CorrelationSave(ctx context.Context, value string, correlationID string, userUID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", NewStorageError(err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[correlationID] = fmt.Sprintf("%s|%s", value, userUID)
-	return correlationID
+	if existKey, err := s.checkAlias(value, correlationID, userUID); err != nil {
+		return existKey, err
+	}
+	if err := s.checkLimits(userUID, 1); err != nil {
+		return "", err
+	}
+	if err := s.put(correlationID, newMemoryRecord(value, userUID, LinkOptions{})); err != nil {
+		return correlationID, NewStorageError(err)
+	}
+	return correlationID, nil
 }
 
 // CorrelationGet - чтение данных (ссылка и идентификатор)
-func (s *StorageInMemory) CorrelationGet(correlationID string) (string, bool) {
+func (s *StorageInMemory) CorrelationGet(ctx context.Context, correlationID string) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	value, exists := s.data[correlationID]
-	return value, exists
+	record, exists := s.data[correlationID]
+	if !exists {
+		return "", false
+	}
+	return fmt.Sprintf("%s|%s", record.OriginalURL, record.UserUID), true
 }
 
-// CorrelationSave - сохранение данных (ссылок и идентификатор)
-func (s *StorageInMemory) CorrelationsSave(correlationURLs []CorrelationURL, userUID string) ([]string, error) {
+// CorrelationsSave - сохранение данных (ссылок и идентификатор)
+// Пакет сохраняется целиком: при занятом коде или уже сокращенной ссылке не сохраняется ничего.
+func (s *StorageInMemory) CorrelationsSave(ctx context.Context, correlationURLs []CorrelationURL, userUID string) ([]string, error) {
 
-	var output []string
+	if err := ctx.Err(); err != nil {
+		return nil, NewStorageError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	aliases := make(map[string]string, len(correlationURLs))
+	values := make(map[string]string, len(correlationURLs))
+	for _, value := range correlationURLs {
+		if _, err := s.checkAlias(value.OriginalURL, value.CorrelationID, userUID); err != nil {
+			return nil, err
+		}
+		// Повторы внутри самого пакета
+		if _, exists := aliases[value.CorrelationID]; exists {
+			return nil, NewAliasTakenError(value.CorrelationID)
+		}
+		if existKey, exists := values[value.OriginalURL]; exists {
+			return nil, NewUniqURLError(value.OriginalURL, existKey)
+		}
+		aliases[value.CorrelationID] = value.OriginalURL
+		values[value.OriginalURL] = value.CorrelationID
+	}
+	if err := s.checkLimits(userUID, len(correlationURLs)); err != nil {
+		return nil, err
+	}
 
+	var output []string
 	for _, value := range correlationURLs {
+		if err := s.put(value.CorrelationID, newMemoryRecord(value.OriginalURL, userUID, value.Options)); err != nil {
+			return output, NewStorageError(err)
+		}
 		output = append(output, value.CorrelationID)
-		s.CorrelationSave(value.OriginalURL, value.CorrelationID, userUID)
 	}
 
 	return output, nil
 }
 
 // IsDeleted - удалена ли ссылка.
-func (s *StorageInMemory) IsDeleted(hashKey string) (bool, error) {
-	// TODO нет реализации
-	return false, nil
+func (s *StorageInMemory) IsDeleted(ctx context.Context, hashKey string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, exists := s.data[hashKey]
+	if !exists {
+		return false, nil
+	}
+	return record.Deleted, nil
+}
+
+// MarkExpired - помечает просроченными все ссылки со сроком действия до now.
+func (s *StorageInMemory) MarkExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for hashKey, record := range s.data {
+		if !record.Expired && record.isExpired(now) {
+			updated := *record
+			updated.Expired = true
+			if err := s.writeJournal(hashKey, &updated); err != nil {
+				return count, NewStorageError(err)
+			}
+			record.Expired = true
+			count += 1
+		}
+	}
+	return count, nil
 }
 
 // DeleteByUser - удалить ссылку по пользовательскому UUID
-func (s *StorageInMemory) DeleteByUser(shortHashURL []string, userUID string) error {
+// Ссылка помечается удаленной и остается в хранилище, чтобы ее можно было восстановить.
+func (s *StorageInMemory) DeleteByUser(ctx context.Context, shortHashURL []string, userUID string) error {
+	return s.setDeleted(ctx, shortHashURL, userUID, true)
+}
+
+// RestoreByUser - восстановить удаленные ссылки по пользовательскому UUID
+func (s *StorageInMemory) RestoreByUser(ctx context.Context, shortHashURL []string, userUID string) error {
+	return s.setDeleted(ctx, shortHashURL, userUID, false)
+}
+
+// UpdateByUser - изменение ссылки владельцем одной записью журнала, короткий код сохраняется.
+// Если новая ссылка уже сокращена под другим кодом, возвращается UniqURLError с этим кодом, удаленная ссылка не меняется.
+func (s *StorageInMemory) UpdateByUser(ctx context.Context, hashKey string, patch LinkPatch, userUID string) error {
+	if err := ctx.Err(); err != nil {
+		return NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, exists := s.data[hashKey]
+	if !exists || record.UserUID != userUID {
+		return NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrNotFound))
+	}
+	if record.Deleted {
+		return NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrDeleted))
+	}
+	if patch.OriginalURL != "" && patch.OriginalURL != record.OriginalURL {
+		if existKey, exists := s.originals[patch.OriginalURL]; exists {
+			return NewUniqURLError(patch.OriginalURL, existKey)
+		}
+	}
+	updated := *record
+	updated.applyPatch(patch)
+	if err := s.put(hashKey, &updated); err != nil {
+		return NewStorageError(err)
+	}
+	return nil
+}
+
+// applyPatch - применение изменений владельца к записи.
+func (record *memoryRecord) applyPatch(patch LinkPatch) {
+	if patch.OriginalURL != "" {
+		record.OriginalURL = patch.OriginalURL
+	}
+	if !patch.ExpiresAt.IsZero() {
+		record.ExpiresAt = patch.ExpiresAt
+		record.Expired = false
+	}
+	if patch.Tags != nil {
+		record.Tags = slices.Clone(*patch.Tags)
+	}
+	if patch.Preview != nil {
+		record.Preview = *patch.Preview
+	}
+	if patch.RedirectType != nil {
+		record.RedirectType = *patch.RedirectType
+	}
+	if patch.QueryRules != nil {
+		record.QueryRules = patch.linkQueryRules()
+	}
+	if patch.Notes != nil {
+		record.Notes = *patch.Notes
+	}
+}
+
+// SearchByUser - поиск ссылок пользователя, в которые входит каждое слово запроса.
+// Кандидаты отбираются по триграммам слов в индексе пользователя, затем слова проверяются целиком.
+func (s *StorageInMemory) SearchByUser(ctx context.Context, userUID string, query string, limit int) ([]ShortHashURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	words := searchWords(query)
+	userTrigrams := s.trigrams[userUID]
+	var candidates map[string]struct{}
+	for _, word := range words {
+		for _, trigram := range searchTrigrams(word) {
+			matched := make(map[string]struct{})
+			for hashKey := range userTrigrams[trigram] {
+				if _, exists := candidates[hashKey]; candidates == nil || exists {
+					matched[hashKey] = struct{}{}
+				}
+			}
+			candidates = matched
+			if len(candidates) == 0 {
+				return nil, nil
+			}
+		}
+	}
+	// Все слова короче трех символов, проверяются все ссылки пользователя
+	if candidates == nil {
+		candidates = s.users[userUID]
+	}
+
+	var output []ShortHashURL
+	now := time.Now()
+	for hashKey := range candidates {
+		record := s.data[hashKey]
+		if record.Deleted || !matchSearch(words, record.OriginalURL, hashKey, record.Notes) {
+			continue
+		}
+		output = append(output, record.toShortHashURL(hashKey, now))
+	}
+	return limitSearchResults(output, limit), nil
+}
+
+// setDeleted - установка признака удаления ссылок владельца.
+func (s *StorageInMemory) setDeleted(ctx context.Context, shortHashURL []string, userUID string, deleted bool) error {
+	if err := ctx.Err(); err != nil {
+		return NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	for _, hash := range shortHashURL {
-		if value, exists := s.data[hash]; exists {
-			parts := strings.Split(value, "|")
-			if len(parts) == 2 && parts[1] == userUID {
-				delete(s.data, hash) // Удаляем ключ
+		if record, exists := s.data[hash]; exists && record.UserUID == userUID && record.Deleted != deleted {
+			updated := *record
+			updated.Deleted = deleted
+			if err := s.writeJournal(hash, &updated); err != nil {
+				return NewStorageError(err)
 			}
+			record.Deleted = deleted
 		}
 	}
-	// TODO продумать какое исключение передавать
 	return nil
 }
 
 // Close - освобождение ресурсов
 func (s *StorageInMemory) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
 	s.data = nil
+	s.originals = nil
+	s.users = nil
+	s.trigrams = nil
 }
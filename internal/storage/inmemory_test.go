@@ -2,8 +2,12 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -21,10 +25,10 @@ func TestCreateURL(t *testing.T) {
 
 	userUID := uuid.New().String()
 	targetHash := "77fca5950e"
-	shortString, _ := inMemoryStorage.Save("https://yandex.ru/", userUID)
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
 	assert.Equal(t, shortString, targetHash)
 
-	result, found := inMemoryStorage.Get(targetHash)
+	result, found := inMemoryStorage.Get(context.Background(), targetHash)
 	assert.True(t, found)
 	assert.Equal(t, "https://yandex.ru/", result)
 }
@@ -36,14 +40,112 @@ func TestDeleteURL(t *testing.T) {
 	defer inMemoryStorage.Close()
 
 	userUID := uuid.New().String()
-	shortString, _ := inMemoryStorage.Save("https://yandex.ru/", userUID)
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
 	targetHash := "77fca5950e"
 	assert.Equal(t, shortString, targetHash)
 
-	inMemoryStorage.DeleteByUser([]string{targetHash}, userUID)
-	_, found := inMemoryStorage.Get(targetHash)
-	assert.False(t, found)
+	// Чужая ссылка не удаляется
+	inMemoryStorage.DeleteByUser(context.Background(), []string{targetHash}, uuid.New().String())
+	deleted, _ := inMemoryStorage.IsDeleted(context.Background(), targetHash)
+	assert.False(t, deleted)
+
+	inMemoryStorage.DeleteByUser(context.Background(), []string{targetHash}, userUID)
+	_, found := inMemoryStorage.Get(context.Background(), targetHash)
+	assert.True(t, found)
+	deleted, _ = inMemoryStorage.IsDeleted(context.Background(), targetHash)
+	assert.True(t, deleted)
+
+	inMemoryStorage.RestoreByUser(context.Background(), []string{targetHash}, userUID)
+	deleted, _ = inMemoryStorage.IsDeleted(context.Background(), targetHash)
+	assert.False(t, deleted)
+
+}
+
+// TestUpdateURL - тестирование замены оригинальной ссылки.
+func TestUpdateURL(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+
+	ctx := context.Background()
+	userUID := uuid.New().String()
+	shortString, _ := inMemoryStorage.Save(ctx, "https://yandex.ru/", userUID)
+	otherShort, _ := inMemoryStorage.Save(ctx, "https://google.ru/", userUID)
+
+	// Чужая ссылка не меняется
+	assert.Error(t, inMemoryStorage.UpdateByUser(ctx, shortString, LinkPatch{OriginalURL: "https://ya.ru/"}, uuid.New().String()))
+	assert.Error(t, inMemoryStorage.UpdateByUser(ctx, "unknown", LinkPatch{OriginalURL: "https://ya.ru/"}, userUID))
+
+	// Ссылка уже сокращена под другим кодом
+	err := inMemoryStorage.UpdateByUser(ctx, shortString, LinkPatch{OriginalURL: "https://google.ru/"}, userUID)
+	var ue *UniqURLError
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, otherShort, ue.ShortHash)
 
+	assert.NoError(t, inMemoryStorage.UpdateByUser(ctx, shortString, LinkPatch{OriginalURL: "https://ya.ru/"}, userUID))
+	result, found := inMemoryStorage.Get(ctx, shortString)
+	assert.True(t, found)
+	assert.Equal(t, "https://ya.ru/", result)
+
+	// Прежняя ссылка освобождается и сокращается заново
+	_, err = inMemoryStorage.Save(ctx, "https://yandex.ru/", userUID)
+	assert.NoError(t, err)
+	_, err = inMemoryStorage.Save(ctx, "https://ya.ru/", userUID)
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, shortString, ue.ShortHash)
+}
+
+// TestUpdatePatch - изменения ссылки применяются вместе или не применяются, удаленная ссылка не меняется.
+func TestUpdatePatch(t *testing.T) {
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+	diskStorage, err := NewStorageOnDisk(filepath.Join(t.TempDir(), "urls.log"), testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+
+	for name, mainStorage := range map[string]Storage{"memory": inMemoryStorage, "disk": diskStorage} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			userUID := uuid.New().String()
+			shortString, _ := mainStorage.Save(ctx, "https://yandex.ru/"+name, userUID)
+			mainStorage.Save(ctx, "https://google.ru/"+name, userUID)
+			preview, redirectType, tags := true, 301, []string{"work"}
+			expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+			rules := QueryRules{Params: map[string]string{"utm_source": "flyer"}}
+
+			// Конфликт ссылки отменяет и остальные изменения
+			err := mainStorage.UpdateByUser(ctx, shortString,
+				LinkPatch{OriginalURL: "https://google.ru/" + name, Tags: &tags, Preview: &preview}, userUID)
+			var ue *UniqURLError
+			assert.ErrorAs(t, err, &ue)
+			link, _ := mainStorage.GetLink(ctx, shortString)
+			assert.Equal(t, "https://yandex.ru/"+name, link.OriginalURL)
+			assert.Empty(t, link.Tags)
+			assert.False(t, link.Preview)
+
+			patch := LinkPatch{ExpiresAt: expiresAt, Tags: &tags, Preview: &preview, RedirectType: &redirectType, QueryRules: &rules}
+			assert.NoError(t, mainStorage.UpdateByUser(ctx, shortString, patch, userUID))
+			link, _ = mainStorage.GetLink(ctx, shortString)
+			assert.Equal(t, "https://yandex.ru/"+name, link.OriginalURL)
+			assert.True(t, expiresAt.Equal(link.ExpiresAt))
+			assert.Equal(t, tags, link.Tags)
+			assert.True(t, link.Preview)
+			assert.Equal(t, redirectType, link.RedirectType)
+			assert.Equal(t, &rules, link.QueryRules)
+
+			// Пустые правила возвращают общие правила
+			assert.NoError(t, mainStorage.UpdateByUser(ctx, shortString, LinkPatch{QueryRules: &QueryRules{}}, userUID))
+			link, _ = mainStorage.GetLink(ctx, shortString)
+			assert.Nil(t, link.QueryRules)
+			assert.True(t, link.Preview)
+
+			assert.NoError(t, mainStorage.DeleteByUser(ctx, []string{shortString}, userUID))
+			err = mainStorage.UpdateByUser(ctx, shortString, LinkPatch{OriginalURL: "https://ya.ru/" + name}, userUID)
+			assert.ErrorIs(t, err, ErrDeleted)
+			result, _ := mainStorage.Get(ctx, shortString)
+			assert.Equal(t, "https://yandex.ru/"+name, result)
+		})
+	}
 }
 
 // TestFindURL - тестирование поиск ссылки.
@@ -53,12 +155,28 @@ func TestFindURL(t *testing.T) {
 	defer inMemoryStorage.Close()
 
 	userUID := uuid.New().String()
-	shortString, _ := inMemoryStorage.Save("https://yandex.ru/", userUID)
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
 	assert.Equal(t, shortString, "77fca5950e")
 
-	result, err := inMemoryStorage.FindByUserUID(userUID)
+	inMemoryStorage.Save(context.Background(), "https://google.ru/", uuid.New().String())
+
+	result, err := inMemoryStorage.FindByUserUID(context.Background(), userUID)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(result))
+	assert.False(t, result[0].CreatedAt.IsZero())
+
+	// Время создания сохраняется в файле
+	pathToFile := filepath.Join(t.TempDir(), "urls.db")
+	_, err = inMemoryStorage.SaveData(context.Background(), pathToFile)
+	assert.NoError(t, err)
+	loaded, _ := NewStorageInMemory(testLengthShortURL)
+	defer loaded.Close()
+	_, err = loaded.LoadData(context.Background(), pathToFile)
+	assert.NoError(t, err)
+	loadedResult, err := loaded.FindByUserUID(context.Background(), userUID)
+	assert.NoError(t, err)
+	assert.Len(t, loadedResult, 1)
+	assert.True(t, result[0].CreatedAt.Equal(loadedResult[0].CreatedAt))
 }
 
 // TestCorrelationSaveGet - тестирование записи и чтения ссылок.
@@ -69,10 +187,16 @@ func TestCorrelationSaveGet(t *testing.T) {
 
 	userUID, correlationID := uuid.New().String(), uuid.New().String()
 
-	correlationResult := inMemoryStorage.CorrelationSave("https://yandex.ru/", correlationID, userUID)
+	correlationResult, err := inMemoryStorage.CorrelationSave(context.Background(), "https://yandex.ru/", correlationID, userUID)
+	assert.NoError(t, err)
 	assert.Equal(t, correlationID, correlationResult)
 
-	result, found := inMemoryStorage.CorrelationGet(correlationID)
+	// Занятый идентификатор другой пользователь не перезаписывает
+	_, err = inMemoryStorage.CorrelationSave(context.Background(), "https://evil.com/", correlationID, uuid.New().String())
+	var ae *AliasTakenError
+	assert.ErrorAs(t, err, &ae)
+
+	result, found := inMemoryStorage.CorrelationGet(context.Background(), correlationID)
 	assert.True(t, found)
 	assert.Equal(t, fmt.Sprintf("%s|%s", "https://yandex.ru/", userUID), result)
 }
@@ -96,10 +220,22 @@ func TestCorrelationsSaveGet(t *testing.T) {
 		},
 	}
 
-	correlationResults, err := inMemoryStorage.CorrelationsSave(inputs, userUID)
+	correlationResults, err := inMemoryStorage.CorrelationsSave(context.Background(), inputs, userUID)
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(correlationResults))
 
+	// Пакет с занятым кодом не сохраняется целиком
+	hijack := []CorrelationURL{
+		{CorrelationID: "fresh", OriginalURL: "https://mail.ru/"},
+		{CorrelationID: inputs[0].CorrelationID, OriginalURL: "https://evil.com/"},
+	}
+	_, err = inMemoryStorage.CorrelationsSave(context.Background(), hijack, uuid.New().String())
+	var ae *AliasTakenError
+	assert.ErrorAs(t, err, &ae)
+	_, found := inMemoryStorage.Get(context.Background(), "fresh")
+	assert.False(t, found)
+	originalURL, _ := inMemoryStorage.Get(context.Background(), inputs[0].CorrelationID)
+	assert.Equal(t, "https://yandex.ru/", originalURL)
 }
 
 // TestLoadSave - тесты записи и чтения хранилища в файле.
@@ -109,9 +245,175 @@ func TestLoadSave(t *testing.T) {
 	defer inMemoryStorage.Close()
 	pathToFile := "noExist.db"
 
-	countLoadRecords := inMemoryStorage.LoadData(pathToFile)
+	countLoadRecords, err := inMemoryStorage.LoadData(context.Background(), pathToFile)
+	assert.NoError(t, err)
 	assert.Equal(t, 0, countLoadRecords)
 
-	countSaveRecords := inMemoryStorage.SaveData(pathToFile)
+	countSaveRecords, err := inMemoryStorage.SaveData(context.Background(), pathToFile)
+	assert.NoError(t, err)
 	assert.Equal(t, 0, countSaveRecords)
 }
+
+// TestSaveLinkWithAlias - тестирование сохранения ссылки под псевдонимом.
+func TestSaveLinkWithAlias(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+
+	userUID, otherUserUID := uuid.New().String(), uuid.New().String()
+
+	shortString, err := inMemoryStorage.SaveLink(context.Background(), "https://yandex.ru/", "spring-sale", userUID, LinkOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "spring-sale", shortString)
+
+	result, found := inMemoryStorage.Get(context.Background(), "spring-sale")
+	assert.True(t, found)
+	assert.Equal(t, "https://yandex.ru/", result)
+
+	// Повторное сохранение той же ссылки владельцем
+	_, err = inMemoryStorage.SaveLink(context.Background(), "https://yandex.ru/", "spring-sale", userUID, LinkOptions{})
+	var ue *UniqURLError
+	assert.ErrorAs(t, err, &ue)
+
+	// Псевдоним занят другим пользователем
+	_, err = inMemoryStorage.SaveLink(context.Background(), "https://google.ru/", "spring-sale", otherUserUID, LinkOptions{})
+	var ae *AliasTakenError
+	assert.ErrorAs(t, err, &ae)
+	assert.Equal(t, "spring-sale", ae.Alias)
+}
+
+// TestIsValidAlias - тестирование проверки псевдонимов.
+func TestIsValidAlias(t *testing.T) {
+	assert.True(t, IsValidAlias("spring-sale"))
+	assert.True(t, IsValidAlias("Promo_2024"))
+	assert.False(t, IsValidAlias(""))
+	assert.False(t, IsValidAlias("with space"))
+	assert.False(t, IsValidAlias("api"))
+	assert.False(t, IsValidAlias("a/b"))
+}
+
+// TestExpiration - тестирование срока действия ссылок.
+func TestExpiration(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+
+	userUID := uuid.New().String()
+	shortString, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+
+	link, err := inMemoryStorage.GetLink(context.Background(), shortString)
+	assert.NoError(t, err)
+	assert.False(t, link.Expired)
+
+	expiresAt := time.Now().Add(time.Hour)
+	patch := LinkPatch{ExpiresAt: expiresAt}
+	assert.NoError(t, inMemoryStorage.UpdateByUser(context.Background(), shortString, patch, userUID))
+	assert.Error(t, inMemoryStorage.UpdateByUser(context.Background(), "NotExist", patch, userUID))
+	assert.Error(t, inMemoryStorage.UpdateByUser(context.Background(), shortString, patch, uuid.New().String()))
+
+	link, _ = inMemoryStorage.GetLink(context.Background(), shortString)
+	assert.False(t, link.Expired)
+
+	// Фоновая очистка после истечения срока
+	marked, err := inMemoryStorage.MarkExpired(context.Background(), expiresAt.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, marked)
+
+	link, _ = inMemoryStorage.GetLink(context.Background(), shortString)
+	assert.True(t, link.Expired)
+
+	result, _ := inMemoryStorage.FindByUserUID(context.Background(), userUID)
+	assert.Equal(t, 1, len(result))
+	assert.True(t, result[0].Expired)
+	assert.True(t, expiresAt.Equal(result[0].ExpiresAt))
+}
+
+// TestLoadSaveExpiration - тест сохранения срока действия в файл и обратной совместимости формата.
+func TestLoadSaveExpiration(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "storage.db")
+	userUID := uuid.New().String()
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	shortString, _ := inMemoryStorage.SaveLink(context.Background(), "https://yandex.ru/", "", userUID, LinkOptions{ExpiresAt: expiresAt})
+	countSaveRecords, err := inMemoryStorage.SaveData(context.Background(), pathToFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, countSaveRecords)
+
+	// Запись в старом формате: originURL | userUUID
+	file, err := os.OpenFile(pathToFile, os.O_APPEND|os.O_WRONLY, 0666)
+	assert.NoError(t, err)
+	file.WriteString("{\"uuid\":\"old\",\"short_url\":\"old\",\"original_url\":\"https://google.ru/|" + userUID + "\"}\n")
+	file.Close()
+
+	loadedStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer loadedStorage.Close()
+	countLoadRecords, err := loadedStorage.LoadData(context.Background(), pathToFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, countLoadRecords)
+
+	result, found := loadedStorage.Get(context.Background(), "old")
+	assert.True(t, found)
+	assert.Equal(t, "https://google.ru/", result)
+
+	urls, _ := loadedStorage.FindByUserUID(context.Background(), userUID)
+	assert.Equal(t, 2, len(urls))
+	for _, url := range urls {
+		if url.ShortHash == shortString {
+			assert.True(t, expiresAt.Equal(url.ExpiresAt))
+		}
+	}
+}
+
+// TestCanceledContext - операции с отмененным контекстом не изменяют хранилище.
+func TestCanceledContext(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	userUID := uuid.New().String()
+	_, err := inMemoryStorage.Save(ctx, "https://yandex.ru/", userUID)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, found := inMemoryStorage.Get(context.Background(), "77fca5950e")
+	assert.False(t, found)
+
+	_, err = inMemoryStorage.FindByUserUID(ctx, userUID)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// TestSaveLink - признаки сохраняются одной записью со ссылкой, в том числе в пакете.
+func TestSaveLink(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+
+	ctx := context.Background()
+	userUID := uuid.New().String()
+	options := LinkOptions{PasswordHash: "hash", Tags: []string{"work"}, RedirectType: 308}
+
+	shortString, err := inMemoryStorage.SaveLink(ctx, "https://yandex.ru/", "", userUID, options)
+	assert.NoError(t, err)
+	_, err = inMemoryStorage.CorrelationsSave(ctx, []CorrelationURL{{CorrelationID: "batch-1", OriginalURL: "https://google.ru/",
+		Options: options}}, userUID)
+	assert.NoError(t, err)
+
+	for _, hashKey := range []string{shortString, "batch-1"} {
+		link, err := inMemoryStorage.GetLink(ctx, hashKey)
+		assert.NoError(t, err)
+		assert.Equal(t, "hash", link.PasswordHash)
+		assert.Equal(t, []string{"work"}, link.Tags)
+		assert.Equal(t, 308, link.RedirectType)
+	}
+
+	// Занятый псевдоним не перезаписывается вместе с признаками
+	_, err = inMemoryStorage.SaveLink(ctx, "https://evil.com/", "batch-1", uuid.New().String(), LinkOptions{})
+	var ae *AliasTakenError
+	assert.ErrorAs(t, err, &ae)
+	link, _ := inMemoryStorage.GetLink(ctx, "batch-1")
+	assert.Equal(t, "hash", link.PasswordHash)
+}
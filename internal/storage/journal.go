@@ -0,0 +1,102 @@
+// Модуль содержит журнал изменений хранилища в памяти.
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+)
+
+// EnableJournal - включает дозапись каждого изменения хранилища в файл.
+// После сбоя процесса LoadData восстанавливает состояние из снимка и журнала в этом файле.
+// Недописанная при сбое запись в конце файла отбрасывается, иначе дозапись продолжилась бы после нее.
+func (s *StorageInMemory) EnableJournal(pathToFile string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.journalPath = pathToFile
+	if err := truncateTornTail(pathToFile); err != nil {
+		return NewStorageError(err)
+	}
+	return s.reopenJournal()
+}
+
+// truncateTornTail - обрезка файла журнала до последней целой записи.
+func truncateTornTail(pathToFile string) error {
+	file, err := os.OpenFile(pathToFile, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	var offset int64 // конец последней целой записи
+	for {
+		var shortURL ShortURL
+		err := decoder.Decode(&shortURL)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			break
+		}
+		offset = decoder.InputOffset()
+	}
+	log.Printf("Journal is truncated at offset %d", offset)
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	// Перевод строки после последней записи, чтобы дозапись начиналась с новой строки
+	if offset > 0 {
+		if _, err := file.WriteAt([]byte("\n"), offset); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// Compact - переписывает журнал снимком текущего состояния, если в нем есть новые записи.
+func (s *StorageInMemory) Compact() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil || s.journalOps == 0 {
+		return 0, nil
+	}
+	return s.writeSnapshot(s.journalPath)
+}
+
+// reopenJournal - открывает файл журнала на дозапись, вызывается под блокировкой.
+func (s *StorageInMemory) reopenJournal() error {
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+	journal, err := NewAppendProducer(s.journalPath)
+	if err != nil {
+		return NewStorageError(err)
+	}
+	s.journal = journal
+	s.journalOps = 0
+	return nil
+}
+
+// writeJournal - дозапись сохранения ссылки в журнал, вызывается под блокировкой.
+func (s *StorageInMemory) writeJournal(hashKey string, record *memoryRecord) error {
+	if s.journal == nil {
+		return nil
+	}
+	shortURL := record.toShortURL(hashKey)
+	shortURL.Operation = OperationSave
+	return s.appendJournal(&shortURL)
+}
+
+// appendJournal - запись в журнал, изменение без записи в журнал не применяется и возвращает ошибку.
+func (s *StorageInMemory) appendJournal(shortURL *ShortURL) error {
+	if err := s.journal.WriteShortURL(shortURL); err != nil {
+		log.Printf("Journal write error: %s", err)
+		return err
+	}
+	s.journalOps += 1
+	return nil
+}
@@ -0,0 +1,158 @@
+// Модуль содержит тесты журнала изменений хранилища в памяти
+package storage
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// countLines - количество записей в файле хранилища.
+func countLines(t *testing.T, pathToFile string) int {
+	file, err := os.Open(pathToFile)
+	assert.NoError(t, err)
+	defer file.Close()
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		count += 1
+	}
+	return count
+}
+
+// TestJournalReplay - восстановление хранилища из журнала без штатного сохранения.
+func TestJournalReplay(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "storage.db")
+	userUID := uuid.New().String()
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	assert.NoError(t, inMemoryStorage.EnableJournal(pathToFile))
+
+	yandexHash, _ := inMemoryStorage.Save(context.Background(), "https://yandex.ru/", userUID)
+	googleHash, _ := inMemoryStorage.Save(context.Background(), "https://google.ru/", userUID)
+	inMemoryStorage.CorrelationSave(context.Background(), "https://mail.ru/", "correlation", userUID)
+	inMemoryStorage.UpdateByUser(context.Background(), googleHash, LinkPatch{ExpiresAt: expiresAt}, userUID)
+	inMemoryStorage.DeleteByUser(context.Background(), []string{yandexHash}, userUID)
+	assert.Equal(t, 5, countLines(t, pathToFile))
+
+	// Аварийное завершение: SaveData не вызывается
+	restoredStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer restoredStorage.Close()
+	restoredStorage.LoadData(context.Background(), pathToFile)
+
+	_, found := restoredStorage.Get(context.Background(), yandexHash)
+	assert.True(t, found)
+	deleted, _ := restoredStorage.IsDeleted(context.Background(), yandexHash)
+	assert.True(t, deleted)
+	result, found := restoredStorage.Get(context.Background(), googleHash)
+	assert.True(t, found)
+	assert.Equal(t, "https://google.ru/", result)
+	result, found = restoredStorage.Get(context.Background(), "correlation")
+	assert.True(t, found)
+	assert.Equal(t, "https://mail.ru/", result)
+
+	urls, _ := restoredStorage.FindByUserUID(context.Background(), userUID)
+	assert.Equal(t, 3, len(urls))
+	for _, url := range urls {
+		if url.ShortHash == googleHash {
+			assert.True(t, expiresAt.Equal(url.ExpiresAt))
+		}
+	}
+
+	// Удаленная ссылка восстанавливается владельцем
+	assert.NoError(t, restoredStorage.RestoreByUser(context.Background(), []string{yandexHash}, userUID))
+	deleted, _ = restoredStorage.IsDeleted(context.Background(), yandexHash)
+	assert.False(t, deleted)
+
+	// Сжатие журнала оставляет только актуальные записи, дозапись продолжается
+	compacted, err := inMemoryStorage.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, compacted)
+	assert.Equal(t, 3, countLines(t, pathToFile))
+
+	inMemoryStorage.Save(context.Background(), "https://ya.ru/", userUID)
+	assert.Equal(t, 4, countLines(t, pathToFile))
+
+	compacted, err = inMemoryStorage.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, compacted)
+	compacted, _ = inMemoryStorage.Compact()
+	assert.Equal(t, 0, compacted)
+	inMemoryStorage.Close()
+}
+
+// TestJournalTornTail - недописанная при сбое запись отбрасывается, дозапись после нее читается.
+func TestJournalTornTail(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "storage.db")
+	ctx := context.Background()
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	assert.NoError(t, inMemoryStorage.EnableJournal(pathToFile))
+	yandexHash, _ := inMemoryStorage.Save(ctx, "https://yandex.ru/", "user")
+	inMemoryStorage.Close()
+
+	// Сбой посреди записи
+	file, err := os.OpenFile(pathToFile, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"uuid":"torn","short_url":"to`)
+	assert.NoError(t, err)
+	file.Close()
+
+	restartedStorage, _ := NewStorageInMemory(testLengthShortURL)
+	loaded, err := restartedStorage.LoadData(ctx, pathToFile)
+	assert.Error(t, err)
+	assert.Equal(t, 1, loaded)
+	assert.NoError(t, restartedStorage.EnableJournal(pathToFile))
+	googleHash, err := restartedStorage.Save(ctx, "https://google.ru/", "user")
+	assert.NoError(t, err)
+	restartedStorage.Close()
+	assert.Equal(t, 2, countLines(t, pathToFile))
+
+	restoredStorage, _ := NewStorageInMemory(testLengthShortURL)
+	loaded, err = restoredStorage.LoadData(ctx, pathToFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, loaded)
+	for _, hashKey := range []string{yandexHash, googleHash} {
+		_, found := restoredStorage.Get(ctx, hashKey)
+		assert.True(t, found, hashKey)
+	}
+}
+
+// TestJournalWriteError - изменение, не записанное в журнал, не применяется и возвращает ошибку хранилища.
+func TestJournalWriteError(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "storage.db")
+	ctx := context.Background()
+	userUID := uuid.New().String()
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+	assert.NoError(t, inMemoryStorage.EnableJournal(pathToFile))
+	yandexHash, err := inMemoryStorage.Save(ctx, "https://yandex.ru/", userUID)
+	assert.NoError(t, err)
+	inMemoryStorage.journal.Close() // файл журнала стал недоступен для записи
+
+	var se *StorageError
+	_, err = inMemoryStorage.Save(ctx, "https://google.ru/", userUID)
+	assert.ErrorAs(t, err, &se)
+	result, _ := inMemoryStorage.FindByUserUID(ctx, userUID)
+	assert.Len(t, result, 1)
+
+	assert.ErrorAs(t, inMemoryStorage.DeleteByUser(ctx, []string{yandexHash}, userUID), &se)
+	deleted, _ := inMemoryStorage.IsDeleted(ctx, yandexHash)
+	assert.False(t, deleted)
+
+	tags := []string{"news"}
+	assert.ErrorAs(t, inMemoryStorage.UpdateByUser(ctx, yandexHash, LinkPatch{Tags: &tags}, userUID), &se)
+	link, _ := inMemoryStorage.GetLink(ctx, yandexHash)
+	assert.Empty(t, link.Tags)
+}
@@ -0,0 +1,58 @@
+// Модуль содержит ограничения количества ссылок пользователя.
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// LinkLimits - ограничения количества ссылок одного пользователя, 0 - без ограничения.
+// Удаленные ссылки учитываются, так как могут быть восстановлены.
+type LinkLimits struct {
+	Total int // всего ссылок
+	Daily int // ссылок, созданных за текущие сутки UTC
+}
+
+// enabled - задано ли хотя бы одно ограничение.
+func (l LinkLimits) enabled() bool {
+	return l.Total > 0 || l.Daily > 0
+}
+
+// check - проверка ограничений перед созданием requested ссылок при уже созданных count.
+func (l LinkLimits) check(count LinkCount, requested int) error {
+	if l.Total > 0 && count.Total+requested > l.Total {
+		return &LinkLimitError{Max: l.Total, Used: count.Total, Requested: requested}
+	}
+	if l.Daily > 0 && count.Daily+requested > l.Daily {
+		return &LinkLimitError{Daily: true, Max: l.Daily, Used: count.Daily, Requested: requested}
+	}
+	return nil
+}
+
+// LinkCount - количество ссылок пользователя.
+type LinkCount struct {
+	Total int // всего ссылок
+	Daily int // ссылок, созданных за текущие сутки UTC
+}
+
+// LinkLimitError - превышение ограничения количества ссылок пользователя.
+type LinkLimitError struct {
+	Daily     bool // превышено суточное ограничение, иначе общее
+	Max       int  // значение ограничения
+	Used      int  // создано до запроса
+	Requested int  // запрошено ссылок
+}
+
+// Error - реализация метода.
+func (le *LinkLimitError) Error() string {
+	limit := "total"
+	if le.Daily {
+		limit = "daily"
+	}
+	return fmt.Sprintf("%s link limit exceeded: used %d of %d, requested %d", limit, le.Used, le.Max, le.Requested)
+}
+
+// DayStart - начало суток UTC, к которым относится момент now.
+func DayStart(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,80 @@
+// Модуль содержит тесты ограничений количества ссылок пользователя
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLinkLimits - ограничения проверяются при вставке, пакет сверх ограничения не сохраняется целиком.
+func TestLinkLimits(t *testing.T) {
+
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	onDiskStorage, err := NewStorageOnDisk(filepath.Join(t.TempDir(), "urls.log"), testLengthShortURL)
+	assert.NoError(t, err)
+	defer onDiskStorage.Close()
+
+	ctx := context.Background()
+	for name, store := range map[string]PersistanceStorage{"memory": inMemoryStorage, "disk": onDiskStorage} {
+		store.SetLinkLimits(LinkLimits{Total: 3, Daily: 2})
+
+		_, err := store.Save(ctx, "https://yandex.ru/", "user")
+		assert.NoError(t, err, name)
+		// Повторная ссылка не считается новой
+		_, err = store.Save(ctx, "https://yandex.ru/", "user")
+		var ue *UniqURLError
+		assert.ErrorAs(t, err, &ue, name)
+
+		_, err = store.CorrelationsSave(ctx, []CorrelationURL{
+			{CorrelationID: "a1", OriginalURL: "https://a1.ru/"},
+			{CorrelationID: "a2", OriginalURL: "https://a2.ru/"},
+		}, "user")
+		var le *LinkLimitError
+		assert.ErrorAs(t, err, &le, name)
+		assert.Equal(t, LinkLimitError{Daily: true, Max: 2, Used: 1, Requested: 2}, *le, name)
+		_, found := store.Get(ctx, "a1")
+		assert.False(t, found, name)
+
+		_, err = store.SaveLink(ctx, "https://google.ru/", "alias", "user", LinkOptions{})
+		assert.NoError(t, err, name)
+		_, err = store.CorrelationSave(ctx, "https://mail.ru/", "c1", "user")
+		assert.ErrorAs(t, err, &le, name)
+
+		// Ограничения одного пользователя не затрагивают других
+		_, err = store.Save(ctx, "https://mail.ru/", "other")
+		assert.NoError(t, err, name)
+
+		count, err := store.CountByUser(ctx, "user")
+		assert.NoError(t, err, name)
+		assert.Equal(t, LinkCount{Total: 2, Daily: 2}, count, name)
+
+		store.SetLinkLimits(LinkLimits{Total: 2})
+		_, err = store.Save(ctx, "https://ya.ru/", "user")
+		assert.ErrorAs(t, err, &le, name)
+		assert.Equal(t, LinkLimitError{Max: 2, Used: 2, Requested: 1}, *le, name)
+	}
+}
+
+// TestLinkLimitsReopen - суточный счетчик считается по сохраненным ссылкам и переживает перезапуск.
+func TestLinkLimitsReopen(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "urls.log")
+	onDiskStorage, err := NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+	ctx := context.Background()
+	_, err = onDiskStorage.Save(ctx, "https://yandex.ru/", "user")
+	assert.NoError(t, err)
+	onDiskStorage.Close()
+
+	reopened, err := NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	reopened.SetLinkLimits(LinkLimits{Daily: 1})
+	_, err = reopened.Save(ctx, "https://google.ru/", "user")
+	var le *LinkLimitError
+	assert.ErrorAs(t, err, &le)
+	assert.True(t, le.Daily)
+}
@@ -0,0 +1,197 @@
+// Модуль содержит общие тесты хранения признаков ссылок во всех хранилищах
+package storage
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// linkMetadata - признак ссылки: сохранение вместе со ссылкой, сброс владельцем и поле ссылки.
+type linkMetadata struct {
+	name    string
+	options LinkOptions // признаки новой ссылки
+	reset   *LinkPatch  // изменение, сбрасывающее признак, nil - признак не меняется
+	field   func(link ShortHashURL) interface{}
+	value   interface{} // значение сохраненного признака
+	zero    interface{} // значение по умолчанию и после сброса
+}
+
+// testQueryRulesValue - правила параметров запроса для тестов хранения.
+func testQueryRulesValue() *QueryRules {
+	on := true
+	return &QueryRules{Passthrough: &on, Params: map[string]string{"utm_source": "short"}}
+}
+
+// Значения изменений, сбрасывающих признаки.
+var (
+	noTags      = []string{}
+	noPreview   = false
+	defaultType = 0
+	commonRules = QueryRules{}
+	noNotes     = ""
+)
+
+var linkMetadataCases = []linkMetadata{
+	{
+		name:    "tags",
+		options: LinkOptions{Tags: []string{"news", "work"}},
+		reset:   &LinkPatch{Tags: &noTags},
+		field:   func(link ShortHashURL) interface{} { return strings.Join(link.Tags, ",") },
+		value:   "news,work",
+		zero:    "",
+	},
+	{
+		name:    "preview",
+		options: LinkOptions{Preview: true},
+		reset:   &LinkPatch{Preview: &noPreview},
+		field:   func(link ShortHashURL) interface{} { return link.Preview },
+		value:   true,
+		zero:    false,
+	},
+	{
+		name:    "password",
+		options: LinkOptions{PasswordHash: "$2a$10$hash"},
+		field:   func(link ShortHashURL) interface{} { return link.PasswordHash },
+		value:   "$2a$10$hash",
+		zero:    "",
+	},
+	{
+		name:    "redirect type",
+		options: LinkOptions{RedirectType: http.StatusMovedPermanently},
+		reset:   &LinkPatch{RedirectType: &defaultType},
+		field:   func(link ShortHashURL) interface{} { return link.RedirectType },
+		value:   http.StatusMovedPermanently,
+		zero:    0,
+	},
+	{
+		name:    "query rules",
+		options: LinkOptions{QueryRules: testQueryRulesValue()},
+		reset:   &LinkPatch{QueryRules: &commonRules},
+		field:   func(link ShortHashURL) interface{} { return link.QueryRules },
+		value:   testQueryRulesValue(),
+		zero:    (*QueryRules)(nil),
+	},
+	{
+		name:    "notes",
+		options: LinkOptions{Notes: "pricing page"},
+		reset:   &LinkPatch{Notes: &noNotes},
+		field:   func(link ShortHashURL) interface{} { return link.Notes },
+		value:   "pricing page",
+		zero:    "",
+	},
+}
+
+// TestLinkMetadataRoundTrip - признак сохраняется вместе со ссылкой, читается из ссылки и списка ссылок пользователя,
+// переживает выгрузку в файл и повторное открытие хранилища на диске и сбрасывается только владельцем.
+func TestLinkMetadataRoundTrip(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(path string) (PersistanceStorage, error)
+	}{
+		{"memory", func(string) (PersistanceStorage, error) { return NewStorageInMemory(testLengthShortURL) }},
+		{"disk", func(path string) (PersistanceStorage, error) { return NewStorageOnDisk(path, testLengthShortURL) }},
+		{"cached", func(string) (PersistanceStorage, error) {
+			inMemoryStorage, err := NewStorageInMemory(testLengthShortURL)
+			return NewCachedStorage(inMemoryStorage, 10, time.Minute), err
+		}},
+	}
+	for _, backend := range backends {
+		for _, metadata := range linkMetadataCases {
+			t.Run(backend.name+"/"+metadata.name, func(t *testing.T) {
+				ctx := context.Background()
+				path := filepath.Join(t.TempDir(), "urls.log")
+				mainStorage, err := backend.open(path)
+				assert.NoError(t, err)
+				defer func() { mainStorage.Close() }()
+				userUID := uuid.New().String()
+
+				assertValue := func(s Storage, hashKey string, expected interface{}) {
+					link, err := s.GetLink(ctx, hashKey)
+					assert.NoError(t, err)
+					assert.WithinDuration(t, time.Now(), link.CreatedAt, time.Minute)
+					assert.Equal(t, expected, metadata.field(link))
+				}
+				plainString, _ := mainStorage.Save(ctx, "https://google.ru/", userUID)
+				assertValue(mainStorage, plainString, metadata.zero)
+
+				shortString, err := mainStorage.SaveLink(ctx, "https://yandex.ru/", "", userUID, metadata.options)
+				assert.NoError(t, err)
+				assertValue(mainStorage, shortString, metadata.value)
+				userURLs, err := mainStorage.FindByUserUID(ctx, userUID)
+				assert.NoError(t, err)
+				assert.Len(t, userURLs, 2)
+				for _, userURL := range userURLs {
+					if userURL.ShortHash == shortString {
+						assert.Equal(t, metadata.value, metadata.field(userURL))
+					}
+				}
+
+				// Признак сохраняется в файле
+				pathToFile := filepath.Join(t.TempDir(), "urls.db")
+				_, err = mainStorage.SaveData(ctx, pathToFile)
+				assert.NoError(t, err)
+				loaded, _ := NewStorageInMemory(testLengthShortURL)
+				defer loaded.Close()
+				_, err = loaded.LoadData(ctx, pathToFile)
+				assert.NoError(t, err)
+				assertValue(loaded, shortString, metadata.value)
+
+				// Хранилище на диске восстанавливает признак из лога
+				if backend.name == "disk" {
+					mainStorage.Close()
+					mainStorage, err = backend.open(path)
+					assert.NoError(t, err)
+					assertValue(mainStorage, shortString, metadata.value)
+				}
+
+				if metadata.reset == nil {
+					return
+				}
+				assert.Error(t, mainStorage.UpdateByUser(ctx, shortString, *metadata.reset, uuid.New().String()))
+				assert.Error(t, mainStorage.UpdateByUser(ctx, "unknown", *metadata.reset, userUID))
+				assertValue(mainStorage, shortString, metadata.value)
+				assert.NoError(t, mainStorage.UpdateByUser(ctx, shortString, *metadata.reset, userUID))
+				assertValue(mainStorage, shortString, metadata.zero)
+			})
+		}
+	}
+}
+
+// TestGetLinkInPostgres - ссылка со всеми признаками читается одним запросом.
+func TestGetLinkInPostgres(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	userUID := uuid.New().String()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockDB.ExpectQuery("SELECT original, COALESCE\\(user_uid").WithArgs("hash1").
+		WillReturnRows(pgxmock.NewRows([]string{"original", "user_uid", "password_hash", "created_at", "expires_at", "expired", "deleted", "tags", "preview", "redirect_type", "query_rules", "notes"}).
+			AddRow("https://yandex.ru/", userUID, "$2a$10$hash", createdAt, (*time.Time)(nil), false, false, []string{"news"}, true,
+				http.StatusPermanentRedirect, `{"passthrough":true,"params":{"utm_source":"short"}}`, "pricing page"))
+	link, err := storage.GetLink(context.Background(), "hash1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://yandex.ru/", link.OriginalURL)
+	assert.Equal(t, userUID, link.UserUID)
+	assert.Equal(t, "$2a$10$hash", link.PasswordHash)
+	assert.True(t, createdAt.Equal(link.CreatedAt))
+	assert.Equal(t, []string{"news"}, link.Tags)
+	assert.True(t, link.Preview)
+	assert.Equal(t, http.StatusPermanentRedirect, link.RedirectType)
+	assert.Equal(t, testQueryRulesValue(), link.QueryRules)
+	assert.Equal(t, "pricing page", link.Notes)
+
+	mockDB.ExpectQuery("SELECT original, COALESCE\\(user_uid").WithArgs("unknown").
+		WillReturnError(pgx.ErrNoRows)
+	_, err = storage.GetLink(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
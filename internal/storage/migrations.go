@@ -0,0 +1,185 @@
+// Модуль содержит версионированные миграции схемы БД Postgres.
+package storage
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// migrationsFS - встроенные в бинарный файл SQL миграции вида 0001_name.sql.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockKey - ключ advisory lock, чтобы миграции не применялись параллельно несколькими экземплярами.
+const migrationLockKey = 7260814
+
+// Migration - миграция схемы БД.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// String - функция печати миграции.
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationConn - соединение, на котором применяются миграции.
+type MigrationConn interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// LoadMigrations - список встроенных миграций по возрастанию версии.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	versions := make(map[int]string)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		prefix, name, found := strings.Cut(strings.TrimSuffix(fileName, ".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("bad migration name: %s", fileName)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version: %s", fileName)
+		}
+		if other, exists := versions[version]; exists {
+			return nil, fmt.Errorf("duplicate migration version %d: %s, %s", version, other, fileName)
+		}
+		versions[version] = fileName
+		query, err := migrationsFS.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(query)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// appliedVersions - версии уже примененных миграций.
+func appliedVersions(ctx context.Context, conn MigrationConn) (map[int]bool, error) {
+	applied := make(map[int]bool)
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_version")
+	if err != nil {
+		return applied, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return applied, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// PendingMigrations - миграции, которые еще не применены. Схема БД не изменяется.
+func PendingMigrations(ctx context.Context, conn MigrationConn) ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		// Таблицы версий еще нет - не применена ни одна миграция
+		var pge *pgconn.PgError
+		if !errors.As(err, &pge) || pge.Code != pgerrcode.UndefinedTable {
+			return nil, err
+		}
+	}
+	var pending []Migration
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate - применяет недостающие миграции под advisory lock, возвращает примененные.
+func Migrate(ctx context.Context, conn MigrationConn) ([]Migration, error) {
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			log.Printf("Failed to release migration lock: %v\n", err)
+		}
+	}()
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return nil, fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	pending, err := PendingMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		if err := applyMigration(ctx, conn, migration); err != nil {
+			return applied, fmt.Errorf("migration %s failed: %w", migration, err)
+		}
+		log.Printf("Applied migration: %s\n", migration)
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// applyMigration - применение одной миграции в транзакции вместе с записью ее версии.
+func applyMigration(ctx context.Context, conn MigrationConn, migration Migration) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, migration.SQL); err != nil {
+		return err
+	}
+	query := "INSERT INTO schema_version (version, name) VALUES ($1, $2)"
+	if _, err := tx.Exec(ctx, query, migration.Version, migration.Name); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListPendingMigrations - подключается к БД и возвращает неприменённые миграции без их выполнения.
+func ListPendingMigrations(connectionString string) ([]Migration, error) {
+	conn, err := pgx.Connect(context.Background(), connectionString)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(context.Background())
+	return PendingMigrations(context.Background(), conn)
+}
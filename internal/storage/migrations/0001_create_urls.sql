@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS urls (
+	uuid UUID PRIMARY KEY,
+	correlation_id TEXT NULL,
+	short VARCHAR(255) NOT NULL,
+	original TEXT NOT NULL UNIQUE,
+	user_uid VARCHAR(1024) NULL,
+	deleted BOOLEAN DEFAULT false
+);
@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NULL;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expired BOOLEAN DEFAULT false;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS clicks (
+	id BIGSERIAL PRIMARY KEY,
+	short VARCHAR(255) NOT NULL,
+	clicked_at TIMESTAMPTZ NOT NULL,
+	referrer TEXT NULL,
+	user_agent TEXT NULL,
+	ip_hash VARCHAR(64) NULL
+);
+CREATE INDEX IF NOT EXISTS idx_clicks_short ON clicks (short);
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_urls_short ON urls (short);
+CREATE INDEX IF NOT EXISTS idx_urls_user_uid ON urls (user_uid);
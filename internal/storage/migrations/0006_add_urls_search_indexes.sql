@@ -0,0 +1,10 @@
+-- Без прав на установку pg_trgm поиск работает без триграммных индексов, полным просмотром ссылок пользователя
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS pg_trgm;
+    CREATE INDEX IF NOT EXISTS idx_urls_original_trgm ON urls USING gin (original gin_trgm_ops);
+    CREATE INDEX IF NOT EXISTS idx_urls_short_trgm ON urls USING gin (short gin_trgm_ops);
+EXCEPTION WHEN insufficient_privilege OR undefined_file OR feature_not_supported THEN
+    RAISE WARNING 'pg_trgm is not available, search indexes are skipped: %', SQLERRM;
+END
+$$;
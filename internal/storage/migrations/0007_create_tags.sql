@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS tags (
+	id BIGSERIAL PRIMARY KEY,
+	user_uid VARCHAR(1024) NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	UNIQUE (user_uid, name)
+);
+CREATE TABLE IF NOT EXISTS url_tags (
+	short VARCHAR(255) NOT NULL,
+	tag_id BIGINT NOT NULL REFERENCES tags (id) ON DELETE CASCADE,
+	PRIMARY KEY (short, tag_id)
+);
+CREATE INDEX IF NOT EXISTS idx_url_tags_tag_id ON url_tags (tag_id);
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS preview BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,21 @@
+-- Пакетное сокращение раньше записывало correlation_id в short без проверки, поэтому коды могли повторяться.
+-- Код остается у самой ранней ссылки, повторы получают суффикс из uuid и выводятся предупреждением.
+DO $$
+DECLARE
+    renamed INTEGER;
+BEGIN
+    WITH duplicates AS (
+        SELECT uuid, ROW_NUMBER() OVER (PARTITION BY short ORDER BY created_at, uuid) AS position
+        FROM urls
+    )
+    UPDATE urls SET short = LEFT(urls.short, 246) || '-' || LEFT(urls.uuid::text, 8)
+    FROM duplicates
+    WHERE urls.uuid = duplicates.uuid AND duplicates.position > 1;
+    GET DIAGNOSTICS renamed = ROW_COUNT;
+    IF renamed > 0 THEN
+        RAISE WARNING '% urls with duplicate short codes are renamed to short-<uuid prefix>', renamed;
+    END IF;
+END
+$$;
+DROP INDEX IF EXISTS idx_urls_short;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_urls_short_unique ON urls (short);
@@ -0,0 +1,9 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '';
+-- Без установленного pg_trgm поиск по заметкам работает без триграммного индекса, как и поиск по ссылкам
+DO $$
+BEGIN
+    CREATE INDEX IF NOT EXISTS idx_urls_notes_trgm ON urls USING gin (notes gin_trgm_ops);
+EXCEPTION WHEN undefined_object THEN
+    RAISE WARNING 'pg_trgm is not available, notes search index is skipped: %', SQLERRM;
+END
+$$;
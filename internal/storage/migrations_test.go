@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.NotEmpty(t, migration.Name)
+		assert.NotEmpty(t, migration.SQL)
+		if i > 0 {
+			assert.Less(t, migrations[i-1].Version, migration.Version)
+		}
+	}
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "0001_create_urls", migrations[0].String())
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := LoadMigrations()
+	assert.NoError(t, err)
+
+	mockDB, err := pgxmock.NewConn(pgxmock.QueryMatcherOption(pgxmock.QueryMatcherRegexp))
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockKey).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+		WillReturnResult(pgxmock.NewResult("CREATE", 0))
+	// Первая миграция уже применена
+	mockDB.ExpectQuery("SELECT version FROM schema_version").
+		WillReturnRows(pgxmock.NewRows([]string{"version"}).AddRow(1))
+	for _, migration := range migrations[1:] {
+		mockDB.ExpectBegin()
+		mockDB.ExpectExec(regexp.QuoteMeta(migration.SQL)).
+			WillReturnResult(pgxmock.NewResult("ALTER", 0))
+		mockDB.ExpectExec("INSERT INTO schema_version").WithArgs(migration.Version, migration.Name).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mockDB.ExpectCommit()
+	}
+	mockDB.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockKey).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+
+	applied, err := Migrate(context.Background(), mockDB)
+	assert.NoError(t, err)
+	assert.Equal(t, migrations[1:], applied)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestMigrateFailedRollback(t *testing.T) {
+	migrations, err := LoadMigrations()
+	assert.NoError(t, err)
+
+	mockDB, err := pgxmock.NewConn(pgxmock.QueryMatcherOption(pgxmock.QueryMatcherRegexp))
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	mockDB.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockKey).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockDB.ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+		WillReturnResult(pgxmock.NewResult("CREATE", 0))
+	mockDB.ExpectQuery("SELECT version FROM schema_version").
+		WillReturnRows(pgxmock.NewRows([]string{"version"}))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(regexp.QuoteMeta(migrations[0].SQL)).
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.SyntaxError})
+	mockDB.ExpectRollback()
+	mockDB.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockKey).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+
+	applied, err := Migrate(context.Background(), mockDB)
+	assert.Error(t, err)
+	assert.Empty(t, applied)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestPendingMigrationsDryRun(t *testing.T) {
+	migrations, err := LoadMigrations()
+	assert.NoError(t, err)
+
+	mockDB, err := pgxmock.NewConn(pgxmock.QueryMatcherOption(pgxmock.QueryMatcherRegexp))
+	assert.NoError(t, err)
+	defer mockDB.Close(context.Background())
+
+	// Таблицы версий еще нет - в списке все миграции, других запросов нет
+	mockDB.ExpectQuery("SELECT version FROM schema_version").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UndefinedTable})
+
+	pending, err := PendingMigrations(context.Background(), mockDB)
+	assert.NoError(t, err)
+	assert.Equal(t, migrations, pending)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,107 @@
+// Модуль содержит проверку и приведение ссылок к каноническому виду.
+package storage
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// defaultPorts - порты по умолчанию для допустимых схем.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// InvalidURLError - ссылка не может быть сокращена.
+type InvalidURLError struct {
+	URL    string
+	Reason string
+}
+
+// Error - реализация метода.
+func (ie *InvalidURLError) Error() string {
+	return fmt.Sprintf("invalid url %q: %s", ie.URL, ie.Reason)
+}
+
+// NormalizeURL - проверка ссылки и приведение к каноническому виду, чтобы разные записи одной ссылки совпадали.
+// Допускаются только http и https с хостом; схема и хост приводятся к нижнему регистру, IDN - к punycode,
+// порт по умолчанию и пустой путь убираются, при sortQuery параметры запроса сортируются по имени.
+func NormalizeURL(rawURL string, sortQuery bool) (string, error) {
+	invalid := func(format string, args ...any) error {
+		return &InvalidURLError{URL: rawURL, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	value := strings.TrimSpace(rawURL)
+	if value == "" {
+		return "", invalid("empty url")
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return "", invalid("malformed url")
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	defaultPort, supported := defaultPorts[parsed.Scheme]
+	if !supported {
+		if parsed.Scheme == "" {
+			return "", invalid("missing scheme, expected http or https")
+		}
+		return "", invalid("unsupported scheme %q, expected http or https", parsed.Scheme)
+	}
+	if parsed.Opaque != "" || parsed.Host == "" {
+		return "", invalid("missing host")
+	}
+
+	host, err := normalizeHost(parsed.Hostname())
+	if err != nil {
+		return "", invalid("invalid host: %s", err)
+	}
+	port := parsed.Port()
+	if port != "" {
+		number, err := strconv.Atoi(port)
+		if err != nil || number < 1 || number > 65535 {
+			return "", invalid("invalid port %q", port)
+		}
+		port = strconv.Itoa(number)
+	}
+	if port == defaultPort {
+		port = ""
+	}
+	switch {
+	case port != "":
+		parsed.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		parsed.Host = "[" + host + "]" // IPv6
+	default:
+		parsed.Host = host
+	}
+
+	if parsed.Path == "" {
+		parsed.Path = "/"
+		parsed.RawPath = ""
+	}
+	if sortQuery && parsed.RawQuery != "" {
+		query, err := url.ParseQuery(parsed.RawQuery)
+		if err != nil {
+			return "", invalid("malformed query")
+		}
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.String(), nil
+}
+
+// normalizeHost - хост в нижнем регистре, IDN в punycode, IP адреса в каноническом виде.
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" {
+		return "", fmt.Errorf("empty host")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	return idna.Lookup.ToASCII(host)
+}
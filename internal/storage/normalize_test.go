@@ -0,0 +1,38 @@
+// Модуль содержит тесты приведения ссылок к каноническому виду
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNormalizeURL - разные записи одной ссылки приводятся к одной.
+func TestNormalizeURL(t *testing.T) {
+
+	testCases := []struct {
+		rawURL    string
+		sortQuery bool
+		expected  string
+	}{
+		{rawURL: "https://yandex.ru/", expected: "https://yandex.ru/"},
+		{rawURL: "  HTTPS://Yandex.RU:443  ", expected: "https://yandex.ru/"},
+		{rawURL: "http://yandex.ru.:80/search?q=1", expected: "http://yandex.ru/search?q=1"},
+		{rawURL: "http://yandex.ru:8080/a/b#top", expected: "http://yandex.ru:8080/a/b#top"},
+		{rawURL: "https://пример.рф/путь", expected: "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C"},
+		{rawURL: "http://[::1]:80/", expected: "http://[::1]/"},
+		{rawURL: "https://ya.ru/?b=2&a=1&a=0", expected: "https://ya.ru/?b=2&a=1&a=0"},
+		{rawURL: "https://ya.ru/?b=2&a=1&a=0", sortQuery: true, expected: "https://ya.ru/?a=1&a=0&b=2"},
+	}
+	for _, tc := range testCases {
+		result, err := NormalizeURL(tc.rawURL, tc.sortQuery)
+		assert.NoError(t, err, tc.rawURL)
+		assert.Equal(t, tc.expected, result)
+	}
+
+	for _, rawURL := range []string{"", "   ", "not a url", "javascript:alert(1)", "ftp://ya.ru/", "http://", "http:ya.ru", "https://ya.ru:99999/", "http://ex ample.com/"} {
+		_, err := NormalizeURL(rawURL, false)
+		var ie *InvalidURLError
+		assert.ErrorAs(t, err, &ie, rawURL)
+	}
+}
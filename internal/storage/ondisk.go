@@ -0,0 +1,804 @@
+// Модуль содержит реализацию интерфейса хранилища в локальном файле
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// StorageOnDisk - хранилище в локальном файле без внешней БД.
+// Изменения дописываются в лог, в памяти держится только индекс коротких ссылок со смещениями записей.
+// Индекс сохраняется в файл рядом с логом и при запуске дочитывается из хвоста лога.
+type StorageOnDisk struct {
+	mu             sync.RWMutex // синхронизация доступа к хранилищу
+	logPath        string
+	indexPath      string
+	log            *os.File
+	logSize        int64
+	keydir         map[string]*diskEntry          // short -> последняя версия записи в логе
+	users          map[string]map[string]struct{} // userUID -> short, вторичный индекс
+	originals      map[string]string              // хеш originURL -> short, проверка уникальности
+	staleOps       int                            // записи лога, перекрытые более новыми версиями
+	lengthShortURL int
+	generator      CodeGenerator
+	limits         LinkLimits
+}
+
+// NewStorageOnDisk - конструктор, pathToFile - файл лога, индекс хранится в pathToFile.idx.
+func NewStorageOnDisk(pathToFile string, lengthShortURL int) (*StorageOnDisk, error) {
+	s := &StorageOnDisk{
+		logPath:        pathToFile,
+		indexPath:      pathToFile + ".idx",
+		keydir:         make(map[string]*diskEntry),
+		users:          make(map[string]map[string]struct{}),
+		originals:      make(map[string]string),
+		lengthShortURL: lengthShortURL,
+		generator:      NewHashGenerator(lengthShortURL),
+	}
+	if err := s.open(); err != nil {
+		return s, NewStorageError(err)
+	}
+	return s, nil
+}
+
+// open - открытие лога и восстановление индекса.
+func (s *StorageOnDisk) open() error {
+	file, err := os.OpenFile(s.logPath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.log = file
+	s.logSize = stat.Size()
+
+	var from int64
+	index, err := readDiskIndex(s.indexPath)
+	if err == nil && index.LogSize <= s.logSize {
+		for key, entry := range index.Entries {
+			s.apply(key, entry)
+		}
+		s.staleOps = index.StaleOps
+		from = index.LogSize
+	} else if err != nil && !os.IsNotExist(err) {
+		log.Printf("Index is broken, rebuilding from log: %s", err)
+	}
+	// Изменения после последнего сохранения индекса
+	return s.replay(from)
+}
+
+// replay - применение записей лога начиная со смещения from.
+func (s *StorageOnDisk) replay(from int64) error {
+	reader := bufio.NewReader(io.NewSectionReader(s.log, from, s.logSize-from))
+	offset := from
+	for {
+		shortURL, size, err := decodeDiskRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Недописанная при сбое запись, лог обрезается до последней целой записи
+			log.Printf("Log is truncated at offset %d: %s", offset, err)
+			s.logSize = offset
+			return s.log.Truncate(offset)
+		}
+		if shortURL.Operation == OperationDelete {
+			if _, exists := s.keydir[shortURL.ShortURL]; exists {
+				s.unapply(shortURL.ShortURL)
+				s.staleOps += 1
+			}
+			s.staleOps += 1
+		} else {
+			s.apply(shortURL.ShortURL, entryFromRecord(shortURL, offset))
+		}
+		offset += size
+	}
+}
+
+// SetCodeGenerator - замена стратегии генерации коротких кодов.
+// Генератор продолжает выдачу после уже записанных кодов.
+func (s *StorageOnDisk) SetCodeGenerator(generator CodeGenerator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generator = generator
+	for hashKey := range s.keydir {
+		seedGenerator(generator, hashKey)
+	}
+}
+
+// SetLinkLimits - ограничения количества ссылок пользователя для новых ссылок.
+func (s *StorageOnDisk) SetLinkLimits(limits LinkLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+}
+
+// CountByUser - количество ссылок пользователя всего и за текущие сутки, читается только индекс.
+func (s *StorageOnDisk) CountByUser(ctx context.Context, userUID string) (LinkCount, error) {
+	if err := ctx.Err(); err != nil {
+		return LinkCount{}, NewStorageError(err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.countByUser(userUID, time.Now()), nil
+}
+
+// countByUser - подсчет ссылок пользователя через вторичный индекс, вызывается под блокировкой.
+func (s *StorageOnDisk) countByUser(userUID string, now time.Time) LinkCount {
+	count := LinkCount{Total: len(s.users[userUID])}
+	dayStart := DayStart(now)
+	for hashKey := range s.users[userUID] {
+		if createdAt := s.keydir[hashKey].CreatedAt; createdAt != nil && !createdAt.Before(dayStart) {
+			count.Daily++
+		}
+	}
+	return count
+}
+
+// checkLimits - проверка ограничений перед созданием requested ссылок пользователя, вызывается под блокировкой.
+func (s *StorageOnDisk) checkLimits(userUID string, requested int) error {
+	if !s.limits.enabled() {
+		return nil
+	}
+	return s.limits.check(s.countByUser(userUID, time.Now()), requested)
+}
+
+// CountURLs - количество сохраненных ссылок.
+func (s *StorageOnDisk) CountURLs() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keydir), nil
+}
+
+// apply - обновление индексов для новой версии записи, вызывается под блокировкой.
+func (s *StorageOnDisk) apply(hashKey string, entry *diskEntry) {
+	if _, exists := s.keydir[hashKey]; exists {
+		s.unapply(hashKey)
+		s.staleOps += 1
+	}
+	s.keydir[hashKey] = entry
+	s.originals[entry.OriginalHash] = hashKey
+	seedGenerator(s.generator, hashKey)
+	if s.users[entry.UserUID] == nil {
+		s.users[entry.UserUID] = make(map[string]struct{})
+	}
+	s.users[entry.UserUID][hashKey] = struct{}{}
+}
+
+// unapply - удаление записи из индексов, вызывается под блокировкой.
+func (s *StorageOnDisk) unapply(hashKey string) {
+	entry, exists := s.keydir[hashKey]
+	if !exists {
+		return
+	}
+	if s.originals[entry.OriginalHash] == hashKey {
+		delete(s.originals, entry.OriginalHash)
+	}
+	delete(s.users[entry.UserUID], hashKey)
+	if len(s.users[entry.UserUID]) == 0 {
+		delete(s.users, entry.UserUID)
+	}
+	delete(s.keydir, hashKey)
+}
+
+// appendRecord - дозапись в лог, возвращает смещение записи, вызывается под блокировкой.
+func (s *StorageOnDisk) appendRecord(shortURL *ShortURL) (int64, error) {
+	record, err := encodeDiskRecord(shortURL)
+	if err != nil {
+		return 0, err
+	}
+	offset := s.logSize
+	if _, err := s.log.WriteAt(record, offset); err != nil {
+		return 0, err
+	}
+	s.logSize += int64(len(record))
+	return offset, nil
+}
+
+// readRecord - чтение записи лога по смещению.
+func (s *StorageOnDisk) readRecord(offset int64) (*ShortURL, error) {
+	shortURL, _, err := decodeDiskRecord(io.NewSectionReader(s.log, offset, s.logSize-offset))
+	return shortURL, err
+}
+
+// put - запись новой версии ссылки в лог и индекс, вызывается под блокировкой.
+func (s *StorageOnDisk) put(shortURL *ShortURL) error {
+	shortURL.Operation = ""
+	offset, err := s.appendRecord(shortURL)
+	if err != nil {
+		return err
+	}
+	s.apply(shortURL.ShortURL, entryFromRecord(shortURL, offset))
+	return nil
+}
+
+// remove - запись удаления ссылки в лог и индекс, вызывается под блокировкой.
+func (s *StorageOnDisk) remove(hashKey string) error {
+	_, err := s.appendRecord(&ShortURL{UUID: hashKey, ShortURL: hashKey, Operation: OperationDelete})
+	if err != nil {
+		return err
+	}
+	s.unapply(hashKey)
+	s.staleOps += 2 // удаленная запись и сама запись удаления
+	return nil
+}
+
+// Save - сохранение новой ссылки.
+func (s *StorageOnDisk) Save(ctx context.Context, value string, userUID string) (string, error) {
+	return s.SaveLink(ctx, value, "", userUID, LinkOptions{})
+}
+
+// SaveLink - сохранение новой ссылки вместе с признаками одной записью лога.
+func (s *StorageOnDisk) SaveLink(ctx context.Context, value string, alias string, userUID string, options LinkOptions) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hashKey := alias
+	if alias != "" {
+		if existKey, err := s.checkAlias(value, alias, userUID); err != nil {
+			return existKey, err
+		}
+	} else {
+		var err error
+		if hashKey, err = s.freeCode(value); err != nil {
+			return hashKey, err
+		}
+	}
+	if err := s.checkLimits(userUID, 1); err != nil {
+		return "", err
+	}
+	if err := s.put(linkRecord(hashKey, value, userUID, options)); err != nil {
+		return "", NewStorageError(err)
+	}
+	return hashKey, nil
+}
+
+// freeCode - свободный код для новой ссылки, вызывается под блокировкой.
+func (s *StorageOnDisk) freeCode(value string) (string, error) {
+	originalHash := sha256Hex(value)
+	// Ссылка уже сокращена ранее
+	if existKey, exists := s.originals[originalHash]; exists {
+		return existKey, NewUniqURLError(value, existKey)
+	}
+	previousKey := ""
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		hashKey, err := s.generator.Generate(value, attempt)
+		if err != nil {
+			return "", NewStorageError(err)
+		}
+		existing, exists := s.keydir[hashKey]
+		if !exists {
+			return hashKey, nil
+		}
+		// Код занят той же ссылкой - это не коллизия
+		if existing.OriginalHash == originalHash {
+			return hashKey, NewUniqURLError(value, hashKey)
+		}
+		// Детерминированный генератор вернул тот же код, повторять бессмысленно
+		if hashKey == previousKey {
+			break
+		}
+		previousKey = hashKey
+	}
+	return "", NewStorageError(errNoFreeCode)
+}
+
+// correlationRecord - запись ссылки, сохраненной под идентификатором запроса.
+func correlationRecord(value string, correlationID string, userUID string, options LinkOptions) *ShortURL {
+	shortURL := linkRecord(correlationID, value, userUID, options)
+	shortURL.CorrelationID = correlationID
+	return shortURL
+}
+
+// linkRecord - запись лога для новой ссылки с признаками.
+func linkRecord(hashKey string, value string, userUID string, options LinkOptions) *ShortURL {
+	createdAt := time.Now().UTC()
+	shortURL := &ShortURL{
+		UUID: hashKey, ShortURL: hashKey, OriginalURL: value, UserUID: userUID, CreatedAt: &createdAt,
+		Tags: options.Tags, Preview: options.Preview, PasswordHash: options.PasswordHash,
+		RedirectType: options.RedirectType, QueryRules: options.QueryRules, Notes: options.Notes,
+	}
+	if !options.ExpiresAt.IsZero() {
+		expiresAt := options.ExpiresAt
+		shortURL.ExpiresAt = &expiresAt
+	}
+	return shortURL
+}
+
+// checkAlias - проверка, что псевдоним свободен и ссылка еще не сокращена, вызывается под блокировкой.
+func (s *StorageOnDisk) checkAlias(value string, alias string, userUID string) (string, error) {
+	originalHash := sha256Hex(value)
+	if existing, exists := s.keydir[alias]; exists {
+		// Повторная отправка той же ссылки тем же пользователем - обычный конфликт
+		if existing.OriginalHash == originalHash && existing.UserUID == userUID {
+			return alias, NewUniqURLError(value, alias)
+		}
+		return alias, NewAliasTakenError(alias)
+	}
+	// Ссылка уже сокращена под другим кодом
+	if existKey, exists := s.originals[originalHash]; exists {
+		return existKey, NewUniqURLError(value, existKey)
+	}
+	return alias, nil
+}
+
+// Get - чтение ссылки, с диска читается только одна запись.
+func (s *StorageOnDisk) Get(ctx context.Context, hashKey string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, exists := s.keydir[hashKey]
+	if !exists {
+		return "", false
+	}
+	shortURL, err := s.readRecord(entry.Offset)
+	if err != nil {
+		log.Printf("Failed to read record %s: %v\n", hashKey, err)
+		return "", false
+	}
+	return shortURL.OriginalURL, true
+}
+
+// FindByUserUID - поиск ссылок по пользовательскому UID через вторичный индекс.
+func (s *StorageOnDisk) FindByUserUID(ctx context.Context, userUID string) ([]ShortHashURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewStorageError(err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var output []ShortHashURL
+
+	now := time.Now()
+	for hashKey := range s.users[userUID] {
+		entry := s.keydir[hashKey]
+		shortURL, err := s.readRecord(entry.Offset)
+		if err != nil {
+			return output, NewStorageError(err)
+		}
+		output = append(output, entry.toShortHashURL(hashKey, shortURL, now))
+	}
+
+	return output, nil
+}
+
+// QueryByUser - страница ссылок пользователя, выборка идет по индексу пользователей.
+func (s *StorageOnDisk) QueryByUser(ctx context.Context, userUID string, query URLQuery) (URLPage, error) {
+	urls, err := s.FindByUserUID(ctx, userUID)
+	if err != nil {
+		return URLPage{}, err
+	}
+	return QueryURLs(urls, query)
+}
+
+// GetLink - чтение ссылки со всеми полями.
+func (s *StorageOnDisk) GetLink(ctx context.Context, hashKey string) (ShortHashURL, error) {
+	if err := ctx.Err(); err != nil {
+		return ShortHashURL{}, NewStorageError(err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, exists := s.keydir[hashKey]
+	if !exists {
+		return ShortHashURL{}, NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrNotFound))
+	}
+	shortURL, err := s.readRecord(entry.Offset)
+	if err != nil {
+		return ShortHashURL{}, NewStorageError(err)
+	}
+	return entry.toShortHashURL(hashKey, shortURL, time.Now()), nil
+}
+
+// SearchByUser - поиск ссылок пользователя, в которые входит каждое слово запроса.
+// Ссылки пользователя берутся из вторичного индекса и читаются с диска по одной.
+func (s *StorageOnDisk) SearchByUser(ctx context.Context, userUID string, query string, limit int) ([]ShortHashURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewStorageError(err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var output []ShortHashURL
+
+	words := searchWords(query)
+	now := time.Now()
+	for hashKey := range s.users[userUID] {
+		entry := s.keydir[hashKey]
+		if entry.Deleted {
+			continue
+		}
+		shortURL, err := s.readRecord(entry.Offset)
+		if err != nil {
+			return nil, NewStorageError(err)
+		}
+		if !matchSearch(words, shortURL.OriginalURL, hashKey, shortURL.Notes) {
+			continue
+		}
+		output = append(output, entry.toShortHashURL(hashKey, shortURL, now))
+	}
+	return limitSearchResults(output, limit), nil
+}
+
+// IsDeleted - удалена ли ссылка.
+func (s *StorageOnDisk) IsDeleted(ctx context.Context, hashKey string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, exists := s.keydir[hashKey]
+	if !exists {
+		return false, nil
+	}
+	return entry.Deleted, nil
+}
+
+// DeleteByUser - удалить ссылку по пользовательскому UUID
+// Ссылка помечается удаленной новой версией записи и может быть восстановлена.
+func (s *StorageOnDisk) DeleteByUser(ctx context.Context, shortHashURL []string, userUID string) error {
+	return s.setDeleted(ctx, shortHashURL, userUID, true)
+}
+
+// RestoreByUser - восстановить удаленные ссылки по пользовательскому UUID
+func (s *StorageOnDisk) RestoreByUser(ctx context.Context, shortHashURL []string, userUID string) error {
+	return s.setDeleted(ctx, shortHashURL, userUID, false)
+}
+
+// UpdateByUser - изменение ссылки владельцем одной новой версией записи, короткий код сохраняется.
+// Если новая ссылка уже сокращена под другим кодом, возвращается UniqURLError с этим кодом, удаленная ссылка не меняется.
+func (s *StorageOnDisk) UpdateByUser(ctx context.Context, hashKey string, patch LinkPatch, userUID string) error {
+	if err := ctx.Err(); err != nil {
+		return NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, exists := s.keydir[hashKey]
+	if !exists || entry.UserUID != userUID {
+		return NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrNotFound))
+	}
+	if entry.Deleted {
+		return NewStorageError(fmt.Errorf("short url %s %w", hashKey, ErrDeleted))
+	}
+	if patch.OriginalURL != "" && entry.OriginalHash != sha256Hex(patch.OriginalURL) {
+		// Новая ссылка уже сокращена под другим кодом
+		if existKey, exists := s.originals[sha256Hex(patch.OriginalURL)]; exists {
+			return NewUniqURLError(patch.OriginalURL, existKey)
+		}
+	}
+	shortURL, err := s.readRecord(entry.Offset)
+	if err != nil {
+		return NewStorageError(err)
+	}
+	applyPatch(shortURL, patch)
+	if err := s.put(shortURL); err != nil {
+		return NewStorageError(err)
+	}
+	return nil
+}
+
+// applyPatch - применение изменений владельца к версии записи.
+func applyPatch(shortURL *ShortURL, patch LinkPatch) {
+	if patch.OriginalURL != "" {
+		shortURL.OriginalURL = patch.OriginalURL
+	}
+	if !patch.ExpiresAt.IsZero() {
+		expiresAt := patch.ExpiresAt
+		shortURL.ExpiresAt = &expiresAt
+		shortURL.Expired = false
+	}
+	if patch.Tags != nil {
+		shortURL.Tags = *patch.Tags
+	}
+	if patch.Preview != nil {
+		shortURL.Preview = *patch.Preview
+	}
+	if patch.RedirectType != nil {
+		shortURL.RedirectType = *patch.RedirectType
+	}
+	if patch.QueryRules != nil {
+		shortURL.QueryRules = patch.linkQueryRules()
+	}
+	if patch.Notes != nil {
+		shortURL.Notes = *patch.Notes
+	}
+}
+
+// setDeleted - установка признака удаления ссылок владельца.
+func (s *StorageOnDisk) setDeleted(ctx context.Context, shortHashURL []string, userUID string, deleted bool) error {
+	if err := ctx.Err(); err != nil {
+		return NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, hash := range shortHashURL {
+		entry, exists := s.keydir[hash]
+		if !exists || entry.UserUID != userUID || entry.Deleted == deleted {
+			continue
+		}
+		shortURL, err := s.readRecord(entry.Offset)
+		if err != nil {
+			return NewStorageError(err)
+		}
+		shortURL.Deleted = deleted
+		if err := s.put(shortURL); err != nil {
+			return NewStorageError(err)
+		}
+	}
+	return nil
+}
+
+// MarkExpired - помечает просроченными все ссылки со сроком действия до now.
+func (s *StorageOnDisk) MarkExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, entry := range s.keydir {
+		if entry.Expired || !entry.isExpired(now) {
+			continue
+		}
+		shortURL, err := s.readRecord(entry.Offset)
+		if err != nil {
+			return count, NewStorageError(err)
+		}
+		shortURL.Expired = true
+		if err := s.put(shortURL); err != nil {
+			return count, NewStorageError(err)
+		}
+		count += 1
+	}
+	return count, nil
+}
+
+// CorrelationSave - сохранение данных (ссылка и идентификатор)
+// Идентификатор становится кодом ссылки, поэтому занятый код не перезаписывается.
+func (s *StorageOnDisk) CorrelationSave(ctx context.Context, value string, correlationID string, userUID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", NewStorageError(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existKey, err := s.checkAlias(value, correlationID, userUID); err != nil {
+		return existKey, err
+	}
+	if err := s.checkLimits(userUID, 1); err != nil {
+		return "", err
+	}
+	if err := s.put(correlationRecord(value, correlationID, userUID, LinkOptions{})); err != nil {
+		return correlationID, NewStorageError(err)
+	}
+	return correlationID, nil
+}
+
+// CorrelationGet - чтение данных (ссылка и идентификатор)
+func (s *StorageOnDisk) CorrelationGet(ctx context.Context, correlationID string) (string, bool) {
+	return s.Get(ctx, correlationID)
+}
+
+// CorrelationsSave - сохранение данных (ссылок и идентификатор)
+// Пакет проверяется целиком до записи: при занятом коде или уже сокращенной ссылке не сохраняется ничего.
+func (s *StorageOnDisk) CorrelationsSave(ctx context.Context, correlationURLs []CorrelationURL, userUID string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NewStorageError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	aliases := make(map[string]string, len(correlationURLs))
+	values := make(map[string]string, len(correlationURLs))
+	for _, value := range correlationURLs {
+		if _, err := s.checkAlias(value.OriginalURL, value.CorrelationID, userUID); err != nil {
+			return nil, err
+		}
+		// Повторы внутри самого пакета
+		if _, exists := aliases[value.CorrelationID]; exists {
+			return nil, NewAliasTakenError(value.CorrelationID)
+		}
+		if existKey, exists := values[value.OriginalURL]; exists {
+			return nil, NewUniqURLError(value.OriginalURL, existKey)
+		}
+		aliases[value.CorrelationID] = value.OriginalURL
+		values[value.OriginalURL] = value.CorrelationID
+	}
+	if err := s.checkLimits(userUID, len(correlationURLs)); err != nil {
+		return nil, err
+	}
+
+	var output []string
+	for _, value := range correlationURLs {
+		if err := s.put(correlationRecord(value.OriginalURL, value.CorrelationID, userUID, value.Options)); err != nil {
+			return output, NewStorageError(err)
+		}
+		output = append(output, value.CorrelationID)
+	}
+
+	return output, nil
+}
+
+// LoadData загрузка данных из файла
+// Импорт снимка или журнала хранилища в памяти, записи применяются по порядку.
+// Файл выгружается при каждой остановке и старше лога, поэтому уже записанные в лог ссылки не меняются,
+// а новые проходят те же проверки кода и уникальности ссылки, что и при сохранении.
+func (s *StorageOnDisk) LoadData(ctx context.Context, pathToFile string) (int, error) {
+	count := 0
+	consumer, err := NewConsumer(pathToFile)
+	if err != nil {
+		return count, NewStorageError(err)
+	}
+	defer consumer.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	imported := make(map[string]struct{})
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, NewStorageError(err)
+		}
+		shortURL, err := consumer.ReadShortURL()
+		if err != nil {
+			if err != io.EOF {
+				return count, NewStorageError(err)
+			}
+			break
+		}
+		applied, err := s.importRecord(shortURL, imported)
+		if err != nil {
+			return count, NewStorageError(err)
+		}
+		if applied {
+			count += 1
+		}
+	}
+	return count, nil
+}
+
+// importRecord - применение записи файла, если она относится к ссылке из этого же импорта
+// или к свободному коду, вызывается под блокировкой. Возвращает false для пропущенной записи.
+func (s *StorageOnDisk) importRecord(shortURL *ShortURL, imported map[string]struct{}) (bool, error) {
+	hashKey := shortURL.ShortURL
+	_, own := imported[hashKey]
+	if shortURL.Operation == OperationDelete {
+		if !own {
+			return false, nil
+		}
+		delete(imported, hashKey)
+		return true, s.remove(hashKey)
+	}
+	record := recordFromShortURL(shortURL)
+	shortURL.OriginalURL, shortURL.UserUID = record.OriginalURL, record.UserUID
+	if own {
+		// Более новая версия ссылки из файла, ссылка не должна совпасть с другой сохраненной
+		if existKey, exists := s.originals[sha256Hex(shortURL.OriginalURL)]; exists && existKey != hashKey {
+			return false, nil
+		}
+	} else if _, err := s.checkAlias(shortURL.OriginalURL, hashKey, shortURL.UserUID); err != nil {
+		return false, nil
+	}
+	imported[hashKey] = struct{}{}
+	return true, s.put(shortURL)
+}
+
+// SaveData сохранение данных в файл
+// Экспорт в формате файла хранилища в памяти, снимок атомарно заменяет прежний файл.
+func (s *StorageOnDisk) SaveData(ctx context.Context, pathToFile string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	count := 0
+	tmpPath := pathToFile + ".tmp"
+	producer, err := NewProducer(tmpPath)
+	if err != nil {
+		return count, NewStorageError(err)
+	}
+	defer producer.Close()
+
+	for _, entry := range s.keydir {
+		if err := ctx.Err(); err != nil {
+			return count, NewStorageError(err)
+		}
+		shortURL, err := s.readRecord(entry.Offset)
+		if err != nil {
+			return count, NewStorageError(err)
+		}
+		if err := producer.WriteShortURL(shortURL); err != nil {
+			return count, NewStorageError(err)
+		}
+		count += 1
+	}
+	if err := producer.Close(); err != nil {
+		return count, NewStorageError(err)
+	}
+	if err := os.Rename(tmpPath, pathToFile); err != nil {
+		return count, NewStorageError(err)
+	}
+	return count, nil
+}
+
+// Compact - переписывает лог только с актуальными версиями ссылок, если в нем есть устаревшие записи.
+func (s *StorageOnDisk) Compact() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.staleOps == 0 {
+		return 0, nil
+	}
+
+	tmpPath := s.logPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return 0, NewStorageError(err)
+	}
+	writer := bufio.NewWriter(file)
+	offsets := make(map[string]int64, len(s.keydir))
+	var size int64
+	for hashKey, entry := range s.keydir {
+		shortURL, err := s.readRecord(entry.Offset)
+		if err == nil {
+			var record []byte
+			record, err = encodeDiskRecord(shortURL)
+			if err == nil {
+				_, err = writer.Write(record)
+			}
+			offsets[hashKey] = size
+			size += int64(len(record))
+		}
+		if err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+			return 0, NewStorageError(err)
+		}
+	}
+	if err := writer.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return 0, NewStorageError(err)
+	}
+
+	// Индекс со смещениями прежнего лога больше не действителен
+	os.Remove(s.indexPath)
+	if err := os.Rename(tmpPath, s.logPath); err != nil {
+		file.Close()
+		return 0, NewStorageError(err)
+	}
+	if err := syncDir(s.logPath); err != nil {
+		log.Printf("Log directory sync error: %s", err)
+	}
+	s.log.Close()
+	s.log = file
+	s.logSize = size
+	for hashKey, offset := range offsets {
+		s.keydir[hashKey].Offset = offset
+	}
+	s.staleOps = 0
+	if err := s.writeIndex(); err != nil {
+		log.Printf("Index write error: %s", err)
+	}
+	return len(offsets), nil
+}
+
+// writeIndex - сохранение индекса, вызывается под блокировкой.
+func (s *StorageOnDisk) writeIndex() error {
+	return writeDiskIndex(s.indexPath, &diskIndex{LogSize: s.logSize, StaleOps: s.staleOps, Entries: s.keydir})
+}
+
+// Close - освобождение ресурсов, индекс сохраняется чтобы не перечитывать лог при запуске.
+func (s *StorageOnDisk) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return
+	}
+	if err := s.log.Sync(); err != nil {
+		log.Printf("Log sync error: %s", err)
+	}
+	if err := s.writeIndex(); err != nil {
+		log.Printf("Index write error: %s", err)
+	}
+	s.log.Close()
+	s.log = nil
+}
@@ -0,0 +1,156 @@
+// Модуль содержит формат файла лога и индекса хранилища на диске.
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// diskRecordHeaderSize - заголовок записи лога: длина и контрольная сумма данных.
+const diskRecordHeaderSize = 8
+
+// maxDiskRecordSize - ограничение размера записи, большее значение длины считается повреждением.
+const maxDiskRecordSize = 1 << 20
+
+// errCorruptRecord - запись лога повреждена или не дописана при сбое.
+var errCorruptRecord = errors.New("corrupt log record")
+
+// diskEntry - запись индекса: смещение последней версии ссылки в логе и поля для поиска без чтения лога.
+type diskEntry struct {
+	Offset       int64       `json:"offset"`
+	UserUID      string      `json:"user_uid,omitempty"`
+	OriginalHash string      `json:"original_hash"`
+	CreatedAt    *time.Time  `json:"created_at,omitempty"`
+	ExpiresAt    *time.Time  `json:"expires_at,omitempty"`
+	Expired      bool        `json:"expired,omitempty"`
+	Deleted      bool        `json:"deleted,omitempty"`
+	Preview      bool        `json:"preview,omitempty"`
+	PasswordHash string      `json:"password_hash,omitempty"`
+	RedirectType int         `json:"redirect_type,omitempty"`
+	QueryRules   *QueryRules `json:"query_rules,omitempty"`
+}
+
+// isExpired - истек ли срок действия ссылки на момент now.
+func (e *diskEntry) isExpired(now time.Time) bool {
+	return e.Expired || (e.ExpiresAt != nil && !now.Before(*e.ExpiresAt))
+}
+
+// entryFromRecord - запись индекса для записи лога по смещению offset.
+func entryFromRecord(shortURL *ShortURL, offset int64) *diskEntry {
+	return &diskEntry{
+		Offset:       offset,
+		UserUID:      shortURL.UserUID,
+		OriginalHash: sha256Hex(shortURL.OriginalURL),
+		CreatedAt:    shortURL.CreatedAt,
+		ExpiresAt:    shortURL.ExpiresAt,
+		Expired:      shortURL.Expired,
+		Deleted:      shortURL.Deleted,
+		Preview:      shortURL.Preview,
+		PasswordHash: shortURL.PasswordHash,
+		RedirectType: shortURL.RedirectType,
+		QueryRules:   shortURL.QueryRules,
+	}
+}
+
+// toShortHashURL - ссылка с короткой из записи индекса и прочитанной записи лога.
+func (e *diskEntry) toShortHashURL(hashKey string, shortURL *ShortURL, now time.Time) ShortHashURL {
+	item := ShortHashURL{
+		ShortHash:    hashKey,
+		OriginalURL:  shortURL.OriginalURL,
+		UserUID:      e.UserUID,
+		PasswordHash: e.PasswordHash,
+		Expired:      e.isExpired(now),
+		Deleted:      e.Deleted,
+		Tags:         shortURL.Tags,
+		Preview:      e.Preview,
+		RedirectType: e.RedirectType,
+		QueryRules:   e.QueryRules,
+		Notes:        shortURL.Notes,
+	}
+	if shortURL.CreatedAt != nil {
+		item.CreatedAt = *shortURL.CreatedAt
+	}
+	if e.ExpiresAt != nil {
+		item.ExpiresAt = *e.ExpiresAt
+	}
+	return item
+}
+
+// diskIndex - файл индекса, снимок индекса на момент, когда лог имел размер LogSize.
+type diskIndex struct {
+	LogSize  int64                 `json:"log_size"`
+	StaleOps int                   `json:"stale_ops"`
+	Entries  map[string]*diskEntry `json:"entries"`
+}
+
+// encodeDiskRecord - запись лога: длина, CRC32 и JSON ссылки.
+func encodeDiskRecord(shortURL *ShortURL) ([]byte, error) {
+	payload, err := json.Marshal(shortURL)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, diskRecordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[diskRecordHeaderSize:], payload)
+	return record, nil
+}
+
+// decodeDiskRecord - чтение записи лога, возвращает ссылку и размер записи.
+// io.EOF - лог закончился ровно на границе записи.
+func decodeDiskRecord(reader io.Reader) (*ShortURL, int64, error) {
+	header := make([]byte, diskRecordHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errCorruptRecord
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxDiskRecordSize {
+		return nil, 0, errCorruptRecord
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, errCorruptRecord
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, 0, errCorruptRecord
+	}
+	shortURL := &ShortURL{}
+	if err := json.Unmarshal(payload, shortURL); err != nil {
+		return nil, 0, errCorruptRecord
+	}
+	return shortURL, int64(diskRecordHeaderSize + size), nil
+}
+
+// readDiskIndex - чтение файла индекса.
+func readDiskIndex(pathToFile string) (*diskIndex, error) {
+	data, err := os.ReadFile(pathToFile)
+	if err != nil {
+		return nil, err
+	}
+	index := &diskIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// writeDiskIndex - запись файла индекса через временный файл.
+func writeDiskIndex(pathToFile string, index *diskIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmpPath := pathToFile + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, pathToFile)
+}
@@ -0,0 +1,280 @@
+// Модуль содержит тесты хранилища в локальном файле
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOnDiskSaveGet - сохранение, чтение и поиск по пользователю.
+func TestOnDiskSaveGet(t *testing.T) {
+
+	diskStorage, err := NewStorageOnDisk(filepath.Join(t.TempDir(), "urls.log"), testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+
+	ctx := context.Background()
+	userUID := uuid.New().String()
+	shortString, err := diskStorage.Save(ctx, "https://yandex.ru/", userUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "77fca5950e", shortString)
+
+	_, err = diskStorage.Save(ctx, "https://yandex.ru/", userUID)
+	var ue *UniqURLError
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, shortString, ue.ShortHash)
+
+	_, err = diskStorage.SaveLink(ctx, "https://google.ru/", "google", userUID, LinkOptions{})
+	assert.NoError(t, err)
+	_, err = diskStorage.SaveLink(ctx, "https://ya.ru/", "google", uuid.New().String(), LinkOptions{})
+	var ae *AliasTakenError
+	assert.ErrorAs(t, err, &ae)
+	// Пакетное сохранение тоже не перезаписывает чужой псевдоним
+	_, err = diskStorage.CorrelationsSave(ctx, []CorrelationURL{{CorrelationID: "google", OriginalURL: "https://ya.ru/"}},
+		uuid.New().String())
+	assert.ErrorAs(t, err, &ae)
+
+	result, found := diskStorage.Get(ctx, shortString)
+	assert.True(t, found)
+	assert.Equal(t, "https://yandex.ru/", result)
+
+	userURLs, err := diskStorage.FindByUserUID(ctx, userUID)
+	assert.NoError(t, err)
+	assert.Len(t, userURLs, 2)
+
+	assert.NoError(t, diskStorage.DeleteByUser(ctx, []string{"google"}, userUID))
+	deleted, err := diskStorage.IsDeleted(ctx, "google")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	assert.NoError(t, diskStorage.RestoreByUser(ctx, []string{"google"}, userUID))
+	deleted, err = diskStorage.IsDeleted(ctx, "google")
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+// TestOnDiskUpdate - замена оригинальной ссылки сохраняется после переоткрытия.
+func TestOnDiskUpdate(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "urls.log")
+	diskStorage, err := NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	userUID := uuid.New().String()
+	shortString, _ := diskStorage.Save(ctx, "https://yandex.ru/", userUID)
+	otherShort, _ := diskStorage.Save(ctx, "https://google.ru/", userUID)
+
+	assert.Error(t, diskStorage.UpdateByUser(ctx, shortString, LinkPatch{OriginalURL: "https://ya.ru/"}, uuid.New().String()))
+	err = diskStorage.UpdateByUser(ctx, shortString, LinkPatch{OriginalURL: "https://google.ru/"}, userUID)
+	var ue *UniqURLError
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, otherShort, ue.ShortHash)
+
+	assert.NoError(t, diskStorage.UpdateByUser(ctx, shortString, LinkPatch{OriginalURL: "https://ya.ru/"}, userUID))
+	_, err = diskStorage.Save(ctx, "https://ya.ru/", userUID)
+	assert.ErrorAs(t, err, &ue)
+	assert.Equal(t, shortString, ue.ShortHash)
+	diskStorage.Close()
+
+	diskStorage, err = NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+	result, found := diskStorage.Get(ctx, shortString)
+	assert.True(t, found)
+	assert.Equal(t, "https://ya.ru/", result)
+	_, err = diskStorage.Save(ctx, "https://yandex.ru/", userUID)
+	assert.NoError(t, err)
+}
+
+// TestOnDiskReopen - восстановление из файла индекса и из лога без индекса.
+func TestOnDiskReopen(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "urls.log")
+	diskStorage, err := NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	userUID := uuid.New().String()
+	yandexHash, _ := diskStorage.Save(ctx, "https://yandex.ru/", userUID)
+	googleHash, _ := diskStorage.Save(ctx, "https://google.ru/", userUID)
+	expiresAt := time.Now().Add(time.Hour).UTC()
+	assert.NoError(t, diskStorage.UpdateByUser(ctx, googleHash, LinkPatch{ExpiresAt: expiresAt}, userUID))
+	diskStorage.Close()
+
+	// Запись после сохранения индекса дочитывается из хвоста лога
+	diskStorage, err = NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+	assert.NoError(t, diskStorage.DeleteByUser(ctx, []string{yandexHash}, userUID))
+	assert.NoError(t, diskStorage.log.Close()) // аварийное завершение без сохранения индекса
+
+	diskStorage, err = NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+	deleted, err := diskStorage.IsDeleted(ctx, yandexHash)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	result, found := diskStorage.Get(ctx, googleHash)
+	assert.True(t, found)
+	assert.Equal(t, "https://google.ru/", result)
+	diskStorage.Close()
+
+	// Без файла индекса он строится заново по логу
+	assert.NoError(t, os.Remove(pathToFile+".idx"))
+	diskStorage, err = NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+	userURLs, err := diskStorage.FindByUserUID(ctx, userUID)
+	assert.NoError(t, err)
+	assert.Len(t, userURLs, 2)
+	for _, userURL := range userURLs {
+		if userURL.ShortHash == googleHash {
+			assert.True(t, expiresAt.Equal(userURL.ExpiresAt))
+		}
+	}
+	deleted, err = diskStorage.IsDeleted(ctx, yandexHash)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+}
+
+// TestOnDiskTornWrite - недописанная при сбое запись отбрасывается.
+func TestOnDiskTornWrite(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "urls.log")
+	diskStorage, err := NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	yandexHash, _ := diskStorage.Save(ctx, "https://yandex.ru/", "user")
+	size := diskStorage.logSize
+	diskStorage.Save(ctx, "https://google.ru/", "user")
+	assert.NoError(t, diskStorage.log.Truncate(diskStorage.logSize-3))
+	assert.NoError(t, diskStorage.log.Close())
+
+	diskStorage, err = NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+	assert.Equal(t, size, diskStorage.logSize)
+	count, _ := diskStorage.CountURLs()
+	assert.Equal(t, 1, count)
+	_, found := diskStorage.Get(ctx, yandexHash)
+	assert.True(t, found)
+
+	// Новые записи пишутся после последней целой
+	googleHash, err := diskStorage.Save(ctx, "https://google.ru/", "user")
+	assert.NoError(t, err)
+	result, found := diskStorage.Get(ctx, googleHash)
+	assert.True(t, found)
+	assert.Equal(t, "https://google.ru/", result)
+}
+
+// TestOnDiskCompact - после сжатия в логе остаются только актуальные записи.
+func TestOnDiskCompact(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "urls.log")
+	diskStorage, err := NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	yandexHash, _ := diskStorage.Save(ctx, "https://yandex.ru/", "user")
+	googleHash, _ := diskStorage.Save(ctx, "https://google.ru/", "user")
+	diskStorage.UpdateByUser(ctx, googleHash, LinkPatch{ExpiresAt: time.Now().Add(time.Hour)}, "user")
+	diskStorage.DeleteByUser(ctx, []string{yandexHash}, "user")
+	sizeBefore := diskStorage.logSize
+
+	compacted, err := diskStorage.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, compacted)
+	assert.Less(t, diskStorage.logSize, sizeBefore)
+
+	compacted, err = diskStorage.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, compacted)
+	diskStorage.Close()
+
+	diskStorage, err = NewStorageOnDisk(pathToFile, testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+	result, found := diskStorage.Get(ctx, googleHash)
+	assert.True(t, found)
+	assert.Equal(t, "https://google.ru/", result)
+	deleted, err := diskStorage.IsDeleted(ctx, yandexHash)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+}
+
+// TestOnDiskLoadSaveData - импорт и экспорт в формате файла хранилища в памяти.
+func TestOnDiskLoadSaveData(t *testing.T) {
+
+	dir := t.TempDir()
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	ctx := context.Background()
+	yandexHash, _ := inMemoryStorage.Save(ctx, "https://yandex.ru/", "user")
+	dumpPath := filepath.Join(dir, "dump.data")
+	_, err := inMemoryStorage.SaveData(ctx, dumpPath)
+	assert.NoError(t, err)
+
+	diskStorage, err := NewStorageOnDisk(filepath.Join(dir, "urls.log"), testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+	loaded, err := diskStorage.LoadData(ctx, dumpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	result, found := diskStorage.Get(ctx, yandexHash)
+	assert.True(t, found)
+	assert.Equal(t, "https://yandex.ru/", result)
+
+	exportPath := filepath.Join(dir, "export.data")
+	saved, err := diskStorage.SaveData(ctx, exportPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, saved)
+	restoredStorage, _ := NewStorageInMemory(testLengthShortURL)
+	loaded, err = restoredStorage.LoadData(ctx, exportPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+}
+
+// TestOnDiskLoadDataKeepsLog - повторный импорт при запуске не меняет уже записанные ссылки и не дублирует оригиналы.
+func TestOnDiskLoadDataKeepsLog(t *testing.T) {
+
+	dir := t.TempDir()
+	ctx := context.Background()
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	yandexHash, _ := inMemoryStorage.Save(ctx, "https://yandex.ru/", "user")
+	inMemoryStorage.SaveLink(ctx, "https://google.ru/", "google", "user", LinkOptions{})
+	inMemoryStorage.Save(ctx, "https://ya.ru/", "user")
+	dumpPath := filepath.Join(dir, "dump.data")
+	_, err := inMemoryStorage.SaveData(ctx, dumpPath)
+	assert.NoError(t, err)
+
+	diskStorage, err := NewStorageOnDisk(filepath.Join(dir, "urls.log"), testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+	diskStorage.Save(ctx, "https://yandex.ru/", "user")
+	diskStorage.DeleteByUser(ctx, []string{yandexHash}, "user")
+	googleHash, _ := diskStorage.Save(ctx, "https://google.ru/", "user")
+
+	// Импортируется только ссылка, которой нет в логе
+	loaded, err := diskStorage.LoadData(ctx, dumpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	deleted, err := diskStorage.IsDeleted(ctx, yandexHash)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	_, found := diskStorage.Get(ctx, "google")
+	assert.False(t, found)
+	result, found := diskStorage.Get(ctx, googleHash)
+	assert.True(t, found)
+	assert.Equal(t, "https://google.ru/", result)
+
+	// Повторный запуск с тем же файлом не дописывает лог
+	logSize := diskStorage.logSize
+	loaded, err = diskStorage.LoadData(ctx, dumpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, loaded)
+	assert.Equal(t, logSize, diskStorage.logSize)
+}
@@ -4,15 +4,36 @@ package storage
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 // ShortURL - сохраняемая сущность в файл.
 type ShortURL struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	UUID          string      `json:"uuid"`
+	ShortURL      string      `json:"short_url"`
+	OriginalURL   string      `json:"original_url"`
+	UserUID       string      `json:"user_uid,omitempty"`
+	CorrelationID string      `json:"correlation_id,omitempty"`
+	Deleted       bool        `json:"deleted,omitempty"`
+	CreatedAt     *time.Time  `json:"created_at,omitempty"`
+	Tags          []string    `json:"tags,omitempty"`
+	Preview       bool        `json:"preview,omitempty"`
+	PasswordHash  string      `json:"password_hash,omitempty"`
+	RedirectType  int         `json:"redirect_type,omitempty"`
+	QueryRules    *QueryRules `json:"query_rules,omitempty"`
+	Notes         string      `json:"notes,omitempty"`
+	ExpiresAt     *time.Time  `json:"expires_at,omitempty"`
+	Expired       bool        `json:"expired,omitempty"`
+	Operation     string      `json:"operation,omitempty"` // операция журнала, пусто - сохранение
 }
 
+// Операции журнала изменений.
+const (
+	OperationSave   = "save"
+	OperationDelete = "delete"
+)
+
 // Consumer - для работы с файлами.
 type Consumer struct {
 	file    *os.File
@@ -52,9 +73,18 @@ type Producer struct {
 	encoder *json.Encoder
 }
 
-// NewProducer - конструктор.
+// NewProducer - конструктор, перезаписывает файл.
 func NewProducer(fileName string) (*Producer, error) {
-	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0666)
+	return newProducer(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+// NewAppendProducer - конструктор, дописывает в конец файла.
+func NewAppendProducer(fileName string) (*Producer, error) {
+	return newProducer(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+}
+
+func newProducer(fileName string, flag int) (*Producer, error) {
+	file, err := os.OpenFile(fileName, flag, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +100,22 @@ func (p *Producer) WriteShortURL(shortURL *ShortURL) error {
 	return p.encoder.Encode(&shortURL)
 }
 
+// Sync - сброс записанных данных на диск.
+func (p *Producer) Sync() error {
+	return p.file.Sync()
+}
+
 // Close - освобождение ресурсов.
 func (p *Producer) Close() error {
 	return p.file.Close()
 }
+
+// syncDir - сброс на диск каталога файла, чтобы его переименование пережило сбой питания.
+func syncDir(pathToFile string) error {
+	dir, err := os.Open(filepath.Dir(pathToFile))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
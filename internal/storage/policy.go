@@ -0,0 +1,250 @@
+// Модуль содержит политику допустимых адресов сокращаемых ссылок.
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Правила политики без строки в файле, на которые ссылается PolicyError.
+const (
+	PolicyRulePrivate   = "private address"  // адрес из локальной или внутренней сети
+	PolicyRuleAllowlist = "not in allowlist" // хост не подходит ни под одно правило allow
+)
+
+// Директивы файла политики.
+const (
+	policyDirectiveDeny  = "deny"
+	policyDirectiveAllow = "allow"
+)
+
+// PolicyError - ссылка запрещена политикой адресов.
+type PolicyError struct {
+	Host string
+	Rule string // сработавшее правило, например "deny *.example.com"
+}
+
+// Error - реализация метода.
+func (pe *PolicyError) Error() string {
+	return fmt.Sprintf("host %q rejected by rule %q", pe.Host, pe.Rule)
+}
+
+// policyRules - правила из файла политики.
+type policyRules struct {
+	deny  []string
+	allow []string // непустой список включает режим белого списка
+}
+
+// DomainPolicy - политика адресов сокращаемых ссылок: черный список доменов и сетей,
+// белый список доменов с шаблонами *.domain и запрет адресов локальных и внутренних сетей.
+// Правила читаются из файла строками "deny <домен|*.домен|CIDR>" и "allow <домен|*.домен>", # - комментарий.
+// Домен подходит вместе с поддоменами, *.домен - только поддомены.
+type DomainPolicy struct {
+	pathToFile   string
+	blockPrivate bool
+	mu           sync.RWMutex
+	rules        policyRules
+	modTime      time.Time
+	size         int64
+}
+
+// NewDomainPolicy - конструктор, пустой pathToFile - политика только с запретом внутренних адресов.
+func NewDomainPolicy(pathToFile string, blockPrivate bool) (*DomainPolicy, error) {
+	policy := &DomainPolicy{pathToFile: pathToFile, blockPrivate: blockPrivate}
+	if pathToFile == "" {
+		return policy, nil
+	}
+	if err := policy.Reload(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Reload - перечитывание файла политики, при ошибке остаются прежние правила.
+func (p *DomainPolicy) Reload() error {
+	if p.pathToFile == "" {
+		return nil
+	}
+	info, err := os.Stat(p.pathToFile)
+	if err != nil {
+		return err
+	}
+	rules, err := readPolicyRules(p.pathToFile)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = rules
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+	return nil
+}
+
+// ReloadIfChanged - перечитывание файла политики, если он изменился после прошлой загрузки.
+func (p *DomainPolicy) ReloadIfChanged() (bool, error) {
+	if p.pathToFile == "" {
+		return false, nil
+	}
+	info, err := os.Stat(p.pathToFile)
+	if err != nil {
+		return false, err
+	}
+	p.mu.RLock()
+	changed := !info.ModTime().Equal(p.modTime) || info.Size() != p.size
+	p.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, p.Reload()
+}
+
+// Check - проверка ссылки в каноническом виде, при запрете возвращает *PolicyError.
+func (p *DomainPolicy) Check(originalURL string) error {
+	parsed, err := url.Parse(originalURL)
+	if err != nil {
+		return &InvalidURLError{URL: originalURL, Reason: "malformed url"}
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	ip := parseHostIP(host)
+
+	if p.blockPrivate && isPrivateHost(host, ip) {
+		return &PolicyError{Host: host, Rule: PolicyRulePrivate}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, pattern := range p.rules.deny {
+		if matchPolicyPattern(pattern, host, ip) {
+			return &PolicyError{Host: host, Rule: policyDirectiveDeny + " " + pattern}
+		}
+	}
+	if len(p.rules.allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.rules.allow {
+		if matchPolicyPattern(pattern, host, ip) {
+			return nil
+		}
+	}
+	return &PolicyError{Host: host, Rule: PolicyRuleAllowlist}
+}
+
+// readPolicyRules - чтение правил из файла.
+func readPolicyRules(pathToFile string) (policyRules, error) {
+	var rules policyRules
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return rules, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return rules, fmt.Errorf("%s:%d: expected \"deny|allow <pattern>\"", pathToFile, lineNumber)
+		}
+		pattern := strings.ToLower(strings.TrimSuffix(fields[1], "."))
+		if err := validatePolicyPattern(pattern); err != nil {
+			return rules, fmt.Errorf("%s:%d: %w", pathToFile, lineNumber, err)
+		}
+		switch strings.ToLower(fields[0]) {
+		case policyDirectiveDeny:
+			rules.deny = append(rules.deny, pattern)
+		case policyDirectiveAllow:
+			rules.allow = append(rules.allow, pattern)
+		default:
+			return rules, fmt.Errorf("%s:%d: unknown directive %q", pathToFile, lineNumber, fields[0])
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// validatePolicyPattern - проверка шаблона правила: домен, *.домен или сеть CIDR.
+func validatePolicyPattern(pattern string) error {
+	if strings.Contains(pattern, "/") {
+		if _, _, err := net.ParseCIDR(pattern); err != nil {
+			return fmt.Errorf("invalid network %q", pattern)
+		}
+		return nil
+	}
+	domain := strings.TrimPrefix(pattern, "*.")
+	if domain == "" || strings.Contains(domain, "*") {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+	return nil
+}
+
+// matchPolicyPattern - подходит ли хост под шаблон: домен и его поддомены, только поддомены для *.домен, адрес из сети CIDR.
+func matchPolicyPattern(pattern string, host string, ip net.IP) bool {
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		return err == nil && ip != nil && network.Contains(ip)
+	}
+	if domain, wildcard := strings.CutPrefix(pattern, "*."); wildcard {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	if ip != nil {
+		patternIP := net.ParseIP(pattern)
+		return patternIP != nil && patternIP.Equal(ip)
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// isPrivateHost - адрес локальной машины или внутренней сети.
+func isPrivateHost(host string, ip net.IP) bool {
+	if ip != nil {
+		return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+			ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+	}
+	return host == "localhost" || strings.HasSuffix(host, ".localhost") ||
+		strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal")
+}
+
+// parseHostIP - адрес IP из хоста, включая записи IPv4 вида 2130706433 и 0x7f.1, которые понимают браузеры.
+func parseHostIP(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseUint(part, 0, 32)
+		if err != nil || strings.Contains(part, "_") {
+			return nil
+		}
+		values[i] = value
+	}
+	// Последняя часть занимает оставшиеся байты адреса
+	var address uint64
+	for i, value := range values[:len(values)-1] {
+		if value > 0xff {
+			return nil
+		}
+		address |= value << (8 * (3 - i))
+	}
+	last := values[len(values)-1]
+	if last >= 1<<(8*(5-len(values))) {
+		return nil
+	}
+	address |= last
+	return net.IPv4(byte(address>>24), byte(address>>16), byte(address>>8), byte(address))
+}
@@ -0,0 +1,81 @@
+// Модуль содержит тесты политики адресов сокращаемых ссылок
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDomainPolicyPrivate - запрет адресов локальной машины и внутренних сетей.
+func TestDomainPolicyPrivate(t *testing.T) {
+
+	policy, err := NewDomainPolicy("", true)
+	assert.NoError(t, err)
+
+	for _, originalURL := range []string{
+		"http://localhost/", "http://api.localhost/", "http://127.0.0.1:8080/", "http://10.1.2.3/",
+		"http://192.168.0.1/", "http://[::1]/", "http://169.254.169.254/latest", "http://2130706433/", "http://0x7f.1/",
+	} {
+		var pe *PolicyError
+		assert.ErrorAs(t, policy.Check(originalURL), &pe, originalURL)
+		if pe != nil {
+			assert.Equal(t, PolicyRulePrivate, pe.Rule)
+		}
+	}
+	assert.NoError(t, policy.Check("https://yandex.ru/"))
+	assert.NoError(t, policy.Check("http://8.8.8.8/"))
+
+	allowPrivate, _ := NewDomainPolicy("", false)
+	assert.NoError(t, allowPrivate.Check("http://localhost/"))
+}
+
+// TestDomainPolicyRules - черный и белый списки из файла и их перечитывание.
+func TestDomainPolicyRules(t *testing.T) {
+
+	pathToFile := filepath.Join(t.TempDir(), "policy.txt")
+	rules := "# фишинг\ndeny phishing.example\ndeny *.bad.ru\ndeny 203.0.113.0/24\n"
+	assert.NoError(t, os.WriteFile(pathToFile, []byte(rules), 0666))
+
+	policy, err := NewDomainPolicy(pathToFile, true)
+	assert.NoError(t, err)
+
+	var pe *PolicyError
+	assert.ErrorAs(t, policy.Check("https://phishing.example/login"), &pe)
+	assert.Equal(t, "deny phishing.example", pe.Rule)
+	assert.ErrorAs(t, policy.Check("https://login.phishing.example/"), &pe)
+	assert.Equal(t, "deny phishing.example", pe.Rule)
+	assert.NoError(t, policy.Check("https://notphishing.example/"))
+	assert.ErrorAs(t, policy.Check("https://www.bad.ru/"), &pe)
+	assert.Equal(t, "deny *.bad.ru", pe.Rule)
+	assert.ErrorAs(t, policy.Check("http://203.0.113.7/"), &pe)
+	assert.Equal(t, "deny 203.0.113.0/24", pe.Rule)
+	assert.NoError(t, policy.Check("https://bad.ru/"))
+	assert.NoError(t, policy.Check("https://google.ru/"))
+
+	// Белый список включается правилами allow
+	rules += "allow *.yandex.ru\nallow ya.ru\n"
+	assert.NoError(t, os.WriteFile(pathToFile, []byte(rules), 0666))
+	assert.NoError(t, os.Chtimes(pathToFile, time.Now(), time.Now().Add(time.Second)))
+	reloaded, err := policy.ReloadIfChanged()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.NoError(t, policy.Check("https://ya.ru/"))
+	assert.NoError(t, policy.Check("https://www.ya.ru/"))
+	assert.NoError(t, policy.Check("https://mail.yandex.ru/"))
+	assert.ErrorAs(t, policy.Check("https://google.ru/"), &pe)
+	assert.Equal(t, PolicyRuleAllowlist, pe.Rule)
+
+	reloaded, err = policy.ReloadIfChanged()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// Ошибка в файле не сбрасывает прежние правила
+	assert.NoError(t, os.WriteFile(pathToFile, []byte("block ya.ru\n"), 0666))
+	assert.Error(t, policy.Reload())
+	assert.NoError(t, policy.Check("https://ya.ru/"))
+	assert.Error(t, policy.Check("https://google.ru/"))
+}
@@ -0,0 +1,173 @@
+// Модуль содержит выборку ссылок пользователя по страницам с сортировкой и фильтрами.
+package storage
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Поля сортировки ссылок пользователя.
+const (
+	SortByCreatedAt = "created_at" // по времени создания
+	SortByOriginal  = "original"   // по оригинальной ссылке
+)
+
+// ErrInvalidQuery - параметры выборки ссылок заданы неверно.
+var ErrInvalidQuery = errors.New("invalid url query")
+
+// ErrInvalidCursor - курсор поврежден или выдан для другой сортировки.
+var ErrInvalidCursor = fmt.Errorf("%w: invalid cursor", ErrInvalidQuery)
+
+// URLQuery - параметры выборки ссылок пользователя.
+type URLQuery struct {
+	Limit   int      // размер страницы, 0 - без ограничения
+	Cursor  string   // курсор из предыдущей страницы, пусто - первая страница
+	SortBy  string   // SortByCreatedAt или SortByOriginal
+	Desc    bool     // сортировка по убыванию
+	Deleted *bool    // фильтр по признаку удаления, nil - без фильтра
+	Expired *bool    // фильтр по истечению срока действия, nil - без фильтра
+	Domain  string   // хост ссылки вместе с поддоменами, пусто - без фильтра
+	Tags    []string // ссылка должна иметь все теги, пусто - без фильтра
+}
+
+// URLPage - страница ссылок пользователя.
+type URLPage struct {
+	URLs       []ShortHashURL
+	NextCursor string // пусто на последней странице
+}
+
+// urlCursor - позиция последней ссылки страницы, курсор передается клиенту в base64.
+type urlCursor struct {
+	SortBy    string    `json:"s"`
+	Desc      bool      `json:"d,omitempty"`
+	ShortHash string    `json:"h"`
+	Original  string    `json:"o,omitempty"`
+	CreatedAt time.Time `json:"c,omitempty"`
+}
+
+// prepareURLQuery - проверка параметров выборки: сортировка по умолчанию, хост фильтра и позиция курсора.
+func prepareURLQuery(query URLQuery) (URLQuery, *urlCursor, error) {
+	if query.SortBy == "" {
+		query.SortBy = SortByCreatedAt
+	}
+	if query.SortBy != SortByCreatedAt && query.SortBy != SortByOriginal {
+		return query, nil, fmt.Errorf("%w: unknown sort field %s", ErrInvalidQuery, query.SortBy)
+	}
+	if query.Domain != "" {
+		domain, err := normalizeHost(strings.ToLower(query.Domain))
+		if err != nil {
+			return query, nil, fmt.Errorf("%w: domain %v", ErrInvalidQuery, err)
+		}
+		query.Domain = domain
+	}
+	if query.Cursor == "" {
+		return query, nil, nil
+	}
+	cursor, err := decodeURLCursor(query.Cursor)
+	if err != nil || cursor.SortBy != query.SortBy || cursor.Desc != query.Desc {
+		return query, nil, ErrInvalidCursor
+	}
+	return query, &cursor, nil
+}
+
+// QueryURLs - фильтрация, сортировка и выборка страницы ссылок пользователя.
+// Курсор указывает на последнюю ссылку страницы, поэтому новые и удаленные ссылки не сдвигают следующие страницы.
+func QueryURLs(urls []ShortHashURL, query URLQuery) (URLPage, error) {
+	query, cursor, err := prepareURLQuery(query)
+	if err != nil {
+		return URLPage{}, err
+	}
+
+	compare := func(a, b ShortHashURL) int {
+		var result int
+		if query.SortBy == SortByOriginal {
+			result = cmp.Compare(a.OriginalURL, b.OriginalURL)
+		} else {
+			result = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		// Короткая ссылка уникальна и задает однозначный порядок при равных значениях
+		if result == 0 {
+			result = cmp.Compare(a.ShortHash, b.ShortHash)
+		}
+		if query.Desc {
+			return -result
+		}
+		return result
+	}
+
+	var after *ShortHashURL
+	if cursor != nil {
+		after = &ShortHashURL{ShortHash: cursor.ShortHash, OriginalURL: cursor.Original, CreatedAt: cursor.CreatedAt}
+	}
+
+	var selected []ShortHashURL
+	for _, item := range urls {
+		if query.Deleted != nil && item.Deleted != *query.Deleted {
+			continue
+		}
+		if query.Expired != nil && item.Expired != *query.Expired {
+			continue
+		}
+		if query.Domain != "" && !matchURLDomain(item.OriginalURL, query.Domain) {
+			continue
+		}
+		if !hasTags(item.Tags, query.Tags) {
+			continue
+		}
+		if after != nil && compare(item, *after) <= 0 {
+			continue
+		}
+		selected = append(selected, item)
+	}
+	slices.SortFunc(selected, compare)
+
+	return pageURLs(selected, query), nil
+}
+
+// pageURLs - страница из отсортированных ссылок, выбранных с запасом на одну для признака следующей страницы.
+func pageURLs(selected []ShortHashURL, query URLQuery) URLPage {
+	page := URLPage{URLs: selected}
+	if query.Limit > 0 && len(selected) > query.Limit {
+		page.URLs = selected[:query.Limit]
+		last := page.URLs[len(page.URLs)-1]
+		page.NextCursor = encodeURLCursor(urlCursor{
+			SortBy: query.SortBy, Desc: query.Desc, ShortHash: last.ShortHash,
+			Original: last.OriginalURL, CreatedAt: last.CreatedAt,
+		})
+	}
+	return page
+}
+
+// matchURLDomain - ведет ли ссылка на хост domain или его поддомен.
+func matchURLDomain(originalURL string, domain string) bool {
+	parsed, err := url.Parse(originalURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// encodeURLCursor - курсор в виде строки для параметра запроса.
+func encodeURLCursor(cursor urlCursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// decodeURLCursor - разбор курсора из параметра запроса.
+func decodeURLCursor(value string) (urlCursor, error) {
+	var cursor urlCursor
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(payload, &cursor)
+	return cursor, err
+}
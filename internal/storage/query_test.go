@@ -0,0 +1,108 @@
+// Модуль содержит тесты выборки ссылок пользователя по страницам
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryURLs(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	urls := []ShortHashURL{
+		{ShortHash: "c", OriginalURL: "https://b.example.com/", CreatedAt: base.Add(2 * time.Hour)},
+		{ShortHash: "a", OriginalURL: "https://yandex.ru/", CreatedAt: base},
+		{ShortHash: "d", OriginalURL: "https://example.com/x", CreatedAt: base.Add(3 * time.Hour), Deleted: true},
+		{ShortHash: "b", OriginalURL: "https://a.ru/", CreatedAt: base.Add(time.Hour), Expired: true},
+		{ShortHash: "e", OriginalURL: "https://notexample.com/", CreatedAt: base.Add(time.Hour)},
+	}
+	shorts := func(page URLPage) []string {
+		var output []string
+		for _, item := range page.URLs {
+			output = append(output, item.ShortHash)
+		}
+		return output
+	}
+
+	page, err := QueryURLs(urls, URLQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "e", "c", "d"}, shorts(page))
+	assert.Empty(t, page.NextCursor)
+
+	// Обход страниц по курсору
+	var all []string
+	query := URLQuery{Limit: 2, SortBy: SortByOriginal, Desc: true}
+	for {
+		page, err = QueryURLs(urls, query)
+		assert.NoError(t, err)
+		all = append(all, shorts(page)...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"a", "e", "d", "c", "b"}, all)
+
+	// Курсор другой сортировки не принимается
+	page, err = QueryURLs(urls, URLQuery{Limit: 2})
+	assert.NoError(t, err)
+	_, err = QueryURLs(urls, URLQuery{Limit: 2, Cursor: page.NextCursor, Desc: true})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = QueryURLs(urls, URLQuery{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = QueryURLs(urls, URLQuery{SortBy: "short"})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+
+	notDeleted, expired := false, true
+	page, err = QueryURLs(urls, URLQuery{Deleted: &notDeleted, Domain: "Example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, shorts(page))
+	page, err = QueryURLs(urls, URLQuery{Expired: &expired})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, shorts(page))
+}
+
+func TestQueryByUserInPostgres(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	userUID := uuid.New().String()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	columns := []string{"short", "original", "created_at", "expires_at", "expired", "deleted", "tags", "preview", "redirect_type", "query_rules", "notes"}
+	notDeleted := false
+
+	// Страница выбирается с запасом на одну ссылку, фильтры и сортировка - в SQL
+	mockDB.ExpectQuery(`COALESCE\(deleted, false\) = \$2 AND .* = \$3 OR .* LIKE \$4\) AND .* @> \$5::text\[\]\s+ORDER BY original COLLATE "C" DESC, short COLLATE "C" DESC LIMIT \$6`).
+		WithArgs(userUID, false, "example.com", "%.example.com", []string{"work"}, 2).
+		WillReturnRows(pgxmock.NewRows(columns).
+			AddRow("hash2", "https://example.com/b", createdAt, (*time.Time)(nil), false, false, []string{"work"}, false, 0, "", "").
+			AddRow("hash1", "https://example.com/a", createdAt, (*time.Time)(nil), false, false, []string{"work"}, false, 0, "", ""))
+
+	query := URLQuery{Limit: 1, SortBy: SortByOriginal, Desc: true, Deleted: &notDeleted, Domain: "Example.com", Tags: []string{"work"}}
+	page, err := storage.QueryByUser(context.Background(), userUID, query)
+	assert.NoError(t, err)
+	assert.Len(t, page.URLs, 1)
+	assert.Equal(t, "hash2", page.URLs[0].ShortHash)
+	assert.NotEmpty(t, page.NextCursor)
+
+	// Следующая страница начинается после последней ссылки курсора
+	mockDB.ExpectQuery(`\(original COLLATE "C", short COLLATE "C"\) < \(\$6, \$7\)`).
+		WithArgs(userUID, false, "example.com", "%.example.com", []string{"work"}, "https://example.com/b", "hash2", 2).
+		WillReturnRows(pgxmock.NewRows(columns).
+			AddRow("hash1", "https://example.com/a", createdAt, (*time.Time)(nil), false, false, []string{"work"}, false, 0, "", ""))
+
+	query.Cursor = page.NextCursor
+	page, err = storage.QueryByUser(context.Background(), userUID, query)
+	assert.NoError(t, err)
+	assert.Len(t, page.URLs, 1)
+	assert.Equal(t, "hash1", page.URLs[0].ShortHash)
+	assert.Empty(t, page.NextCursor)
+
+	// Курсор другой сортировки отклоняется без запроса к базе
+	_, err = storage.QueryByUser(context.Background(), userUID, URLQuery{Cursor: query.Cursor})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,221 @@
+// Модуль содержит правила объединения параметров запроса при перенаправлении по ссылке.
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Стороны, параметр которых остается при совпадении имен.
+const (
+	QueryConflictLink    = "link"    // параметр ссылки, параметр запроса перехода отбрасывается
+	QueryConflictRequest = "request" // параметр запроса перехода заменяет все значения параметра ссылки
+)
+
+// maxQueryParams - предельное количество добавляемых параметров.
+const maxQueryParams = 20
+
+// QueryRules - правила объединения параметров запроса при перенаправлении по ссылке.
+type QueryRules struct {
+	Passthrough *bool             `json:"passthrough,omitempty"` // передавать параметры запроса перехода, nil - как в общих правилах
+	Params      map[string]string `json:"params,omitempty"`      // добавляемые параметры, например utm_source
+	Conflict    string            `json:"conflict,omitempty"`    // сторона при совпадении имен, пусто - как в общих правилах
+}
+
+// IsZero - правила ничего не задают.
+func (r QueryRules) IsZero() bool {
+	return r.Passthrough == nil && len(r.Params) == 0 && r.Conflict == ""
+}
+
+// Validate - проверка правил: непустые имена параметров, не больше maxQueryParams параметров, известная сторона Conflict.
+func (r QueryRules) Validate() error {
+	if len(r.Params) > maxQueryParams {
+		return fmt.Errorf("more than %d query params", maxQueryParams)
+	}
+	for name := range r.Params {
+		if name == "" {
+			return fmt.Errorf("empty query param name")
+		}
+	}
+	switch r.Conflict {
+	case "", QueryConflictLink, QueryConflictRequest:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict side %q: must be %s or %s", r.Conflict, QueryConflictLink, QueryConflictRequest)
+}
+
+// Merge - правила ссылки link поверх общих правил.
+// Заданные в ссылке Passthrough и Conflict заменяют общие, параметры объединяются,
+// пустое значение параметра ссылки отключает общий параметр с тем же именем.
+func (r QueryRules) Merge(link *QueryRules) QueryRules {
+	if link == nil {
+		return r
+	}
+	merged := r
+	if link.Passthrough != nil {
+		merged.Passthrough = link.Passthrough
+	}
+	if link.Conflict != "" {
+		merged.Conflict = link.Conflict
+	}
+	if len(link.Params) > 0 {
+		merged.Params = maps.Clone(r.Params)
+		if merged.Params == nil {
+			merged.Params = make(map[string]string, len(link.Params))
+		}
+		for name, value := range link.Params {
+			if value == "" {
+				delete(merged.Params, name)
+				continue
+			}
+			merged.Params[name] = value
+		}
+	}
+	return merged
+}
+
+// queryPair - параметр запроса: декодированное имя и исходная запись name=value.
+type queryPair struct {
+	name string
+	raw  string
+}
+
+// splitQuery - параметры ссылки в исходной записи, чтобы не менять их кодирование.
+func splitQuery(rawQuery string) []queryPair {
+	var pairs []queryPair
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		pairs = append(pairs, queryPair{name: name, raw: raw})
+	}
+	return pairs
+}
+
+// parseRequestQuery - параметры запроса перехода, заново закодированные.
+// Параметры с пустым именем или ошибкой кодирования отбрасываются.
+func parseRequestQuery(rawQuery string) []queryPair {
+	var pairs []queryPair
+	for _, raw := range strings.Split(rawQuery, "&") {
+		rawName, rawValue, hasValue := strings.Cut(raw, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil || name == "" {
+			continue
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			continue
+		}
+		pair := queryPair{name: name, raw: url.QueryEscape(name)}
+		if hasValue {
+			pair.raw += "=" + url.QueryEscape(value)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// Apply - итоговая ссылка перенаправления на originURL для запроса перехода с параметрами requestQuery.
+// К параметрам ссылки добавляются Params в порядке имен, кроме уже заданных в ссылке,
+// затем при Passthrough - параметры запроса перехода. При совпадении имен параметров перехода
+// с параметрами ссылки или Params остается сторона Conflict, по умолчанию ссылка.
+// Параметры ссылки сохраняют исходное кодирование, фрагмент остается в конце.
+func (r QueryRules) Apply(originURL string, requestQuery string) string {
+	passthrough := r.Passthrough != nil && *r.Passthrough && requestQuery != ""
+	if len(r.Params) == 0 && !passthrough {
+		return originURL
+	}
+
+	base, fragment, hasFragment := strings.Cut(originURL, "#")
+	base, rawQuery, _ := strings.Cut(base, "?")
+	pairs := splitQuery(rawQuery)
+	linkNames := make(map[string]bool, len(pairs)+len(r.Params))
+	for _, pair := range pairs {
+		linkNames[pair.name] = true
+	}
+	names := make([]string, 0, len(r.Params))
+	for name := range r.Params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if linkNames[name] {
+			continue
+		}
+		linkNames[name] = true
+		pairs = append(pairs, queryPair{name: name, raw: url.QueryEscape(name) + "=" + url.QueryEscape(r.Params[name])})
+	}
+
+	if passthrough {
+		requestPairs := parseRequestQuery(requestQuery)
+		if r.Conflict == QueryConflictRequest {
+			requestNames := make(map[string]bool, len(requestPairs))
+			for _, pair := range requestPairs {
+				requestNames[pair.name] = true
+			}
+			pairs = slices.DeleteFunc(pairs, func(pair queryPair) bool { return requestNames[pair.name] })
+		} else {
+			requestPairs = slices.DeleteFunc(requestPairs, func(pair queryPair) bool { return linkNames[pair.name] })
+		}
+		pairs = append(pairs, requestPairs...)
+	}
+
+	var result strings.Builder
+	result.WriteString(base)
+	for i, pair := range pairs {
+		if i == 0 {
+			result.WriteByte('?')
+		} else {
+			result.WriteByte('&')
+		}
+		result.WriteString(pair.raw)
+	}
+	if hasFragment {
+		result.WriteByte('#')
+		result.WriteString(fragment)
+	}
+	return result.String()
+}
+
+// ParseQueryParams - добавляемые параметры из записи запроса name=value&..., для повторного имени берется первое значение.
+func ParseQueryParams(rawQuery string) (map[string]string, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]string, len(values))
+	for name, value := range values {
+		params[name] = value[0]
+	}
+	return params, nil
+}
+
+// encodeQueryRules - правила ссылки в JSON для БД, пусто для nil.
+func encodeQueryRules(rules *QueryRules) string {
+	if rules == nil {
+		return ""
+	}
+	// Строки, флаг и словарь строк сериализуются без ошибок
+	data, _ := json.Marshal(rules)
+	return string(data)
+}
+
+// decodeQueryRules - правила ссылки из JSON БД, nil для пустой строки.
+func decodeQueryRules(data string) (*QueryRules, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var rules QueryRules
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
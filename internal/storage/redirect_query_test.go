@@ -0,0 +1,99 @@
+// Модуль содержит тесты правил параметров запроса при перенаправлении
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryRulesApply(t *testing.T) {
+	on, off := true, false
+	utm := map[string]string{"utm_source": "short", "utm_medium": "link"}
+
+	testCases := []struct {
+		name     string
+		rules    QueryRules
+		origin   string
+		request  string
+		expected string
+	}{
+		{name: "без правил запрос отбрасывается", origin: "https://ya.ru/a?x=1", request: "y=2",
+			expected: "https://ya.ru/a?x=1"},
+		{name: "передача запроса", rules: QueryRules{Passthrough: &on}, origin: "https://ya.ru/a", request: "y=2&z",
+			expected: "https://ya.ru/a?y=2&z"},
+		{name: "передача выключена", rules: QueryRules{Passthrough: &off, Params: utm}, origin: "https://ya.ru/", request: "y=2",
+			expected: "https://ya.ru/?utm_medium=link&utm_source=short"},
+		{name: "пустой запрос", rules: QueryRules{Passthrough: &on}, origin: "https://ya.ru/a?x=1#top", request: "",
+			expected: "https://ya.ru/a?x=1#top"},
+		{name: "фрагмент остается в конце", rules: QueryRules{Passthrough: &on, Params: utm},
+			origin: "https://ya.ru/docs?x=1#section-2?not=query", request: "y=2",
+			expected: "https://ya.ru/docs?x=1&utm_medium=link&utm_source=short&y=2#section-2?not=query"},
+		{name: "пустой фрагмент", rules: QueryRules{Params: map[string]string{"a": "1"}}, origin: "https://ya.ru/#",
+			expected: "https://ya.ru/?a=1#"},
+		{name: "ссылка с пустым запросом", rules: QueryRules{Passthrough: &on}, origin: "https://ya.ru/?", request: "y=2",
+			expected: "https://ya.ru/?y=2"},
+		{name: "параметры ссылки не перекодируются", rules: QueryRules{Params: utm},
+			origin:   "https://ya.ru/search?text=%D0%BF%D1%80%D0%B8%D0%B2%D0%B5%D1%82&q=a+b&flag",
+			expected: "https://ya.ru/search?text=%D0%BF%D1%80%D0%B8%D0%B2%D0%B5%D1%82&q=a+b&flag&utm_medium=link&utm_source=short"},
+		{name: "кодирование добавленных параметров", rules: QueryRules{Params: map[string]string{"utm campaign": "весна & лето=2024"}},
+			origin:   "https://ya.ru/",
+			expected: "https://ya.ru/?utm+campaign=%D0%B2%D0%B5%D1%81%D0%BD%D0%B0+%26+%D0%BB%D0%B5%D1%82%D0%BE%3D2024"},
+		{name: "кодирование параметров запроса", rules: QueryRules{Passthrough: &on}, origin: "https://ya.ru/",
+			request:  "q=a%20b&next=https://x.ru/?a=1&bad=%zz&=empty&%E2%9C%93=1",
+			expected: "https://ya.ru/?q=a+b&next=https%3A%2F%2Fx.ru%2F%3Fa%3D1&%E2%9C%93=1"},
+		{name: "параметр ссылки не заменяется добавленным", rules: QueryRules{Params: utm},
+			origin:   "https://ya.ru/?utm_source=newsletter",
+			expected: "https://ya.ru/?utm_source=newsletter&utm_medium=link"},
+		{name: "при совпадении побеждает ссылка", rules: QueryRules{Passthrough: &on, Params: utm},
+			origin: "https://ya.ru/?x=1", request: "x=2&utm_source=ads&y=3",
+			expected: "https://ya.ru/?x=1&utm_medium=link&utm_source=short&y=3"},
+		{name: "при совпадении побеждает запрос", rules: QueryRules{Passthrough: &on, Params: utm, Conflict: QueryConflictRequest},
+			origin: "https://ya.ru/?x=1&x=0&keep=1", request: "x=2&utm_source=ads&x=3",
+			expected: "https://ya.ru/?keep=1&utm_medium=link&x=2&utm_source=ads&x=3"},
+		{name: "закодированное имя параметра ссылки", rules: QueryRules{Passthrough: &on},
+			origin: "https://ya.ru/?utm%5Fsource=a", request: "utm_source=b",
+			expected: "https://ya.ru/?utm%5Fsource=a"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.rules.Apply(tc.origin, tc.request))
+		})
+	}
+}
+
+func TestQueryRulesMerge(t *testing.T) {
+	on, off := true, false
+	global := QueryRules{Passthrough: &on, Params: map[string]string{"utm_source": "short", "utm_medium": "link"}}
+
+	assert.Equal(t, global, global.Merge(nil))
+	merged := global.Merge(&QueryRules{Passthrough: &off, Conflict: QueryConflictRequest,
+		Params: map[string]string{"utm_medium": "", "utm_campaign": "spring"}})
+	assert.Equal(t, QueryRules{Passthrough: &off, Conflict: QueryConflictRequest,
+		Params: map[string]string{"utm_source": "short", "utm_campaign": "spring"}}, merged)
+	// Общие правила не меняются
+	assert.Equal(t, map[string]string{"utm_source": "short", "utm_medium": "link"}, global.Params)
+
+	assert.Equal(t, QueryRules{Params: map[string]string{"a": "1"}}, QueryRules{}.Merge(&QueryRules{Params: map[string]string{"a": "1"}}))
+	assert.True(t, QueryRules{}.IsZero())
+	assert.False(t, QueryRules{Conflict: QueryConflictLink}.IsZero())
+}
+
+func TestQueryRulesValidate(t *testing.T) {
+	assert.NoError(t, QueryRules{}.Validate())
+	assert.NoError(t, QueryRules{Conflict: QueryConflictRequest, Params: map[string]string{"utm_source": "x"}}.Validate())
+	assert.Error(t, QueryRules{Conflict: "both"}.Validate())
+	assert.Error(t, QueryRules{Params: map[string]string{"": "x"}}.Validate())
+	tooMany := make(map[string]string)
+	for i := 0; i <= maxQueryParams; i++ {
+		tooMany[uuid.New().String()] = "x"
+	}
+	assert.Error(t, QueryRules{Params: tooMany}.Validate())
+
+	params, err := ParseQueryParams("utm_source=short&utm_medium=link&utm_source=other")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"utm_source": "short", "utm_medium": "link"}, params)
+	_, err = ParseQueryParams("a=%zz")
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+// Модуль содержит общие функции поиска по ссылкам пользователя.
+package storage
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// searchWords - слова поискового запроса в нижнем регистре.
+func searchWords(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// searchTrigrams - различные тройки подряд идущих символов текста в нижнем регистре, ключи обратного индекса.
+// Слово запроса входит в текст, только если в тексте есть все его триграммы, короче трех символов триграмм нет.
+func searchTrigrams(text string) []string {
+	runes := []rune(strings.ToLower(text))
+	seen := make(map[string]struct{})
+	var output []string
+	for i := 0; i+3 <= len(runes); i++ {
+		trigram := string(runes[i : i+3])
+		if _, exists := seen[trigram]; !exists {
+			seen[trigram] = struct{}{}
+			output = append(output, trigram)
+		}
+	}
+	return output
+}
+
+// matchSearch - каждое слово запроса входит в оригинальную ссылку, короткий код или заметку без учета регистра.
+func matchSearch(words []string, originalURL string, shortHash string, notes string) bool {
+	fields := []string{strings.ToLower(originalURL), strings.ToLower(shortHash), strings.ToLower(notes)}
+	for _, word := range words {
+		if !slices.ContainsFunc(fields, func(field string) bool { return strings.Contains(field, word) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// limitSearchResults - результаты поиска от новых к старым, не больше limit, 0 - без ограничения.
+func limitSearchResults(results []ShortHashURL, limit int) []ShortHashURL {
+	slices.SortFunc(results, func(a, b ShortHashURL) int {
+		if result := b.CreatedAt.Compare(a.CreatedAt); result != 0 {
+			return result
+		}
+		return cmp.Compare(a.ShortHash, b.ShortHash)
+	})
+	if limit > 0 && len(results) > limit {
+		return results[:limit]
+	}
+	return results
+}
+
+// escapeLike - экранирование спецсимволов шаблона LIKE.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
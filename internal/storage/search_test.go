@@ -0,0 +1,88 @@
+// Модуль содержит тесты поиска по ссылкам пользователя
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSearch - поиск по словам запроса в хранилищах в памяти и на диске.
+func TestSearch(t *testing.T) {
+	inMemoryStorage, _ := NewStorageInMemory(testLengthShortURL)
+	defer inMemoryStorage.Close()
+	diskStorage, err := NewStorageOnDisk(filepath.Join(t.TempDir(), "urls.log"), testLengthShortURL)
+	assert.NoError(t, err)
+	defer diskStorage.Close()
+
+	for name, mainStorage := range map[string]Storage{"memory": inMemoryStorage, "disk": diskStorage} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			userUID := uuid.New().String()
+			pricing, _ := mainStorage.Save(ctx, "https://shop.example.com/pricing-page", userUID)
+			mainStorage.Save(ctx, "https://example.com/docs/Pricing", userUID)
+			mainStorage.SaveLink(ctx, "https://yandex.ru/", "promo-2024", userUID, LinkOptions{Notes: "Spring sale landing"})
+			mainStorage.Save(ctx, "https://shop.example.com/pricing-old", uuid.New().String())
+			deletedShort, _ := mainStorage.Save(ctx, "https://pricing.example.org/", userUID)
+			mainStorage.DeleteByUser(ctx, []string{deletedShort}, userUID)
+
+			shorts := func(query string, limit int) []string {
+				found, err := mainStorage.SearchByUser(ctx, userUID, query, limit)
+				assert.NoError(t, err)
+				var output []string
+				for _, item := range found {
+					output = append(output, item.ShortHash)
+				}
+				return output
+			}
+
+			assert.Len(t, shorts("pricing", 0), 2)
+			assert.Len(t, shorts("PRIC", 1), 1)
+			assert.Equal(t, []string{pricing}, shorts("pricing shop", 0))
+			assert.Equal(t, []string{pricing}, shorts("shop.example.com/pric", 0))
+			assert.Equal(t, []string{"promo-2024"}, shorts("promo", 0))
+			assert.Equal(t, []string{"promo-2024"}, shorts("SALE landing yandex", 0))
+			assert.Empty(t, shorts("pricing yandex", 0))
+			assert.Empty(t, shorts("example.org", 0))
+			// Слово короче триграммы проверяется по всем ссылкам пользователя
+			assert.Len(t, shorts("/p", 0), 2)
+
+			// Измененная ссылка ищется по новому адресу
+			assert.NoError(t, mainStorage.UpdateByUser(ctx, pricing, LinkPatch{OriginalURL: "https://shop.example.com/tariffs"}, userUID))
+			assert.Len(t, shorts("pricing", 0), 1)
+			assert.Len(t, shorts("tariffs", 0), 1)
+
+			// Измененная заметка ищется по новому тексту
+			notes := "winter tariffs"
+			assert.NoError(t, mainStorage.UpdateByUser(ctx, "promo-2024", LinkPatch{Notes: &notes}, userUID))
+			assert.Empty(t, shorts("sale", 0))
+			assert.Len(t, shorts("tariffs", 0), 2)
+		})
+	}
+}
+
+func TestSearchInPostgres(t *testing.T) {
+	storage, mockDB, cleanup := setupMockDB(t)
+	defer cleanup()
+	userUID := uuid.New().String()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockDB.ExpectQuery(`original ILIKE \$2 OR short ILIKE \$2 OR notes ILIKE \$2\) AND \(original ILIKE \$3 OR short ILIKE \$3 OR notes ILIKE \$3\)`).
+		WithArgs(userUID, "%pricing%", `%100\%%`, 10).
+		WillReturnRows(pgxmock.NewRows([]string{"short", "original", "created_at", "expires_at", "expired", "tags", "preview", "redirect_type", "query_rules", "notes"}).
+			AddRow("hash1", "https://example.com/pricing?off=100%", createdAt, (*time.Time)(nil), false, []string{"sales"}, false, 0, "", "spring pricing"))
+
+	found, err := storage.SearchByUser(context.Background(), userUID, "Pricing 100%", 10)
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, "hash1", found[0].ShortHash)
+	assert.True(t, createdAt.Equal(found[0].CreatedAt))
+	assert.Equal(t, []string{"sales"}, found[0].Tags)
+	assert.Equal(t, "spring pricing", found[0].Notes)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
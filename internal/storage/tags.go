@@ -0,0 +1,60 @@
+// Модуль содержит проверку тегов ссылок.
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Ограничения тегов ссылки.
+const (
+	maxTagLength   = 64
+	MaxTagsPerLink = 20
+)
+
+// tagPattern - допустимые символы тега, / разделяет вложенные папки, например work/reports.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]+(/[\p{L}\p{N}_-]+)*$`)
+
+// InvalidTagError - тег не может быть назначен ссылке.
+type InvalidTagError struct {
+	Tag    string
+	Reason string
+}
+
+// Error - реализация метода.
+func (te *InvalidTagError) Error() string {
+	return fmt.Sprintf("invalid tag %q: %s", te.Tag, te.Reason)
+}
+
+// NormalizeTags - проверка тегов и приведение к нижнему регистру без повторов в порядке сортировки.
+func NormalizeTags(tags []string) ([]string, error) {
+	output := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		value := strings.ToLower(strings.TrimSpace(tag))
+		if value == "" || len(value) > maxTagLength {
+			return nil, &InvalidTagError{Tag: tag, Reason: fmt.Sprintf("length must be from 1 to %d", maxTagLength)}
+		}
+		if !tagPattern.MatchString(value) {
+			return nil, &InvalidTagError{Tag: tag, Reason: "only letters, digits, _, - and / between folders are allowed"}
+		}
+		output = append(output, value)
+	}
+	slices.Sort(output)
+	output = slices.Compact(output)
+	if len(output) > MaxTagsPerLink {
+		return nil, &InvalidTagError{Tag: output[MaxTagsPerLink], Reason: fmt.Sprintf("at most %d tags per link", MaxTagsPerLink)}
+	}
+	return output, nil
+}
+
+// hasTags - есть ли у ссылки все теги из wanted.
+func hasTags(tags []string, wanted []string) bool {
+	for _, tag := range wanted {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+// Модуль содержит тесты тегов ссылок
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := NormalizeTags([]string{" Work/Reports ", "news", "work/reports", "Отчеты"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"news", "work/reports", "отчеты"}, tags)
+
+	for _, tag := range []string{"", "two words", "work//reports", "/work", "tag?"} {
+		_, err = NormalizeTags([]string{tag})
+		var te *InvalidTagError
+		assert.ErrorAs(t, err, &te, tag)
+	}
+
+	tooMany := make([]string, MaxTagsPerLink+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag%02d", i)
+	}
+	_, err = NormalizeTags(tooMany)
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@
 package alltests
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"github.com/google/uuid"
@@ -48,9 +49,9 @@ func BenchmarkStorageInMemory(b *testing.B) {
 			rndURL := generateRandomLink(lengthURL)
 			b.StartTimer() // возобновляем таймер
 
-			shortURL, err := mainStorage.Save(rndURL, rndUUID)
+			shortURL, err := mainStorage.Save(context.Background(), rndURL, rndUUID)
 			assert.NoError(b, err)
-			foundURL, found := mainStorage.Get(shortURL)
+			foundURL, found := mainStorage.Get(context.Background(), shortURL)
 			assert.Equal(b, found, true)
 			assert.Equal(b, foundURL, rndURL)
 		}